@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/gin-gonic/gin"
+)
+
+// the routes admins use to manage other users' accounts and invoices
+// every route goes through protectData and requireRole(admin)
+func (srv *Server) adminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", srv.protectData, requireRole(accts.RoleAdmin))
+	{
+		admin.GET("/users", srv.adminReadUsers)                            // lists every account and its role
+		admin.GET("/users/:id", srv.adminReadUser)                         // reads an account
+		admin.PUT("/users/:id/role", srv.adminSetRole)                     // changes an account's role
+		admin.DELETE("/users/:id", srv.adminDeleteUser)                    // deletes an account
+		admin.DELETE("/users/:id/2fa", srv.adminRemoveTOTP)                // turns off an account's two-factor login
+		admin.GET("/users/:id/invoices", srv.adminReadInvoices)            // reads a user's invoices
+		admin.PUT("/users/:id/invoices/:invID", srv.adminUpdateInvoice)    // updates a user's entire invoice
+		admin.PATCH("/users/:id/invoices/:invID", srv.adminPatchInvoice)   // updates any field of a user's invoice
+		admin.DELETE("/users/:id/invoices/:invID", srv.adminDeleteInvoice) // deletes a user's invoice
+		admin.GET("/lockouts", srv.adminReadLockouts)                      // lists usernames and ips with failed logins
+		admin.DELETE("/lockouts/:kind/:key", srv.adminClearLockout)        // lifts a lockout
+	}
+}
+
+// validates an id route parameter, what names the resource in the error msg
+func validateRouteParamID(c *gin.Context, param, what string, fieldErr *fields.GrammarError) int {
+	id, err := strconv.Atoi(c.Param(param))
+	if err != nil {
+		fieldErr.AddMsg(fields.BadRequest, param, fields.CodeInvalidID,
+			"Bad Request: "+what+" id can't be converted to an integer")
+	}
+	return id
+}
+
+// meant to be binded to a role change
+type roleRqst struct {
+	Role string `json:"role" form:"role"`
+}
+
+// returns every account along with its role
+func (srv *Server) adminReadUsers(c *gin.Context) {
+	accounts, fieldErr := srv.accounts.ReadAccounts(c.Request.Context())
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, accounts)
+}
+
+// returns the account given its user id
+func (srv *Server) adminReadUser(c *gin.Context) {
+	var fieldErr fields.GrammarError
+	userID := validateRouteParamID(c, "id", "user", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	accounts, fieldErr := srv.accounts.ReadAccountByID(c.Request.Context(), userID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, accounts[0])
+}
+
+// changes the role of the account given its user id
+func (srv *Server) adminSetRole(c *gin.Context) {
+	var fieldErr fields.GrammarError
+	userID := validateRouteParamID(c, "id", "user", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	var rqst roleRqst
+	if err := c.ShouldBind(&rqst); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "role", fields.CodeInvalidBody,
+			"Binding Error: role must be a string")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	usrs, fieldErr := srv.accounts.SetRole(c.Request.Context(), userID, rqst.Role)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, usrs[0])
+}
+
+// deletes the account given its user id
+// which cascades to delete their invoices too
+func (srv *Server) adminDeleteUser(c *gin.Context) {
+	var fieldErr fields.GrammarError
+	userID := validateRouteParamID(c, "id", "user", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	user := srv.accounts.ReadUsernameByID(c.Request.Context(), userID, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	rmvUser, fieldErr := srv.accounts.DeleteAcct(c.Request.Context(), *user[0])
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, gin.H{
+		"message": fmt.Sprintf("User: %s has been deleted", rmvUser[0].Username),
+	})
+}
+
+// turns off two-factor login for a user who lost their device
+func (srv *Server) adminRemoveTOTP(c *gin.Context) {
+	var fieldErr fields.GrammarError
+	userID := validateRouteParamID(c, "id", "user", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	fieldErr = srv.accounts.RemoveTOTP(c.Request.Context(), userID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, gin.H{
+		"message": fmt.Sprintf("two-factor login disabled for user %d", userID),
+	})
+}
+
+// returns every invoice of the user given their id
+func (srv *Server) adminReadInvoices(c *gin.Context) {
+	var fieldErr fields.GrammarError
+	userID := validateRouteParamID(c, "id", "user", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	invLst, fieldErr := srv.invoices.ReadInvoicesByUserID(c.Request.Context(), userID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, invLst)
+}
+
+// validates both the user id and invoice id route parameters
+func adminRouteIDs(c *gin.Context) (int, int, bool) {
+	var fieldErr fields.GrammarError
+	userID := validateRouteParamID(c, "id", "user", &fieldErr)
+	invID := validateRouteParamID(c, "invID", "invoice", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return 0, 0, false
+	}
+	return userID, invID, true
+}
+
+// updates every field of a user's invoice
+func (srv *Server) adminUpdateInvoice(c *gin.Context) {
+	userID, invID, ok := adminRouteIDs(c)
+	if !ok {
+		return
+	}
+
+	var rqstData respBodyData
+	inv, bindingOk := validateInvoiceBinding(c, &rqstData)
+	if !bindingOk {
+		return
+	}
+
+	rqstData.Invs, rqstData.FieldErr = srv.invoices.UpdateInvoiceByUserID(c.Request.Context(), inv, userID, invID)
+	if rqstData.FieldErr.ErrMsgs != nil {
+		sendProblem(c, rqstData.FieldErr)
+		return
+	}
+	c.JSON(statusOK, rqstData.Invs[0])
+}
+
+// updates the given fields of a user's invoice
+func (srv *Server) adminPatchInvoice(c *gin.Context) {
+	userID, invID, ok := adminRouteIDs(c)
+	if !ok {
+		return
+	}
+
+	var rqstData respBodyData
+	inv, bindingOk := validateInvoiceBinding(c, &rqstData)
+	if !bindingOk {
+		return
+	}
+
+	rqstData.Invs, rqstData.FieldErr = srv.invoices.PatchInvoice(c.Request.Context(), inv, userID, invID)
+	if rqstData.FieldErr.ErrMsgs != nil {
+		sendProblem(c, rqstData.FieldErr)
+		return
+	}
+	c.JSON(statusOK, rqstData.Invs[0])
+}
+
+// deletes a user's invoice
+func (srv *Server) adminDeleteInvoice(c *gin.Context) {
+	userID, invID, ok := adminRouteIDs(c)
+	if !ok {
+		return
+	}
+
+	invLst, fieldErr := srv.invoices.DeleteInvoice(c.Request.Context(), invID, userID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, invLst[0])
+}
+
+// returns every username and ip with failed logins counted against it
+func (srv *Server) adminReadLockouts(c *gin.Context) {
+	locks, fieldErr := srv.accounts.ReadLockouts(c.Request.Context())
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, locks)
+}
+
+// lifts the lockout of a username or ip given its kind and key
+func (srv *Server) adminClearLockout(c *gin.Context) {
+	locks, fieldErr := srv.accounts.ClearLockout(c.Request.Context(), c.Param("kind"), c.Param("key"))
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, locks[0])
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/totp"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/e2e with the responses")
+
+// response fields that change from run to run, their values
+// are swapped for a placeholder before comparing with the golden files
+var volatileFields = map[string]bool{
+	"token":              true,
+	"refresh_token":      true,
+	"expires_at":         true,
+	"refresh_expires_at": true,
+	"issued_at":          true,
+	"last_used_at":       true,
+	"created_at":         true,
+	"secret":             true,
+	"otpauth_uri":        true,
+	"key":                true,
+	"prefix":             true,
+	"recovery_codes":     true,
+}
+
+// the accounts the suite signs up with, staff is given the staff role
+var e2eAccounts = map[string]accts.Account{
+	"alice": {Fname: "Alice", Lname: "Pedal", Address: "12 Spoke St Chicago IL 60629", Username: "alicepedal", Password: "Sunflower7"},
+	"bob":   {Fname: "Bob", Lname: "Crank", Address: "9 Chain Rd Topeka KS 66603", Username: "bobcrank", Password: "Sunflower7"},
+	"staff": {Fname: "Sam", Lname: "Wrench", Address: "1 Shop Ave Boston MA 02108", Username: "samwrench", Password: "Sunflower7"},
+}
+
+// one request in the suite, checked against testdata/e2e/<name>.json
+// the path and body can use {var} for values saved from earlier responses
+// and {totp} for the current code of the saved secret
+type routeCase struct {
+	name   string
+	method string
+	path   string
+	as     string // the account whose token is sent, "apikey" sends the saved key instead
+	body   string
+	status int
+	save   func(vars map[string]string, body any) // keeps values later cases need
+}
+
+// saves a field of an object response as vars[name]
+func saveField(field, name string) func(map[string]string, any) {
+	return func(vars map[string]string, body any) {
+		if obj, ok := body.(map[string]any); ok {
+			vars[name] = jsonString(obj[field])
+		}
+	}
+}
+
+// saves the id of the first entry of a list response as vars[name]
+func saveFirstID(name string) func(map[string]string, any) {
+	return func(vars map[string]string, body any) {
+		if list, ok := body.([]any); ok && len(list) > 0 {
+			saveField("id", name)(vars, list[0])
+		}
+	}
+}
+
+func jsonString(val any) string {
+	if num, ok := val.(float64); ok {
+		return strconv.FormatFloat(num, 'f', -1, 64)
+	}
+	str, _ := val.(string)
+	return str
+}
+
+// swaps the values of volatile fields for a placeholder
+func normalize(val any) any {
+	switch val := val.(type) {
+	case map[string]any:
+		for k, v := range val {
+			if volatileFields[k] && v != nil {
+				val[k] = "<" + k + ">"
+				continue
+			}
+			val[k] = normalize(v)
+		}
+	case []any:
+		for i, v := range val {
+			val[i] = normalize(v)
+		}
+	}
+	return val
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// fills in the placeholders of a path or body
+func expand(t *testing.T, str string, vars map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(str, func(match string) string {
+		name := match[1 : len(match)-1]
+		if name == "totp" {
+			code, err := totp.Code(vars["secret"], time.Now())
+			if err != nil {
+				t.Fatalf("making a totp code: %v", err)
+			}
+			return code
+		}
+		val, ok := vars[name]
+		if !ok {
+			t.Fatalf("%s hasn't been saved by an earlier case", match)
+		}
+		return val
+	})
+}
+
+// every route in userGroup1, createInv and userGroup2, run in order
+// against the same stores so later cases see what earlier ones did
+var routeCases = []routeCase{
+	// userGroup1
+	{name: "users_no_token", method: "GET", path: "/users", status: 401},
+	{name: "users_bad_token", method: "GET", path: "/users", as: "forged", status: 401},
+	{name: "users_customer", method: "GET", path: "/users", as: "alice", status: 403},
+	{name: "users_staff", method: "GET", path: "/users", as: "staff", status: 200},
+	{name: "invoices_customer", method: "GET", path: "/invoices", as: "alice", status: 403},
+	{name: "invoices_staff_empty", method: "GET", path: "/invoices", as: "staff", status: 200},
+
+	// createInv
+	{name: "create_invoice_no_token", method: "POST", path: "/invoices/",
+		body: `{"product":"Peashooter","category":"Toy","price":20,"quantity":1}`, status: 401},
+	{name: "create_invoice", method: "POST", path: "/invoices/", as: "alice",
+		body: `{"product":"Peashooter","category":"Toy","price":20,"quantity":1}`, status: 201},
+	{name: "create_invoice_second", method: "POST", path: "/invoices/", as: "alice",
+		body: `{"product":"Door Hinges","category":"Home Improvement","price":12.5,"quantity":5}`, status: 201},
+	{name: "create_invoice_staff", method: "POST", path: "/invoices/", as: "staff",
+		body: `{"product":"Bike Lock","category":"Security","price":34.95,"quantity":1}`, status: 201},
+	{name: "create_invoice_invalid", method: "POST", path: "/invoices/", as: "alice",
+		body: `{"product":"Peashooter!","category":"Toy4","price":-3,"quantity":0}`, status: 400},
+	{name: "create_invoice_wrong_type", method: "POST", path: "/invoices/", as: "alice",
+		body: `{"product":"Peashooter","category":"Toy","price":"cheap","quantity":1}`, status: 400},
+	{name: "create_invoice_malformed", method: "POST", path: "/invoices/", as: "alice",
+		body: `{"product":"Peashooter",`, status: 400},
+	{name: "invoices_staff", method: "GET", path: "/invoices", as: "staff", status: 200},
+	{name: "invoices_page", method: "GET", path: "/invoices?limit=2&sort=-price", as: "staff", status: 200,
+		save: saveField("next", "next")},
+	{name: "invoices_next_page", method: "GET", path: "/invoices?limit=2&sort=-price&after={next}", as: "staff", status: 200},
+	{name: "invoices_filtered", method: "GET", path: "/invoices?category=toy&product=SHOOT&min_price=10&max_quantity=3", as: "staff", status: 200},
+	{name: "invoices_bad_query", method: "GET", path: "/invoices?limit=0&sort=colour&min_price=cheap", as: "staff", status: 400},
+	{name: "invoices_cursor_other_sort", method: "GET", path: "/invoices?sort=price&after={next}", as: "staff", status: 400},
+
+	// userGroup2, the profile
+	{name: "read_user", method: "GET", path: "/user", as: "alice", status: 200},
+	{name: "read_user_no_token", method: "GET", path: "/user", status: 401},
+	{name: "put_user", method: "PUT", path: "/user", as: "alice",
+		body: `{"fname":"Alicia","lname":"Pedal","address":"14 Spoke St Chicago IL 60629","username":"aliciapedal"}`, status: 200},
+	{name: "put_user_missing_fields", method: "PUT", path: "/user", as: "alice",
+		body: `{"fname":"Alicia"}`, status: 400},
+	{name: "put_user_taken_username", method: "PUT", path: "/user", as: "alice",
+		body: `{"fname":"Alicia","lname":"Pedal","address":"14 Spoke St Chicago IL 60629","username":"bobcrank"}`, status: 409},
+	{name: "patch_user", method: "PATCH", path: "/user", as: "alice",
+		body: `{"address":"16 Spoke St Chicago IL 60629"}`, status: 200},
+	{name: "patch_user_invalid", method: "PATCH", path: "/user", as: "alice",
+		body: `{"fname":"Al1cia"}`, status: 400},
+
+	// userGroup2, invoices
+	{name: "user_invoices", method: "GET", path: "/user/invoices", as: "alice", status: 200},
+	{name: "user_invoices_filtered", method: "GET", path: "/user/invoices?product=hinge", as: "alice", status: 200},
+	{name: "user_invoices_none", method: "GET", path: "/user/invoices", as: "bob", status: 200},
+	{name: "read_invoice", method: "GET", path: "/invoice/1", as: "alice", status: 200},
+	{name: "read_invoice_other_user", method: "GET", path: "/invoice/1", as: "bob", status: 404},
+	{name: "read_invoice_bad_id", method: "GET", path: "/invoice/one", as: "alice", status: 400},
+	{name: "read_invoice_no_token", method: "GET", path: "/invoice/1", status: 401},
+	{name: "update_invoice", method: "PUT", path: "/invoice/1", as: "alice",
+		body: `{"product":"Peashooter","category":"Toy","price":18.75,"quantity":2}`, status: 200},
+	{name: "update_invoice_invalid", method: "PUT", path: "/invoice/1", as: "alice",
+		body: `{"product":"","category":"Toy","price":18.75,"quantity":2}`, status: 400},
+	{name: "update_invoice_missing", method: "PUT", path: "/invoice/99", as: "alice",
+		body: `{"product":"Peashooter","category":"Toy","price":18.75,"quantity":2}`, status: 404},
+	{name: "patch_invoice", method: "PATCH", path: "/invoice/2", as: "alice",
+		body: `{"quantity":2}`, status: 200},
+	{name: "patch_invoice_invalid", method: "PATCH", path: "/invoice/2", as: "alice",
+		body: `{"category":"H0me"}`, status: 400},
+	{name: "patch_invoice_other_user", method: "PATCH", path: "/invoice/2", as: "bob",
+		body: `{"quantity":3}`, status: 404},
+	{name: "delete_invoice", method: "DELETE", path: "/invoice/2", as: "alice", status: 200},
+	{name: "delete_invoice_again", method: "DELETE", path: "/invoice/2", as: "alice", status: 404},
+	{name: "delete_invoice_bad_id", method: "DELETE", path: "/invoice/two", as: "alice", status: 400},
+
+	// userGroup2, sessions
+	{name: "sessions", method: "GET", path: "/sessions", as: "bob", status: 200, save: saveFirstID("bob_session")},
+	{name: "revoke_session_bad_id", method: "DELETE", path: "/sessions/one", as: "bob", status: 400},
+	{name: "revoke_session_missing", method: "DELETE", path: "/sessions/99", as: "bob", status: 404},
+	{name: "revoke_session_other_user", method: "DELETE", path: "/sessions/{bob_session}", as: "alice", status: 404},
+	{name: "revoke_session", method: "DELETE", path: "/sessions/{bob_session}", as: "bob", status: 200},
+	{name: "revoked_session_rejected", method: "GET", path: "/sessions", as: "bob", status: 401},
+
+	// userGroup2, passwords
+	{name: "change_password_missing", method: "PUT", path: "/user/password", as: "alice",
+		body: `{}`, status: 400},
+	{name: "change_password_wrong", method: "PUT", path: "/user/password", as: "alice",
+		body: `{"current_password":"Sunflower8","new_password":"Marigold42"}`, status: 403},
+	{name: "change_password_weak", method: "PUT", path: "/user/password", as: "alice",
+		body: `{"current_password":"Sunflower7","new_password":"marigold"}`, status: 400},
+	{name: "change_password", method: "PUT", path: "/user/password", as: "alice",
+		body: `{"current_password":"Sunflower7","new_password":"Marigold42"}`, status: 200},
+
+	// userGroup2, two-factor login
+	{name: "enroll_2fa", method: "POST", path: "/user/2fa", as: "alice", status: 200, save: saveField("secret", "secret")},
+	{name: "confirm_2fa_missing", method: "POST", path: "/user/2fa/confirm", as: "alice", body: `{}`, status: 400},
+	{name: "confirm_2fa_wrong", method: "POST", path: "/user/2fa/confirm", as: "alice", body: `{"code":"abcdef"}`, status: 400},
+	{name: "confirm_2fa", method: "POST", path: "/user/2fa/confirm", as: "alice", body: `{"code":"{totp}"}`, status: 200},
+	{name: "enroll_2fa_again", method: "POST", path: "/user/2fa", as: "alice", status: 409},
+	{name: "disable_2fa_wrong_password", method: "DELETE", path: "/user/2fa", as: "alice",
+		body: `{"password":"Sunflower7"}`, status: 403},
+	{name: "disable_2fa", method: "DELETE", path: "/user/2fa", as: "alice",
+		body: `{"password":"Marigold42"}`, status: 200},
+
+	// userGroup2, api keys
+	{name: "create_api_key", method: "POST", path: "/user/api-keys", as: "alice",
+		body: `{"name":"ledger","scopes":["invoices:read"]}`, status: 201,
+		save: func(vars map[string]string, body any) {
+			saveField("id", "key_id")(vars, body)
+			saveField("key", "api_key")(vars, body)
+		}},
+	{name: "create_api_key_bad_scope", method: "POST", path: "/user/api-keys", as: "alice",
+		body: `{"name":"ledger","scopes":["everything"]}`, status: 400},
+	{name: "api_key_reads_invoices", method: "GET", path: "/user/invoices", as: "apikey", status: 200},
+	{name: "api_key_missing_scope", method: "POST", path: "/invoices/", as: "apikey",
+		body: `{"product":"Peashooter","category":"Toy","price":20,"quantity":1}`, status: 403},
+	{name: "api_key_cant_manage_keys", method: "GET", path: "/user/api-keys", as: "apikey", status: 403},
+	{name: "read_api_keys", method: "GET", path: "/user/api-keys", as: "alice", status: 200},
+	{name: "revoke_api_key_bad_id", method: "DELETE", path: "/user/api-keys/one", as: "alice", status: 400},
+	{name: "revoke_api_key_other_user", method: "DELETE", path: "/user/api-keys/{key_id}", as: "staff", status: 404},
+	{name: "revoke_api_key", method: "DELETE", path: "/user/api-keys/{key_id}", as: "alice", status: 200},
+	{name: "revoked_api_key_rejected", method: "GET", path: "/user/invoices", as: "apikey", status: 401},
+
+	// userGroup1, leaving
+	{name: "logout", method: "POST", path: "/logout", as: "staff", status: 200},
+	{name: "logged_out_token_rejected", method: "POST", path: "/logout", as: "staff", status: 401},
+	{name: "delete_account", method: "DELETE", path: "/users", as: "alice", status: 200},
+	{name: "deleted_account_token_rejected", method: "GET", path: "/user", as: "alice", status: 401},
+}
+
+func TestEndToEnd(t *testing.T) {
+	r, accounts := newTestRouter(t)
+
+	tokens := map[string]string{
+		"alice":  signUp(t, r, e2eAccounts["alice"]),
+		"bob":    signUp(t, r, e2eAccounts["bob"]),
+		"forged": "not-a-real-token",
+	}
+	// nobody can sign up as staff, so the role is given straight through the store
+	staff := e2eAccounts["staff"]
+	registered, fieldErr := accounts.AddAccount(context.Background(), &staff)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if _, fieldErr := accounts.SetRole(context.Background(), registered.UserID, accts.RoleStaff); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	tokens["staff"] = logIn(t, r, e2eAccounts["staff"])
+
+	vars := make(map[string]string)
+	for _, tc := range routeCases {
+		req := httptest.NewRequest(tc.method, expand(t, tc.path, vars), strings.NewReader(expand(t, tc.body, vars)))
+		req.Header.Set("content-type", "application/json")
+		switch tc.as {
+		case "":
+		case "apikey":
+			req.Header.Set("X-API-Key", vars["api_key"])
+		default:
+			req.Header.Set("Authorization", "Bearer "+tokens[tc.as])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: %s %s got %d, want %d\n%s", tc.name, tc.method, tc.path, w.Code, tc.status, w.Body)
+		}
+		var body any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: response isn't json: %v\n%s", tc.name, err, w.Body)
+			continue
+		}
+		if tc.save != nil {
+			tc.save(vars, body)
+		}
+		checkGolden(t, tc.name, body)
+	}
+}
+
+// compares a response with its golden file, or rewrites the file with -update
+func checkGolden(t *testing.T, name string, body any) {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keeps the <placeholders> readable
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(normalize(body)); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	path := filepath.Join("testdata", "e2e", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("%s: %v, run the tests with -update to create it", name, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: response doesn't match %s\ngot:\n%s\nwant:\n%s", name, path, got, want)
+	}
+}
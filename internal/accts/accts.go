@@ -1,13 +1,20 @@
 package accts
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"strings"
+	"log"
+	"strconv"
+	"time"
 
 	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/config"
 	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/ScriptMang/conch/internal/jwt"
+	"github.com/ScriptMang/conch/internal/notify"
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/jackc/pgx/v5"
 	"golang.org/x/crypto/bcrypt"
@@ -34,6 +41,7 @@ type UserContacts struct {
 type Usernames struct {
 	ID       int    `db:"id" json:"id"`
 	Username string `db:"username" json:"username"`
+	Role     string `db:"role" json:"role"`
 }
 
 type Passwords struct {
@@ -43,9 +51,35 @@ type Passwords struct {
 }
 
 type Tokens struct {
-	ID     int    `db:"id" json:"id" form:"id"`
-	UserID int    `db:"user_id" json:"user_id"`
-	Token  []byte `db:"token" json:"token"`
+	ID               int       `db:"id" json:"id" form:"id"`
+	UserID           int       `db:"user_id" json:"user_id"`
+	Token            []byte    `db:"token" json:"token"`
+	IssuedAt         time.Time `db:"issued_at" json:"issued_at"`
+	ExpiresAt        time.Time `db:"expires_at" json:"expires_at"`
+	RefreshToken     string    `db:"refresh_token" json:"refresh_token"`
+	RefreshExpiresAt time.Time `db:"refresh_expires_at" json:"refresh_expires_at"`
+	LastUsedAt       time.Time `db:"last_used_at" json:"last_used_at"`
+	UserAgent        string    `db:"user_agent" json:"user_agent"`
+	IPAddress        string    `db:"ip_address" json:"ip_address"`
+}
+
+// the client a session was started from
+type Device struct {
+	UserAgent string
+	IPAddress string
+}
+
+// a login session as shown to its owner
+// the tokens themselves are never listed
+type Session struct {
+	ID         int       `db:"id" json:"id"`
+	Token      string    `db:"token" json:"-"`
+	UserAgent  string    `db:"user_agent" json:"user_agent"`
+	IPAddress  string    `db:"ip_address" json:"ip_address"`
+	IssuedAt   time.Time `db:"issued_at" json:"issued_at"`
+	LastUsedAt time.Time `db:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time `db:"refresh_expires_at" json:"expires_at"`
+	Current    bool      `db:"current" json:"current"`
 }
 
 type Registered struct {
@@ -57,77 +91,97 @@ type LoginStatus struct {
 	Status string
 }
 
-// meant to hold err strings related to authentication and account creation
-type AuthError struct {
-	ErrMsgs []string
-}
-
-var ErrorCode int // http-status code for errors
 const BadRequest = 400
+const unauthorized = 401
 const resourceNotFound = 404
 
-// helper funct: takes a pointer to an Authentication Error, HttpStatusCode and a string msg
-// as parameters and sets the values for the AuthError struct.
-// By default content-type is of type 'application/json'
-func (credErr *AuthError) AddMsg(statusCode int, str string) {
-	ErrorCode = statusCode
-	credErr.ErrMsgs = append(credErr.ErrMsgs, str)
+// what both stores are set up with: the config loaded at startup and
+// what's built from it. each store gets its own so nothing is shared
+// between stores through package state
+type settings struct {
+	conf     *config.Config
+	signer   *jwt.Signer            // signs access tokens, nil in opaque token mode
+	notifier notify.Notifier        // delivers password reset tokens
+	policy   *fields.PasswordPolicy // new passwords are checked against it
+	revoked  *revocationList        // signed tokens logged out before they expired
 }
 
-// adds private userinfo  to usercontacts
-func addUserContact(acct *Account, acctErr *fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+// builds the settings for a store from the config
+func newSettings(cfg *config.Config) (*settings, error) {
+	set := &settings{conf: cfg, revoked: newRevocationList()}
+	set.notifier = notify.LogNotifier{}
+	if cfg.NotifyFile != "" {
+		set.notifier = &notify.FileNotifier{Path: cfg.NotifyFile}
+	}
+
+	var err error
+	set.policy, err = fields.NewPasswordPolicy(cfg.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TokenMode == config.TokenModeJWT {
+		set.signer, err = jwt.NewSigner(cfg.SigningKeys, cfg.ActiveKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
 
+// replaces the notifier the config set up
+func (set *settings) SetNotifier(n notify.Notifier) {
+	set.notifier = n
+}
+
+// returns the policy new passwords are checked against
+func (set *settings) PasswordPolicy() *fields.PasswordPolicy {
+	return set.policy
+}
+
+// adds private userinfo  to usercontacts
+func addUserContact(ctx context.Context, q bikeshop.Querier, acct *Account, acctErr *fields.GrammarError) {
 	var newContact UserContacts
 	if len(acctErr.ErrMsgs) > 0 {
 		// fmt.Println("Errs exist in addUserContact Funct return nil")
 		return
 	}
 
-	rows, _ := db.Query(
+	rows, _ := q.Query(
 		ctx,
 		`INSERT INTO UserContacts (user_id, fname, lname, address) VALUES($1, $2, $3, $4) RETURNING *`,
 		acct.ID, acct.Fname, acct.Lname, acct.Address,
 	)
 
 	err := pgxscan.ScanOne(&newContact, rows)
+	if acctErr.AddCtxErr(ctx, err) {
+		return
+	}
 	if err != nil {
-		qryError := err.Error()
-		if strings.Contains(qryError, "value too long for type character varying") {
-			acctErr.AddMsg(BadRequest, "varchar too long, use varchar length between 1-255")
-		} else {
-			acctErr.AddMsg(BadRequest, qryError)
-		}
+		acctErr.AddDBErr(err)
 		return
 	}
 }
 
 // helper funct that adds a users username to users table
-func addUsername(acct *Account, acctErr *fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
-
+func addUsername(ctx context.Context, q bikeshop.Querier, acct *Account, acctErr *fields.GrammarError) {
 	var id int
 	if len(acctErr.ErrMsgs) > 0 {
 		// fmt.Println("Errs exist in AddUser Funct return nil")
 		return
 	}
 
-	rows, _ := db.Query(
+	rows, _ := q.Query(
 		ctx,
 		`INSERT INTO Usernames (username) VALUES($1) RETURNING id`,
 		acct.Username,
 	)
 
 	err := pgxscan.ScanOne(&id, rows)
+	if acctErr.AddCtxErr(ctx, err) {
+		return
+	}
 	if err != nil {
-		qryError := err.Error()
-		if strings.Contains(qryError, "value too long for type character varying") {
-			acctErr.AddMsg(BadRequest, "varchar too long, use varchar length between 1-255")
-		} else {
-			acctErr.AddMsg(BadRequest, qryError)
-		}
+		acctErr.AddDBErr(err)
 		return
 	}
 
@@ -136,86 +190,116 @@ func addUsername(acct *Account, acctErr *fields.GrammarError) {
 	acct.ID = id
 }
 
-func encryptPassword(val string) ([]byte, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(val), 14)
-	return hash, err
+// helper funct that hashes the account's password
+// it's done before the account's transaction starts
+// so the slow hash doesn't hold a connection open
+func (set *settings) hashPassword(acct *Account, acctErr *fields.GrammarError) []byte {
+	if len(acct.Password) == 0 {
+		acctErr.AddMsg(BadRequest, "password", fields.CodeRequired, " Couldn't add password since none exist")
+		return nil
+	}
+	// hash the password with the configured algorithm
+	hashedPswd, err := hashSecret(acct.Password, set.conf)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		acctErr.AddMsg(BadRequest, "password", fields.CodeHashing,
+			"Hashing Error: password longer than 72 bytes, bcrypt can't hash it")
+		return nil
+	}
+	if err != nil {
+		acctErr.AddMsg(fields.InternalServerError, "password", fields.CodeHashing,
+			"Hashing Error: the password couldn't be hashed with "+set.conf.PasswordHash)
+		return nil
+	}
+	return hashedPswd
 }
 
 // helper funct that adds hash to the passwords table
-func addPassword(acct *Account, acctErr *fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
-
+func addPassword(ctx context.Context, q bikeshop.Querier, acct *Account, hashedPswd []byte, acctErr *fields.GrammarError) {
 	var pswds Passwords
-	// var err error
 
 	if len(acctErr.ErrMsgs) > 0 {
 		return
 	}
 
-	if len(acct.Password) == 0 {
-		acctErr.AddMsg(BadRequest, " Couldn't add password since none exist")
-		return
-	}
-	// encrypt password
-	hashedPswd, err := encryptPassword(acct.Password)
-	if err != nil {
-		acctErr.AddMsg(BadRequest,
-			"Hashing Error: password longer than 72 bytes, can't hash")
-		return
-	}
-
 	// // if no errors add info to appropiate tables
-	rows, _ := db.Query(ctx,
+	rows, _ := q.Query(ctx,
 		`INSERT INTO Passwords (user_id, password) VALUES($1, $2) RETURNING *`,
 		acct.ID, hashedPswd,
 	)
 
-	err = pgxscan.ScanOne(&pswds, rows)
+	err := pgxscan.ScanOne(&pswds, rows)
+	if acctErr.AddCtxErr(ctx, err) {
+		return
+	}
 	if err != nil {
-		qryError := err.Error()
-		if strings.Contains(qryError, "value too long for type character varying") {
-			acctErr.AddMsg(BadRequest, "password too long,chars must be less than 72 bytes")
-		} else {
-			acctErr.AddMsg(BadRequest, qryError)
-		}
+		acctErr.AddDBErr(err)
 	}
 }
 
-// returns a pswd hash given userid
-// if the id doesn't exist it error
-func ReadHashByID(userID int) ([]*Passwords, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
-
-	var pswd Passwords
-	var pswds []*Passwords
-	_, fieldErr := ReadUserContact()
-
-	// make sure the table isn't empty
-	if fieldErr.ErrMsgs != nil {
-		return nil, fieldErr
-	}
+// holds a username joined with its password hash
+type credentials struct {
+	UserID   int    `db:"user_id"`
+	Password []byte `db:"password"`
+}
 
-	row, _ := db.Query(ctx, `SELECT Password FROM Passwords WHERE user_id=$1`, userID)
+// checks the username and password against the stored hash
+// returns the user's id, or 0 and a 401 error when they don't match.
+// an unknown username and a wrong password get the same error
+// so the response doesn't reveal which usernames exist
+func (pg *Postgres) Authenticate(ctx context.Context, username, password string, fieldErr *fields.GrammarError) int {
+	db := pg.store.Pool
 
-	err := pgxscan.ScanOne(&pswd, row)
+	var creds credentials
+	rows, _ := db.Query(ctx,
+		`SELECT u.id AS user_id, p.password FROM Usernames u
+		JOIN Passwords p ON p.user_id = u.id
+		WHERE u.username=$1`,
+		username,
+	)
 
+	err := pgxscan.ScanOne(&creds, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return 0
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		// spend as long as a real check would before answering
+		verifySecret(dummyHash(pg.conf), password, pg.conf)
+		fieldErr.AddMsg(unauthorized, "", fields.CodeInvalidCreds, "Unauthorized: username or password is incorrect")
+		return 0
+	}
 	if err != nil {
-		errMsg := err.Error()
-		switch {
-		case strings.Contains(errMsg, "no rows in result set"):
-			fieldErr.AddMsg(resourceNotFound, "Resource Not Found: user with specified id does not exist")
-		default:
-			fieldErr.AddMsg(BadRequest, errMsg)
-		}
-		return nil, fieldErr
+		fieldErr.AddDBErr(err)
+		return 0
 	}
 
-	// fmt.Printf("The len of fieldErr msgs is: %d\n", len(fieldErr.ErrMsgs))
+	ok, stale := verifySecret(creds.Password, password, pg.conf)
+	if !ok {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeInvalidCreds, "Unauthorized: username or password is incorrect")
+		return 0
+	}
+	if stale {
+		pg.rehashPassword(ctx, creds, password)
+	}
+	return creds.UserID
+}
 
-	pswds = append(pswds, &pswd)
-	return pswds, fieldErr
+// replaces a hash made with an old algorithm or cost now that the
+// password is known. the hash is only swapped if it's still the one
+// that was checked, so a password changed meanwhile isn't undone.
+// a failure is only logged since the login itself succeeded
+func (pg *Postgres) rehashPassword(ctx context.Context, creds credentials, password string) {
+	db := pg.store.Pool
+
+	hash, err := hashSecret(password, pg.conf)
+	if err == nil {
+		_, err = db.Exec(ctx,
+			`UPDATE Passwords SET password=$3 WHERE user_id=$1 AND password=$2`,
+			creds.UserID, creds.Password, hash,
+		)
+	}
+	if err != nil {
+		log.Printf("rehashing the password of user %d: %v", creds.UserID, err)
+	}
 }
 
 // returns random hex as a string
@@ -228,94 +312,297 @@ func randHex(n int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// creates an auth-token for a user and stores it in the database
-func GenerateToken(username string, acctErr *fields.GrammarError) Tokens {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+// returns the hex encoded sha-256 digest of a token.
+// only digests are stored so a leaked tokens table can't be used to log in
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	var newToken Tokens
-	token, _ := randHex(20)
+// returns the digest a token's session is stored under.
+// in jwt mode that's the digest of the token's id, not the whole token
+func (set *settings) tokenDigest(tgtToken string) string {
+	if set.signer == nil {
+		return digest(tgtToken)
+	}
+	claims, err := set.signer.Verify(tgtToken, time.Now())
+	if err != nil && !errors.Is(err, jwt.ErrExpired) {
+		return digest(tgtToken) // matches no session
+	}
+	return digest(claims.ID)
+}
 
-	users, fieldErr := readUserByUsername(username)
-	user := *users[0]
+// replaces the raw token with a signed one carrying its id
+// and the user's role in jwt mode
+func (set *settings) signToken(ctx context.Context, q bikeshop.Querier, token *Tokens, fieldErr *fields.GrammarError) {
+	if set.signer == nil {
+		return
+	}
+	role := readRole(ctx, q, token.UserID, fieldErr)
 	if fieldErr.ErrMsgs != nil {
-		return newToken
+		return
 	}
+	set.signAs(token, role, fieldErr)
+}
+
+// helper funct that signs the token for a user with the given role
+// does nothing in opaque token mode
+func (set *settings) signAs(token *Tokens, role string, fieldErr *fields.GrammarError) {
+	if set.signer == nil {
+		return
+	}
+	signed, err := set.signer.Sign(jwt.Claims{
+		ID:        string(token.Token),
+		Subject:   strconv.Itoa(token.UserID),
+		UserID:    token.UserID,
+		SessionID: token.ID,
+		Roles:     []string{role},
+		IssuedAt:  token.IssuedAt.Unix(),
+		ExpiresAt: token.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return
+	}
+	token.Token = []byte(signed)
+}
+
+// creates an auth-token for a user and stores it in the database
+// along with a refresh token that can later be traded for a new pair.
+// each login starts its own session so a user can be logged in on many devices.
+// the returned Tokens holds the raw tokens, the database only their digests
+func (pg *Postgres) GenerateToken(ctx context.Context, userID int, device Device, acctErr *fields.GrammarError) Tokens {
+	db := pg.store.Pool
+
+	var newToken Tokens
+	token, _ := randHex(20)
+	refreshToken, _ := randHex(32)
+	now := time.Now()
 
 	rows, _ := db.Query(ctx,
-		`INSERT INTO Tokens (user_id, token) VALUES($1, $2) RETURNING *`,
-		user.ID, token,
+		`INSERT INTO Tokens (user_id, token, issued_at, expires_at, refresh_token, refresh_expires_at,
+		last_used_at, user_agent, ip_address)
+		VALUES($1, $2, $3, $4, $5, $6, $3, $7, $8) RETURNING *`,
+		userID, digest(token), now, now.Add(pg.conf.AccessTokenTTL.Duration),
+		digest(refreshToken), now.Add(pg.conf.RefreshTokenTTL.Duration),
+		truncate(device.UserAgent, 255), truncate(device.IPAddress, 45),
 	)
 
 	err := pgxscan.ScanOne(&newToken, rows)
+	if acctErr.AddCtxErr(ctx, err) {
+		return newToken
+	}
 	if err != nil {
-		qryError := err.Error()
-		switch {
-		case strings.Contains(qryError, "value too long for type character varying"):
-			acctErr.AddMsg(BadRequest, "varchar too long, use varchar length between 1-255")
-		case strings.Contains(qryError, "duplicate key value violates unique constraint"):
-			acctErr.AddMsg(BadRequest, "Error: Duplicate User: cannot generate a new token if one already exist")
-		default:
-			acctErr.AddMsg(BadRequest, qryError)
-		}
+		acctErr.AddDBErr(err)
 		return newToken
 	}
 
+	newToken.Token = []byte(token)
+	newToken.RefreshToken = refreshToken
+	pg.signToken(ctx, db, &newToken, acctErr)
 	return newToken
 }
 
-// returns the user id asscoiated by the auth-token
-func ReadUserIDByToken(tgtToken string, fieldErr *fields.GrammarError) int {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+// the user an auth-token was issued to
+type TokenUser struct {
+	UserID    int       `db:"user_id"`
+	Role      string    `db:"role"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
 
-	var token Tokens
+// returns the user asscoiated by the auth-token along with their role
+// an expired token is rejected with the code token_expired
+// so the client knows to use its refresh token
+func (pg *Postgres) ReadUserByToken(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) TokenUser {
+	if pg.signer != nil {
+		return pg.readUserBySignedToken(tgtToken, fieldErr)
+	}
+	db := pg.store.Pool
+
+	hashed := digest(tgtToken)
+	if cached, ok := pg.cache.get(hashed); ok && time.Now().Before(cached.expiresAt) {
+		return TokenUser{cached.userID, cached.role, cached.expiresAt}
+	}
+	gen := pg.cache.begin()
+	defer pg.cache.done(gen)
+
+	var user TokenUser
 	rows, _ := db.Query(ctx,
-		`SELECT * FROM tokens WHERE token=$1`, tgtToken,
+		`SELECT t.user_id, u.role, t.expires_at FROM tokens t
+		JOIN Usernames u ON u.id = t.user_id
+		WHERE t.token=$1`, hashed,
 	)
 
-	err := pgxscan.ScanOne(&token, rows)
+	err := pgxscan.ScanOne(&user, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return TokenUser{}
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeUnauthorized, "Unauthorized: token doesn't belong to any user")
+		return TokenUser{}
+	}
 	if err != nil {
-		errMsg := err.Error()
-		switch {
-		case strings.Contains(errMsg, "no rows in result set"):
-			fieldErr.AddMsg(resourceNotFound, "Resource Not Found: user with specified id does not exist")
-		default:
-			fieldErr.AddMsg(BadRequest, errMsg)
-		}
-		return 0
+		fieldErr.AddDBErr(err)
+		return TokenUser{}
 	}
-	return token.UserID
+
+	if !time.Now().Before(user.ExpiresAt) {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeTokenExpired, "Unauthorized: token has expired, use the refresh token to get a new one")
+		return TokenUser{}
+	}
+	pg.cache.put(cachedToken{digest: hashed, userID: user.UserID, role: user.Role, expiresAt: user.ExpiresAt}, gen)
+	return user
+}
+
+// checks a signed token without the database
+// a token that was logged out is rejected like an unknown one
+func (set *settings) readUserBySignedToken(tgtToken string, fieldErr *fields.GrammarError) TokenUser {
+	claims, err := set.signer.Verify(tgtToken, time.Now())
+	if errors.Is(err, jwt.ErrExpired) {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeTokenExpired, "Unauthorized: token has expired, use the refresh token to get a new one")
+		return TokenUser{}
+	}
+	if err != nil || set.revoked.has(digest(claims.ID)) {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeUnauthorized, "Unauthorized: token doesn't belong to any user")
+		return TokenUser{}
+	}
+
+	role := RoleCustomer
+	if len(claims.Roles) > 0 {
+		role = claims.Roles[0]
+	}
+	return TokenUser{claims.UserID, role, time.Unix(claims.ExpiresAt, 0)}
+}
+
+// records when the token's session was last used and, if sliding
+// sessions are turned on, pushes its expiry out by the access token lifetime
+// signed tokens can't be extended, so nothing is recorded for them
+func (pg *Postgres) TouchToken(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) {
+	if pg.signer != nil {
+		return
+	}
+	db := pg.store.Pool
+
+	now := time.Now()
+	expiresAt := now
+	if pg.conf.SlidingSessions {
+		expiresAt = now.Add(pg.conf.AccessTokenTTL.Duration)
+	}
+	hashed := digest(tgtToken)
+	_, err := db.Exec(ctx,
+		`UPDATE tokens SET last_used_at=$2, expires_at=GREATEST(expires_at, $3) WHERE token=$1`,
+		hashed, now, expiresAt,
+	)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return
+	}
+	pg.cache.extend(hashed, expiresAt)
+}
+
+// trades a refresh token for a new access and refresh token.
+// the old pair is replaced so each refresh token can only be used once
+func (pg *Postgres) RefreshToken(ctx context.Context, refreshToken string, fieldErr *fields.GrammarError) Tokens {
+	var newToken Tokens
+	var oldDigest string
+
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var oldToken Tokens
+		rows, _ := q.Query(ctx,
+			`SELECT * FROM tokens WHERE refresh_token=$1 FOR UPDATE`, digest(refreshToken),
+		)
+		err := pgxscan.ScanOne(&oldToken, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			fieldErr.AddMsg(unauthorized, "refresh_token", fields.CodeUnauthorized, "Unauthorized: refresh token isn't valid")
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+
+		now := time.Now()
+		if !now.Before(oldToken.RefreshExpiresAt) {
+			fieldErr.AddMsg(unauthorized, "refresh_token", fields.CodeTokenExpired, "Unauthorized: refresh token has expired, log in again")
+			return bikeshop.ErrRollback
+		}
+
+		token, _ := randHex(20)
+		newRefreshToken, _ := randHex(32)
+		rows, _ = q.Query(ctx,
+			`UPDATE tokens SET token=$2, issued_at=$3, expires_at=$4, refresh_token=$5, refresh_expires_at=$6,
+			last_used_at=$3 WHERE id=$1 RETURNING *`,
+			oldToken.ID, digest(token), now, now.Add(pg.conf.AccessTokenTTL.Duration),
+			digest(newRefreshToken), now.Add(pg.conf.RefreshTokenTTL.Duration),
+		)
+		err = pgxscan.ScanOne(&newToken, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+		// the old signed token would stay valid until it expired
+		pg.revokeToken(ctx, q, string(oldToken.Token), oldToken.ExpiresAt, fieldErr)
+		if fieldErr.ErrMsgs != nil {
+			return bikeshop.ErrRollback
+		}
+
+		oldDigest = string(oldToken.Token)
+		newToken.Token = []byte(token)
+		newToken.RefreshToken = newRefreshToken
+		pg.signToken(ctx, q, &newToken, fieldErr)
+		if fieldErr.ErrMsgs != nil {
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+
+	fieldErr.AddTxErr(ctx, txErr)
+	pg.cache.remove(oldDigest)
+	return newToken
 }
 
 // adds the account info to the appropiate tables w/ the database
-func AddAccount(acct *Account) (*Registered, fields.GrammarError) {
+// the username, contact and password are added in one transaction
+// so a failure part way through doesn't leave an orphaned username behind
+func (pg *Postgres) AddAccount(ctx context.Context, acct *Account) (*Registered, fields.GrammarError) {
 	acctErr := &fields.GrammarError{}
-	validateAccount(acct, acctErr)
+	pg.validateAccount(acct, acctErr)
 
 	if acctErr.ErrMsgs != nil {
 		// fmt.Printf("Errors in AddAccount Func, %v\n", acctErr.ErrMsgs)
 		return nil, *acctErr
 	}
 
-	// if no errors add info to appropiate tables
-	addUsername(acct, acctErr)
+	hashedPswd := pg.hashPassword(acct, acctErr)
 	if acctErr.ErrMsgs != nil {
-		// fmt.Printf("Errors in AddAccount Func, %v\n", acctErr.ErrMsgs)
 		return nil, *acctErr
 	}
 
-	addUserContact(acct, acctErr)
-	if acctErr.ErrMsgs != nil {
-		// fmt.Printf("Errors in AddAccount Func, %v\n", acctErr.ErrMsgs)
-		return nil, *acctErr
-	}
+	// if no errors add info to appropiate tables
+	err := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		addUsername(ctx, q, acct, acctErr)
+		addUserContact(ctx, q, acct, acctErr)
+		addPassword(ctx, q, acct, hashedPswd, acctErr)
+		if acctErr.ErrMsgs != nil {
+			// fmt.Printf("Errors in AddAccount Func, %v\n", acctErr.ErrMsgs)
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
 
-	// add passwords to table, don't if err existf
-	// fmt.Printf("User added into Usernames: %v\n", *accts[0])
-	addPassword(acct, acctErr)
+	// the commit itself can fail after every insert went through
+	acctErr.AddTxErr(ctx, err)
 	if acctErr.ErrMsgs != nil {
-		// fmt.Printf("Errors in AddAccount Func, %v\n", acctErr.ErrMsgs)
+		acct.ID = 0
 		return nil, *acctErr
 	}
 
@@ -323,54 +610,50 @@ func AddAccount(acct *Account) (*Registered, fields.GrammarError) {
 }
 
 // returns the list of all existing users
-func ReadUserContact() ([]*UserContacts, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) ReadUserContact(ctx context.Context) ([]*UserContacts, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var usrContacts []*UserContacts
 	fieldErr := fields.GrammarError{}
 	rows, _ := db.Query(ctx, `SELECT * FROM UserContacts`)
 	err := pgxscan.ScanAll(&usrContacts, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 	if err != nil {
-		errMsg := err.Error()
 		// log.Printf("Error in ReadUsernames: %s\n", errMsg)
-		if strings.Contains(errMsg, "failed to connect") {
-			fieldErr.ErrMsgs = nil
-			fieldErr.AddMsg(BadRequest, "Error: failed to connect to database, username doesn't exist")
-		} else if errMsg != "" {
-			fieldErr.AddMsg(BadRequest, errMsg)
-		}
-		// fmt.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
+		fieldErr.AddDBErr(err)
 	}
 
 	return usrContacts, fieldErr
 }
 
-// returns a user given the id
+// returns the contact of the user with the given user id
 // if the id doesn't exist it error
-func ReadUserContactByID(id int) ([]*UserContacts, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) ReadUserContactByID(ctx context.Context, id int) ([]*UserContacts, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var usrContact UserContacts
 	var usrContacts []*UserContacts
-	_, fieldErr := ReadUserContact()
+	_, fieldErr := pg.ReadUserContact(ctx)
 
 	// make sure the table isn't empty
 	if fieldErr.ErrMsgs != nil {
 		return usrContacts, fieldErr
 	}
 
-	row, _ := db.Query(ctx, `SELECT * FROM UserContacts WHERE id=$1`, id)
+	row, _ := db.Query(ctx, `SELECT * FROM UserContacts WHERE user_id=$1`, id)
 
 	err := pgxscan.ScanOne(&usrContact, row)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id does not exist")
+		return usrContacts, fieldErr
+	}
 	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "no rows in result set") {
-			fieldErr.AddMsg(resourceNotFound, "Resource Not Found: user with specified id does not exist")
-		}
-
-		// fmt.Printf("The len of fieldErr msgs is: %d\n", len(fieldErr.ErrMsgs))
+		fieldErr.AddDBErr(err)
 		return usrContacts, fieldErr
 	}
 	usrContacts = append(usrContacts, &usrContact)
@@ -378,13 +661,12 @@ func ReadUserContactByID(id int) ([]*UserContacts, fields.GrammarError) {
 }
 
 // returns the user given their username
-func readUserByUsername(username string) ([]*Usernames, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) readUserByUsername(ctx context.Context, username string) ([]*Usernames, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var usr Usernames
 	var usrs []*Usernames
-	_, fieldErr := ReadUserContact()
+	_, fieldErr := pg.ReadUserContact(ctx)
 
 	// make sure the table isn't empty
 
@@ -395,22 +677,23 @@ func readUserByUsername(username string) ([]*Usernames, fields.GrammarError) {
 	row, _ := db.Query(ctx, `SELECT * FROM Usernames WHERE username=$1`, username)
 
 	err := pgxscan.ScanOne(&usr, row)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "username", fields.CodeNotFound, "Error: username is incorrect")
+		return usrs, fieldErr
+	}
 	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "no rows in result set") {
-			fieldErr.AddMsg(resourceNotFound, "Error: username is incorrect")
-		}
-
-		// fmt.Printf("The len of fieldErr msgs is: %d\n", len(fieldErr.ErrMsgs))
+		fieldErr.AddDBErr(err)
 		return usrs, fieldErr
 	}
 	usrs = append(usrs, &usr)
 	return usrs, fieldErr
 }
 
-func ReadUsernameByID(userID int, fieldErr *fields.GrammarError) []*Usernames {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) ReadUsernameByID(ctx context.Context, userID int, fieldErr *fields.GrammarError) []*Usernames {
+	db := pg.store.Pool
 
 	var usr Usernames
 	var usrs []*Usernames
@@ -418,56 +701,124 @@ func ReadUsernameByID(userID int, fieldErr *fields.GrammarError) []*Usernames {
 	row, _ := db.Query(ctx, `SELECT * FROM Usernames WHERE id=$1`, userID)
 
 	err := pgxscan.ScanOne(&usr, row)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: username with specified id doesn't exist")
+		return usrs
+	}
 	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "no rows in result set") {
-			fieldErr.AddMsg(resourceNotFound, "Resource Not Found: username with specified id doesn't exist")
-		}
-
-		// fmt.Printf("The len of fieldErr msgs is: %d\n", len(fieldErr.ErrMsgs))
+		fieldErr.AddDBErr(err)
 		return usrs
 	}
 	usrs = append(usrs, &usr)
 	return usrs
 }
 
-// When users logout they delete their session token
-func LogOut(userID int, fieldErr *fields.GrammarError) Tokens {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+// When users logout they delete the token of the session they're using,
+// their sessions on other devices stay logged in
+func (pg *Postgres) LogOut(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) Tokens {
+	db := pg.store.Pool
 
 	var token Tokens
+	hashed := pg.tokenDigest(tgtToken)
 	row, _ := db.Query(ctx,
-		`DELETE FROM tokens WHERE user_id=$1 RETURNING *`,
-		userID)
+		`DELETE FROM tokens WHERE token=$1 RETURNING *`,
+		hashed)
 
+	// dropped once the row is gone, the cache's tombstone keeps a lookup
+	// that read the row before then from putting the token back
 	err := pgxscan.ScanOne(&token, row)
+	pg.cache.remove(hashed)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return token
+	}
 	if errors.Is(err, pgx.ErrNoRows) {
 		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: user with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: session has already ended")
 		return token
 	}
 
 	if err != nil {
-		errMsg := err.Error()
-		fieldErr.AddMsg(fields.BadRequest, errMsg)
+		fieldErr.AddDBErr(err)
 		return token
 	}
 
+	pg.revokeToken(ctx, db, hashed, token.ExpiresAt, fieldErr)
 	return token
 }
 
+// returns every session the user is logged in with, newest first.
+// the session using tgtToken is marked as the current one
+func (pg *Postgres) ReadSessions(ctx context.Context, userID int, tgtToken string) ([]*Session, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var sessions []*Session
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx,
+		`SELECT id, user_agent, ip_address, issued_at, last_used_at, refresh_expires_at, token=$2 AS current
+		FROM tokens WHERE user_id=$1 ORDER BY last_used_at DESC, id DESC`,
+		userID, pg.tokenDigest(tgtToken),
+	)
+	err := pgxscan.ScanAll(&sessions, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return sessions, fieldErr
+}
+
+// ends one of the user's sessions by its id.
+// a session that belongs to someone else is reported as not found
+func (pg *Postgres) RevokeSession(ctx context.Context, userID, sessionID int) ([]*Session, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var session Session
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx,
+		`DELETE FROM tokens WHERE id=$1 AND user_id=$2
+		RETURNING id, token, user_agent, ip_address, issued_at, last_used_at, refresh_expires_at, false AS current`,
+		sessionID, userID,
+	)
+	err := pgxscan.ScanOne(&session, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: session with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	pg.cache.remove(session.Token)
+	pg.revokeToken(ctx, db, session.Token, session.ExpiresAt, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	return []*Session{&session}, fieldErr
+}
+
 // Deletes the User account which
 // cascades to delete their invoices too
-func DeleteAcct(user Usernames) ([]*Usernames, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) DeleteAcct(ctx context.Context, user Usernames) ([]*Usernames, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var usr Usernames
 	var usrs []*Usernames
 
 	// verify username exists
-	_, fieldErr := readUserByUsername(user.Username)
+	_, fieldErr := pg.readUserByUsername(ctx, user.Username)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	pg.revokeUserTokens(ctx, db, user.ID, "", &fieldErr)
 	if fieldErr.ErrMsgs != nil {
 		return nil, fieldErr
 	}
@@ -476,16 +827,21 @@ func DeleteAcct(user Usernames) ([]*Usernames, fields.GrammarError) {
 		`DELETE FROM usernames WHERE id=$1 RETURNING *`,
 		user.ID)
 
+	// the account's tokens are deleted with it, so they can't be served from the cache
 	err := pgxscan.ScanOne(&usr, row)
+	pg.cache.removeUser(user.ID)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 	if errors.Is(err, pgx.ErrNoRows) {
 		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: user with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
 		return nil, fieldErr
 	}
 
 	if err != nil {
 		// log.Println("Found an Error Iterating in Getting All the Invoices for the Specified User")
-		fieldErr.AddMsg(fields.BadRequest, err.Error())
+		fieldErr.AddDBErr(err)
 		return nil, fieldErr
 	}
 
@@ -494,19 +850,28 @@ func DeleteAcct(user Usernames) ([]*Usernames, fields.GrammarError) {
 
 }
 
+// cuts val down to at most n chars so it fits its varchar column
+func truncate(val string, n int) string {
+	runes := []rune(val)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return val
+}
+
 // validate username, fname, lname, address fields for digits, symbols, punct
-func validateAccount(acct *Account, acctErr *fields.GrammarError) {
+func (set *settings) validateAccount(acct *Account, acctErr *fields.GrammarError) {
 
-	textFields := map[string]*string{
-		"Fname":    &acct.Fname,
-		"Lname":    &acct.Lname,
-		"Address":  &acct.Address,
-		"Username": &acct.Username,
-		"Password": &acct.Password,
+	textFields := []textField{
+		{"Fname", &acct.Fname},
+		{"Lname", &acct.Lname},
+		{"Address", &acct.Address},
+		{"Username", &acct.Username},
 	}
 
-	for field, val := range textFields {
-		fields.CheckGrammar(field, val, acctErr)
+	for _, field := range textFields {
+		fields.CheckGrammar(field.name, field.val, acctErr)
 	}
+	set.policy.Check(acct.Password, acct.Username, acctErr)
 
 }
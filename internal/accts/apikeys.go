@@ -0,0 +1,207 @@
+package accts
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// the scopes an api key can be given
+// a key can only use the routes its scopes cover
+const (
+	ScopeAccountRead   = "account:read"
+	ScopeInvoicesRead  = "invoices:read"
+	ScopeInvoicesWrite = "invoices:write"
+)
+
+// every api key starts with this so a leaked one is easy to spot
+const apiKeyPrefix = "conch_"
+
+// reports whether scope is one of the known scopes
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeAccountRead, ScopeInvoicesRead, ScopeInvoicesWrite:
+		return true
+	}
+	return false
+}
+
+// a personal api key as shown to its owner
+// only the digest of the key is stored
+type APIKey struct {
+	ID         int        `db:"id" json:"id"`
+	UserID     int        `db:"user_id" json:"-"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	Digest     string     `db:"key" json:"-"`
+	Scopes     []string   `db:"scopes" json:"scopes"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+// a key that was just created, the only time the key itself is shown
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// the user an api key belongs to and what it's allowed to do
+type KeyUser struct {
+	KeyID  int      `db:"id"`
+	UserID int      `db:"user_id"`
+	Role   string   `db:"role"`
+	Scopes []string `db:"scopes"`
+}
+
+// reports whether the key was given scope
+func (user KeyUser) HasScope(scope string) bool {
+	return slices.Contains(user.Scopes, scope)
+}
+
+// helper funct that checks the name and scopes of a new key
+// returns the scopes sorted with duplicates removed
+func checkAPIKey(name string, scopes []string, fieldErr *fields.GrammarError) []string {
+	switch {
+	case strings.TrimSpace(name) == "":
+		fieldErr.AddMsg(BadRequest, "name", fields.CodeRequired, "Error: name can't be empty")
+	case len(name) > 64:
+		fieldErr.AddMsg(BadRequest, "name", fields.CodeTooLong, "Error: name can't be longer than 64 characters")
+	}
+
+	if len(scopes) == 0 {
+		fieldErr.AddMsg(BadRequest, "scopes", fields.CodeRequired,
+			"Error: scopes can't be empty, use "+ScopeAccountRead+", "+ScopeInvoicesRead+" or "+ScopeInvoicesWrite)
+	}
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			fieldErr.AddMsg(BadRequest, "scopes", fields.CodeInvalidScope, "Error: "+scope+" isn't a known scope")
+		}
+	}
+
+	sorted := slices.Clone(scopes)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// returns a new random key along with the start of it
+// that's shown to tell the user's keys apart
+func newAPIKey() (string, string) {
+	secret, _ := randHex(24)
+	key := apiKeyPrefix + secret
+	return key, key[:len(apiKeyPrefix)+6]
+}
+
+// creates a named api key for the user limited to scopes
+// the key is returned once and only its digest is kept
+func (pg *Postgres) CreateAPIKey(ctx context.Context, userID int, name string, scopes []string) ([]*NewAPIKey, fields.GrammarError) {
+	db := pg.store.Pool
+
+	fieldErr := fields.GrammarError{}
+	scopes = checkAPIKey(name, scopes, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	key, prefix := newAPIKey()
+
+	var created NewAPIKey
+	rows, _ := db.Query(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key, scopes) VALUES($1, $2, $3, $4, $5) RETURNING *`,
+		userID, strings.TrimSpace(name), prefix, digest(key), scopes,
+	)
+	err := pgxscan.ScanOne(&created.APIKey, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	created.Key = key
+	return []*NewAPIKey{&created}, fieldErr
+}
+
+// returns the user's api keys, newest first
+func (pg *Postgres) ReadAPIKeys(ctx context.Context, userID int) ([]*APIKey, fields.GrammarError) {
+	db := pg.store.Pool
+
+	keys := []*APIKey{}
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx, `SELECT * FROM api_keys WHERE user_id=$1 ORDER BY created_at DESC, id DESC`, userID)
+	err := pgxscan.ScanAll(&keys, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return keys, fieldErr
+}
+
+// deletes one of the user's api keys, it stops working right away
+func (pg *Postgres) RevokeAPIKey(ctx context.Context, userID, keyID int) ([]*APIKey, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var key APIKey
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx, `DELETE FROM api_keys WHERE id=$1 AND user_id=$2 RETURNING *`, keyID, userID)
+	err := pgxscan.ScanOne(&key, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: api key with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return []*APIKey{&key}, fieldErr
+}
+
+// returns the user an api key belongs to along with the key's scopes
+// the role is read with the key so a role change applies right away
+func (pg *Postgres) ReadUserByAPIKey(ctx context.Context, key string, fieldErr *fields.GrammarError) KeyUser {
+	db := pg.store.Pool
+
+	var user KeyUser
+	rows, _ := db.Query(ctx,
+		`SELECT k.id, k.user_id, u.role, k.scopes FROM api_keys k
+		JOIN Usernames u ON u.id = k.user_id
+		WHERE k.key=$1`, digest(key),
+	)
+	err := pgxscan.ScanOne(&user, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return KeyUser{}
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeUnauthorized, "Unauthorized: api key doesn't belong to any user")
+		return KeyUser{}
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return KeyUser{}
+	}
+	return user
+}
+
+// records that the api key was just used
+func (pg *Postgres) TouchAPIKey(ctx context.Context, keyID int, fieldErr *fields.GrammarError) {
+	db := pg.store.Pool
+
+	_, err := db.Exec(ctx, `UPDATE api_keys SET last_used_at=now() WHERE id=$1`, keyID)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+	}
+}
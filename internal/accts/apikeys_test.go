@@ -0,0 +1,45 @@
+package accts
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/fields"
+)
+
+func TestCheckAPIKeySortsScopes(t *testing.T) {
+	var fieldErr fields.GrammarError
+	scopes := checkAPIKey("reports", []string{ScopeInvoicesRead, ScopeAccountRead, ScopeInvoicesRead}, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatalf("unexpected errors: %v", fieldErr.ErrMsgs)
+	}
+	if want := []string{ScopeAccountRead, ScopeInvoicesRead}; !slices.Equal(scopes, want) {
+		t.Errorf("scopes = %v, want %v", scopes, want)
+	}
+}
+
+func TestCheckAPIKeyRejects(t *testing.T) {
+	tests := map[string]struct {
+		name   string
+		scopes []string
+		code   string
+	}{
+		"blank name":    {" ", []string{ScopeInvoicesRead}, fields.CodeRequired},
+		"no scopes":     {"reports", nil, fields.CodeRequired},
+		"unknown scope": {"reports", []string{"invoices:delete"}, fields.CodeInvalidScope},
+	}
+	for desc, tc := range tests {
+		var fieldErr fields.GrammarError
+		checkAPIKey(tc.name, tc.scopes, &fieldErr)
+		if len(fieldErr.Violations) != 1 || fieldErr.Violations[0].Code != tc.code {
+			t.Errorf("%s: violations = %v, want one %s", desc, fieldErr.Violations, tc.code)
+		}
+	}
+}
+
+func TestKeyUserHasScope(t *testing.T) {
+	user := KeyUser{Scopes: []string{ScopeInvoicesRead}}
+	if !user.HasScope(ScopeInvoicesRead) || user.HasScope(ScopeInvoicesWrite) {
+		t.Errorf("HasScope doesn't match the scopes %v", user.Scopes)
+	}
+}
@@ -0,0 +1,193 @@
+package accts
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// a token lookup remembered by the cache
+type cachedToken struct {
+	digest    string
+	userID    int
+	role      string
+	expiresAt time.Time
+}
+
+// a bounded least-recently-used cache of token lookups keyed by digest.
+// it's safe for concurrent use, and a nil cache is a valid cache
+// that never holds anything.
+//
+// a lookup that read a token before it was deleted mustn't put it back
+// once it's been removed, so every removal bumps gen and leaves a tombstone
+// at that generation. put refuses a lookup that started before the tombstone.
+// tombstones are dropped once no lookup that started before them is running
+type tokenCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+
+	gen          uint64            // bumped by every removal
+	lookups      map[uint64]int    // the generations running lookups started at, to how many
+	removed      map[string]uint64 // tombstones by digest
+	removedUsers map[int]uint64    // tombstones by user id
+}
+
+// returns a cache holding at most size tokens, or nil when size is zero
+func newTokenCache(size int) *tokenCache {
+	if size <= 0 {
+		return nil
+	}
+	return &tokenCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+
+		lookups:      make(map[uint64]int),
+		removed:      make(map[string]uint64),
+		removedUsers: make(map[int]uint64),
+	}
+}
+
+// starts a lookup whose result may be put in the cache, it has to be
+// started before the token is read. the returned generation is passed
+// to put, and to done once the lookup is over
+func (cache *tokenCache) begin() uint64 {
+	if cache == nil {
+		return 0
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.lookups[cache.gen]++
+	return cache.gen
+}
+
+// ends a lookup started at gen and drops the tombstones
+// no running lookup is old enough to need
+func (cache *tokenCache) done(gen uint64) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.lookups[gen]--; cache.lookups[gen] <= 0 {
+		delete(cache.lookups, gen)
+	}
+	oldest := cache.gen
+	for started := range cache.lookups {
+		oldest = min(oldest, started)
+	}
+	for digest, at := range cache.removed {
+		if at <= oldest {
+			delete(cache.removed, digest)
+		}
+	}
+	for userID, at := range cache.removedUsers {
+		if at <= oldest {
+			delete(cache.removedUsers, userID)
+		}
+	}
+}
+
+// returns the cached lookup for digest and marks it as recently used
+func (cache *tokenCache) get(digest string) (cachedToken, bool) {
+	if cache == nil {
+		return cachedToken{}, false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[digest]
+	if !ok {
+		return cachedToken{}, false
+	}
+	cache.order.MoveToFront(elem)
+	return elem.Value.(cachedToken), true
+}
+
+// adds or replaces the lookup started at gen, evicting the least recently
+// used one when full. it's dropped if the token or its user was removed
+// since the lookup started
+func (cache *tokenCache) put(token cachedToken, gen uint64) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.removed[token.digest] > gen || cache.removedUsers[token.userID] > gen {
+		return
+	}
+	if elem, ok := cache.entries[token.digest]; ok {
+		elem.Value = token
+		cache.order.MoveToFront(elem)
+		return
+	}
+	cache.entries[token.digest] = cache.order.PushFront(token)
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(cachedToken).digest)
+	}
+}
+
+// pushes a cached token's expiry out to expiresAt
+// tokens that aren't cached are left alone
+func (cache *tokenCache) extend(digest string, expiresAt time.Time) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[digest]; ok {
+		token := elem.Value.(cachedToken)
+		if expiresAt.After(token.expiresAt) {
+			token.expiresAt = expiresAt
+			elem.Value = token
+		}
+	}
+}
+
+// drops the lookup for digest. it's called once the token's row is gone
+// so a lookup that starts afterwards can't find it
+func (cache *tokenCache) remove(digest string) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.lookups) > 0 {
+		cache.gen++
+		cache.removed[digest] = cache.gen
+	}
+	if elem, ok := cache.entries[digest]; ok {
+		cache.order.Remove(elem)
+		delete(cache.entries, digest)
+	}
+}
+
+// drops every lookup belonging to the user, like remove
+// it's called once the user's rows are gone or changed
+func (cache *tokenCache) removeUser(userID int) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.lookups) > 0 {
+		cache.gen++
+		cache.removedUsers[userID] = cache.gen
+	}
+	for digest, elem := range cache.entries {
+		if elem.Value.(cachedToken).userID == userID {
+			cache.order.Remove(elem)
+			delete(cache.entries, digest)
+		}
+	}
+}
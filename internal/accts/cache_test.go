@@ -0,0 +1,128 @@
+package accts
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTokenCache(2)
+	cache.put(cachedToken{digest: "a", userID: 1}, 0)
+	cache.put(cachedToken{digest: "b", userID: 2}, 0)
+	cache.get("a") // b is now the least recently used
+	cache.put(cachedToken{digest: "c", userID: 3}, 0)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, digest := range []string{"a", "c"} {
+		if _, ok := cache.get(digest); !ok {
+			t.Errorf("%s should still be cached", digest)
+		}
+	}
+}
+
+func TestTokenCacheRemoveUser(t *testing.T) {
+	cache := newTokenCache(4)
+	cache.put(cachedToken{digest: "a", userID: 1}, 0)
+	cache.put(cachedToken{digest: "b", userID: 1}, 0)
+	cache.put(cachedToken{digest: "c", userID: 2}, 0)
+	cache.removeUser(1)
+
+	for _, digest := range []string{"a", "b"} {
+		if _, ok := cache.get(digest); ok {
+			t.Errorf("%s should have been removed with its user", digest)
+		}
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Error("c belongs to another user and should still be cached")
+	}
+}
+
+func TestTokenCacheExtend(t *testing.T) {
+	cache := newTokenCache(1)
+	now := time.Now()
+	cache.put(cachedToken{digest: "a", userID: 1, expiresAt: now}, 0)
+	cache.extend("a", now.Add(time.Minute))
+	cache.extend("a", now) // an earlier time never shortens the expiry
+
+	token, _ := cache.get("a")
+	if !token.expiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("expiresAt = %v, want %v", token.expiresAt, now.Add(time.Minute))
+	}
+}
+
+func TestNilTokenCache(t *testing.T) {
+	cache := newTokenCache(0)
+	cache.put(cachedToken{digest: "a", userID: 1}, 0)
+	if _, ok := cache.get("a"); ok {
+		t.Error("a disabled cache shouldn't hold anything")
+	}
+	cache.remove("a")
+	cache.removeUser(1)
+}
+
+// run with -race to check the cache is safe to share between requests
+func TestTokenCacheConcurrent(t *testing.T) {
+	cache := newTokenCache(16)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			digest := strconv.Itoa(i % 20)
+			gen := cache.begin()
+			cache.put(cachedToken{digest: digest, userID: i % 5}, gen)
+			cache.done(gen)
+			cache.get(digest)
+			cache.extend(digest, time.Now())
+			if i%7 == 0 {
+				cache.removeUser(i % 5)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := cache.order.Len(); n > 16 || n != len(cache.entries) {
+		t.Errorf("cache holds %d entries and %d keys, want the same count of at most 16", n, len(cache.entries))
+	}
+	if len(cache.lookups) != 0 || len(cache.removed) != 0 || len(cache.removedUsers) != 0 {
+		t.Errorf("%d lookups and %d tombstones are left with nothing running",
+			len(cache.lookups), len(cache.removed)+len(cache.removedUsers))
+	}
+}
+
+// a lookup reads the token, then it's logged out, then the lookup
+// gets to put what it read. it must not be cached
+func TestTokenCacheLookupRacingLogout(t *testing.T) {
+	cache := newTokenCache(4)
+	read := cache.begin()
+	other := cache.begin()
+	cache.remove("a")
+	cache.removeUser(2)
+
+	cache.put(cachedToken{digest: "a", userID: 1}, read)
+	cache.put(cachedToken{digest: "b", userID: 2}, read)
+	cache.done(read)
+	for _, digest := range []string{"a", "b"} {
+		if _, ok := cache.get(digest); ok {
+			t.Errorf("%s was put back after it was removed", digest)
+		}
+	}
+
+	// a lookup that starts after the removal read the database without the token,
+	// anything it finds is current
+	after := cache.begin()
+	cache.put(cachedToken{digest: "b", userID: 2}, after)
+	cache.done(after)
+	if _, ok := cache.get("b"); !ok {
+		t.Error("a lookup that started after the removal wasn't cached")
+	}
+
+	cache.done(other)
+	if len(cache.removed) != 0 || len(cache.removedUsers) != 0 {
+		t.Errorf("tombstones %v and %v are left once the lookups are done", cache.removed, cache.removedUsers)
+	}
+}
@@ -0,0 +1,123 @@
+package accts
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ScriptMang/conch/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashes are told apart by the prefix of their PHC style string,
+// bcrypt's are $2a$, $2b$ or $2y$
+var argon2idPrefix = []byte("$argon2id$")
+
+var errUnknownHash = errors.New("password hash has an unknown format")
+
+// the settings a hash is made with, the key dummy hashes are cached by
+type hashSettings struct {
+	alg        string
+	bcryptCost int
+	argon2     config.Argon2
+}
+
+var dummyHashes sync.Map // hashSettings to []byte
+
+// returns a hash of a throwaway password made with cfg's settings.
+// checking a password against it when the username is unknown takes
+// as long as checking a real one, so timing doesn't reveal who exists
+func dummyHash(cfg *config.Config) []byte {
+	key := hashSettings{cfg.PasswordHash, cfg.BcryptCost, cfg.Argon2}
+	if hash, ok := dummyHashes.Load(key); ok {
+		return hash.([]byte)
+	}
+	hash, err := hashSecret("no user has this password", cfg)
+	if err != nil {
+		// verifySecret fails fast on a malformed hash, so bcrypt's
+		// lowest cost still costs more than not checking at all
+		hash, _ = bcrypt.GenerateFromPassword([]byte("no user has this password"), bcrypt.MinCost)
+	}
+	dummyHashes.Store(key, hash)
+	return hash
+}
+
+// hashes a password with the algorithm and cost set in cfg
+func hashSecret(password string, cfg *config.Config) ([]byte, error) {
+	if cfg.PasswordHash == config.HashArgon2id {
+		return hashArgon2id(password, cfg.Argon2)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+}
+
+// checks a password against its stored hash. stale is true when the
+// hash was made with a different algorithm than cfg sets now, or a lower
+// bcrypt cost or different argon2id settings, so it should be replaced
+// while the password is at hand. a higher bcrypt cost is kept rather than weakened
+func verifySecret(hash []byte, password string, cfg *config.Config) (ok, stale bool) {
+	if bytes.HasPrefix(hash, argon2idPrefix) {
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false, false
+		}
+		got := argon2.IDKey([]byte(password), salt, params.Time, params.MemoryKiB, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return false, false
+		}
+		return true, cfg.PasswordHash != config.HashArgon2id || params != cfg.Argon2
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false, false
+	}
+	cost, _ := bcrypt.Cost(hash)
+	return true, cfg.PasswordHash != config.HashBcrypt || cost < cfg.BcryptCost
+}
+
+// returns an argon2id hash in the PHC string format
+// $argon2id$v=19$m=<KiB>,t=<time>,p=<threads>$<salt>$<key>
+func hashArgon2id(password string, params config.Argon2) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.MemoryKiB, params.Threads, 32)
+	return fmt.Appendf(nil, "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.MemoryKiB, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// splits an argon2id hash into the settings, salt and key it was made with
+func parseArgon2id(hash []byte) (config.Argon2, []byte, []byte, error) {
+	var params config.Argon2
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errUnknownHash
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.MemoryKiB, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, errUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errUnknownHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,94 @@
+package accts
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap settings so the tests don't spend seconds hashing
+func testHashConfig(alg string) *config.Config {
+	cfg := config.Default()
+	cfg.PasswordHash = alg
+	cfg.BcryptCost = 4
+	cfg.Argon2 = config.Argon2{Time: 1, MemoryKiB: 64, Threads: 1}
+	return cfg
+}
+
+func TestHashSecretRoundTrip(t *testing.T) {
+	for _, alg := range []string{config.HashBcrypt, config.HashArgon2id} {
+		cfg := testHashConfig(alg)
+		hash, err := hashSecret("Sunflower7", cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if ok, stale := verifySecret(hash, "Sunflower7", cfg); !ok || stale {
+			t.Errorf("%s: ok = %v stale = %v, want a fresh match", alg, ok, stale)
+		}
+		if ok, _ := verifySecret(hash, "Sunflower8", cfg); ok {
+			t.Errorf("%s: the wrong password matched", alg)
+		}
+	}
+
+	hash, _ := hashSecret("Sunflower7", testHashConfig(config.HashArgon2id))
+	if !bytes.HasPrefix(hash, argon2idPrefix) {
+		t.Errorf("argon2id hash = %s, want the PHC prefix", hash)
+	}
+}
+
+func TestVerifySecretReportsStaleHashes(t *testing.T) {
+	cfg := testHashConfig(config.HashBcrypt)
+	hash, _ := hashSecret("Sunflower7", cfg)
+
+	cfg.BcryptCost = 5
+	if ok, stale := verifySecret(hash, "Sunflower7", cfg); !ok || !stale {
+		t.Errorf("ok = %v stale = %v, want a match that needs rehashing after a cost change", ok, stale)
+	}
+
+	strongHash, _ := hashSecret("Sunflower7", cfg)
+	cfg.BcryptCost = 4
+	if ok, stale := verifySecret(strongHash, "Sunflower7", cfg); !ok || stale {
+		t.Errorf("ok = %v stale = %v, want a hash with a higher cost kept", ok, stale)
+	}
+
+	argonCfg := testHashConfig(config.HashArgon2id)
+	if ok, stale := verifySecret(hash, "Sunflower7", argonCfg); !ok || !stale {
+		t.Errorf("ok = %v stale = %v, want a bcrypt hash to need rehashing under argon2id", ok, stale)
+	}
+
+	argonHash, _ := hashSecret("Sunflower7", argonCfg)
+	argonCfg.Argon2.Time = 2
+	if ok, stale := verifySecret(argonHash, "Sunflower7", argonCfg); !ok || !stale {
+		t.Errorf("ok = %v stale = %v, want a match that needs rehashing after a time change", ok, stale)
+	}
+}
+
+func TestVerifySecretRejectsMalformed(t *testing.T) {
+	cfg := testHashConfig(config.HashArgon2id)
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=64,t=1$c2FsdA$a2V5", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if ok, _ := verifySecret([]byte(hash), "plaintext", cfg); ok {
+			t.Errorf("%q matched", hash)
+		}
+	}
+}
+
+func TestDummyHashMatchesTheSettings(t *testing.T) {
+	for _, alg := range []string{config.HashBcrypt, config.HashArgon2id} {
+		cfg := testHashConfig(alg)
+		hash := dummyHash(cfg)
+		if !bytes.Equal(hash, dummyHash(cfg)) {
+			t.Errorf("%s: the dummy hash isn't reused", alg)
+		}
+		if ok, _ := verifySecret(hash, "Sunflower7", cfg); ok {
+			t.Errorf("%s: a password matched the dummy hash", alg)
+		}
+		if (alg == config.HashArgon2id) != bytes.HasPrefix(hash, argon2idPrefix) {
+			t.Errorf("%s: dummy hash %s was made with the wrong algorithm", alg, hash)
+		}
+	}
+	if cost, _ := bcrypt.Cost(dummyHash(testHashConfig(config.HashBcrypt))); cost != 4 {
+		t.Errorf("bcrypt dummy hash has cost %d, want 4", cost)
+	}
+}
@@ -0,0 +1,380 @@
+package accts
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// what failed logins are counted against
+const (
+	LockoutUsername = "username"
+	LockoutIP       = "ip"
+)
+
+// what password reset requests are counted against
+const (
+	ResetUsername = "reset_username"
+	ResetIP       = "reset_ip"
+)
+
+// the failed logins counted against a username or an ip address
+type Lockout struct {
+	Kind        string     `db:"kind" json:"kind"`
+	Key         string     `db:"key" json:"key"`
+	Failures    int        `db:"failures" json:"failures"`
+	LastFailure time.Time  `db:"last_failure" json:"last_failure"`
+	LockedUntil *time.Time `db:"locked_until" json:"locked_until"`
+}
+
+// counts another failed login. failures older than the window
+// are forgotten first, and reaching the threshold locks it out for window
+func (lock *Lockout) fail(now time.Time, threshold int, window time.Duration) {
+	if now.Sub(lock.LastFailure) > window {
+		lock.Failures = 0
+		lock.LockedUntil = nil
+	}
+	lock.Failures++
+	lock.LastFailure = now
+	if lock.Failures >= threshold {
+		lockedUntil := now.Add(window)
+		lock.LockedUntil = &lockedUntil
+	}
+}
+
+// returns how long until another login is allowed and whether
+// that's because of a lockout rather than the backoff after a failure.
+// the backoff starts at base and doubles with each failure up to limit
+func (lock *Lockout) retryAfter(now time.Time, base, limit time.Duration) (time.Duration, bool) {
+	if lock.LockedUntil != nil && now.Before(*lock.LockedUntil) {
+		return lock.LockedUntil.Sub(now), true
+	}
+	if lock.Failures == 0 || base <= 0 {
+		return 0, false
+	}
+
+	wait := limit
+	if shift := lock.Failures - 1; shift < 32 && base<<shift < limit {
+		wait = base << shift
+	}
+	if next := lock.LastFailure.Add(wait); now.Before(next) {
+		return next.Sub(now), false
+	}
+	return 0, false
+}
+
+// takes back a failure counted by attempt. when nothing was counted
+// since, the lock is put back the way it was before the attempt and
+// drop reports whether it didn't exist then. otherwise the count is
+// only lowered, since later failures have to keep counting
+func (lock *Lockout) forgive(attempt *LoginAttempt, threshold int) (drop bool) {
+	prev := attempt.prev[[2]string{lock.Kind, lock.Key}]
+	if lock.LastFailure.Equal(attempt.At) {
+		*lock = prev
+		return prev.Failures == 0
+	}
+	if lock.Failures > 0 {
+		lock.Failures--
+	}
+	if lock.Failures < threshold {
+		lock.LockedUntil = nil
+	}
+	return false
+}
+
+// a login that BeginLogin let through. it's counted as a failure as soon
+// as it starts, so parallel guesses can't all pass the check before any
+// of them is counted. ForgiveLogin takes the count back if it doesn't fail
+type LoginAttempt struct {
+	Username string
+	IP       string
+	At       time.Time             // when it was counted
+	prev     map[[2]string]Lockout // the locks as they were before it
+}
+
+// returns the kind and key failed logins are counted under
+// for the ip and for the username, in the order their rows are locked
+func loginKeys(username, ip string) [][2]string {
+	return [][2]string{{LockoutIP, ip}, {LockoutUsername, truncate(username, 255)}}
+}
+
+// returns the kind and key reset requests are counted under
+// for the ip and for the username, in the order their rows are locked
+func resetKeys(username, ip string) [][2]string {
+	return [][2]string{{ResetIP, ip}, {ResetUsername, truncate(username, 255)}}
+}
+
+// reports whether failed logins or reset requests are counted under the kind
+func validLockoutKind(kind string) bool {
+	switch kind {
+	case LockoutUsername, LockoutIP, ResetUsername, ResetIP:
+		return true
+	}
+	return false
+}
+
+// returns the threshold for the kind of lockout
+func (set *settings) lockoutThreshold(kind string) int {
+	switch kind {
+	case LockoutIP:
+		return set.conf.LockoutIPThreshold
+	case ResetUsername:
+		return set.conf.ResetThreshold
+	case ResetIP:
+		return set.conf.ResetIPThreshold
+	}
+	return set.conf.LockoutThreshold
+}
+
+// checks whether a login for the username from the ip is allowed right now
+// and counts it as a failure in the same transaction if it is.
+// a locked username is a 423, a locked ip or a login too soon after
+// a failed one is a 429. the returned duration is when to try again
+func (pg *Postgres) BeginLogin(ctx context.Context, username, ip string, fieldErr *fields.GrammarError) (*LoginAttempt, time.Duration) {
+	attempt := &LoginAttempt{
+		Username: username,
+		IP:       ip,
+		At:       time.Now().Truncate(time.Microsecond), // what timestamptz keeps
+		prev:     make(map[[2]string]Lockout),
+	}
+	var wait time.Duration
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		// the upsert locks each row until the transaction ends, so a parallel
+		// attempt waits here and then sees this one counted
+		var locks []*Lockout
+		for _, key := range loginKeys(username, ip) {
+			lock, err := upsertLock(ctx, q, key)
+			if err != nil {
+				return err
+			}
+			if lock.Failures == 0 {
+				// the row was just made, there was nothing to count against before
+				attempt.prev[key] = Lockout{Kind: key[0], Key: key[1]}
+			} else {
+				attempt.prev[key] = *lock
+			}
+			locks = append(locks, lock)
+		}
+
+		wait = pg.checkLocks(locks, attempt.At, fieldErr)
+		if fieldErr.ErrMsgs != nil {
+			return bikeshop.ErrRollback
+		}
+		for _, lock := range locks {
+			lock.fail(attempt.At, pg.lockoutThreshold(lock.Kind), pg.conf.LockoutDuration.Duration)
+			if err := updateLock(ctx, q, lock); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, wait
+	}
+	return attempt, 0
+}
+
+// helper funct that reports the longest wait the locks impose
+// and adds the error a login gets while any of them is in effect
+func (set *settings) checkLocks(locks []*Lockout, now time.Time, fieldErr *fields.GrammarError) time.Duration {
+	var wait time.Duration
+	var usernameLocked, ipLocked bool
+	for _, lock := range locks {
+		retry, locked := lock.retryAfter(now, set.conf.LoginBackoff.Duration, set.conf.LockoutDuration.Duration)
+		if retry > wait {
+			wait = retry
+		}
+		if locked && lock.Kind == LockoutUsername {
+			usernameLocked = true
+		}
+		if locked && lock.Kind == LockoutIP {
+			ipLocked = true
+		}
+	}
+
+	switch {
+	case usernameLocked:
+		fieldErr.AddMsg(fields.Locked, "", fields.CodeAccountLocked,
+			"Locked: too many failed logins, the account is temporarily locked")
+	case ipLocked:
+		fieldErr.AddMsg(fields.TooManyRequests, "", fields.CodeTooManyAttempts,
+			"Too Many Requests: too many failed logins from this address, try again later")
+	case wait > 0:
+		fieldErr.AddMsg(fields.TooManyRequests, "", fields.CodeTooManyAttempts,
+			"Too Many Requests: wait before trying to log in again")
+	}
+	return wait
+}
+
+// helper funct that returns the row failures are counted in under the key,
+// making it when there isn't one. the row stays locked until the transaction ends
+func upsertLock(ctx context.Context, q bikeshop.Querier, key [2]string) (*Lockout, error) {
+	var lock Lockout
+	rows, _ := q.Query(ctx,
+		`INSERT INTO login_attempts (kind, key) VALUES($1, $2)
+		ON CONFLICT (kind, key) DO UPDATE SET kind=EXCLUDED.kind
+		RETURNING *`,
+		key[0], key[1],
+	)
+	if err := pgxscan.ScanOne(&lock, rows); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// counts a password reset request against the username and the ip,
+// refusing it with a 429 once either has requested too many.
+// unknown usernames are counted too so the limit doesn't reveal which exist
+func (pg *Postgres) countReset(ctx context.Context, username, ip string, fieldErr *fields.GrammarError) {
+	now := time.Now().Truncate(time.Microsecond)
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var locks []*Lockout
+		for _, key := range resetKeys(username, ip) {
+			lock, err := upsertLock(ctx, q, key)
+			if err != nil {
+				return err
+			}
+			locks = append(locks, lock)
+		}
+
+		if pg.checkResets(locks, now, fieldErr); fieldErr.ErrMsgs != nil {
+			return bikeshop.ErrRollback
+		}
+		for _, lock := range locks {
+			lock.fail(now, pg.lockoutThreshold(lock.Kind), pg.conf.LockoutDuration.Duration)
+			if err := updateLock(ctx, q, lock); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+}
+
+// helper funct that adds the error a reset request gets
+// while the username or the ip has requested too many
+func (set *settings) checkResets(locks []*Lockout, now time.Time, fieldErr *fields.GrammarError) {
+	for _, lock := range locks {
+		if lock.LockedUntil != nil && now.Before(*lock.LockedUntil) {
+			fieldErr.AddMsg(fields.TooManyRequests, "", fields.CodeTooManyAttempts,
+				"Too Many Requests: too many password resets requested, try again later")
+			return
+		}
+	}
+}
+
+// helper funct that writes a lock's counts back to its row
+func updateLock(ctx context.Context, q bikeshop.Querier, lock *Lockout) error {
+	_, err := q.Exec(ctx,
+		`UPDATE login_attempts SET failures=$3, last_failure=$4, locked_until=$5 WHERE kind=$1 AND key=$2`,
+		lock.Kind, lock.Key, lock.Failures, lock.LastFailure, lock.LockedUntil,
+	)
+	return err
+}
+
+// takes back the failure BeginLogin counted for a login that didn't fail,
+// like one that logged in or was cut short by an error.
+// the username is counted even when it doesn't exist
+// so lockouts don't reveal which usernames do
+func (pg *Postgres) ForgiveLogin(ctx context.Context, attempt *LoginAttempt, fieldErr *fields.GrammarError) {
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		for _, key := range loginKeys(attempt.Username, attempt.IP) {
+			var lock Lockout
+			rows, _ := q.Query(ctx, `SELECT * FROM login_attempts WHERE kind=$1 AND key=$2 FOR UPDATE`, key[0], key[1])
+			err := pgxscan.ScanOne(&lock, rows)
+			if errors.Is(err, pgx.ErrNoRows) {
+				// cleared by an admin or a login meanwhile
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if lock.forgive(attempt, pg.lockoutThreshold(key[0])) {
+				_, err = q.Exec(ctx, `DELETE FROM login_attempts WHERE kind=$1 AND key=$2`, key[0], key[1])
+			} else {
+				err = updateLock(ctx, q, &lock)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+}
+
+// forgets the failed logins of the username after it logs in.
+// the ip's failures are kept so logging into one account
+// doesn't reset the count for guessing at others
+func (pg *Postgres) ClearLoginFailures(ctx context.Context, username string, fieldErr *fields.GrammarError) {
+	db := pg.store.Pool
+
+	_, err := db.Exec(ctx,
+		`DELETE FROM login_attempts WHERE kind=$1 AND key=$2`,
+		LockoutUsername, truncate(username, 255),
+	)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+	}
+}
+
+// returns every username and ip that's locked out
+// or still has failed logins counted against it
+func (pg *Postgres) ReadLockouts(ctx context.Context) ([]*Lockout, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var locks []*Lockout
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx,
+		`SELECT * FROM login_attempts WHERE locked_until > $1 OR last_failure > $2
+		ORDER BY last_failure DESC`,
+		time.Now(), time.Now().Add(-pg.conf.LockoutDuration.Duration),
+	)
+	err := pgxscan.ScanAll(&locks, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return locks, fieldErr
+}
+
+// lifts the lockout of a username or ip and forgets its failed logins
+func (pg *Postgres) ClearLockout(ctx context.Context, kind, key string) ([]*Lockout, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var lock Lockout
+	fieldErr := fields.GrammarError{}
+	if !validLockoutKind(kind) {
+		fieldErr.AddMsg(BadRequest, "kind", fields.CodeValidation,
+			"Error: kind must be "+LockoutUsername+", "+LockoutIP+", "+ResetUsername+" or "+ResetIP)
+		return nil, fieldErr
+	}
+
+	rows, _ := db.Query(ctx, `DELETE FROM login_attempts WHERE kind=$1 AND key=$2 RETURNING *`, kind, key)
+	err := pgxscan.ScanOne(&lock, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: no failed logins are counted against "+kind+" "+key)
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return []*Lockout{&lock}, fieldErr
+}
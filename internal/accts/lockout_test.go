@@ -0,0 +1,95 @@
+package accts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutBackoffDoubles(t *testing.T) {
+	now := time.Now()
+	lock := &Lockout{}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		lock.fail(now, 5, time.Minute)
+		retry, locked := lock.retryAfter(now, time.Second, time.Minute)
+		if retry != want || locked {
+			t.Errorf("after %d failures: retry = %v locked = %v, want %v and not locked", i+1, retry, locked, want)
+		}
+	}
+
+	if retry, _ := lock.retryAfter(now.Add(5*time.Second), time.Second, time.Minute); retry != 0 {
+		t.Errorf("retry = %v once the backoff has passed, want 0", retry)
+	}
+}
+
+func TestLockoutLocksAtThreshold(t *testing.T) {
+	now := time.Now()
+	lock := &Lockout{}
+	for i := 0; i < 3; i++ {
+		lock.fail(now, 3, time.Minute)
+	}
+
+	retry, locked := lock.retryAfter(now, time.Second, time.Minute)
+	if !locked || retry != time.Minute {
+		t.Errorf("retry = %v locked = %v, want a minute long lockout", retry, locked)
+	}
+	if _, locked := lock.retryAfter(now.Add(2*time.Minute), time.Second, time.Minute); locked {
+		t.Error("the lockout should be over after the window")
+	}
+}
+
+func TestLockoutForgetsOldFailures(t *testing.T) {
+	now := time.Now()
+	lock := &Lockout{}
+	lock.fail(now, 3, time.Minute)
+	lock.fail(now, 3, time.Minute)
+	lock.fail(now.Add(2*time.Minute), 3, time.Minute)
+
+	if lock.Failures != 1 || lock.LockedUntil != nil {
+		t.Errorf("failures = %d locked until %v, want the count to start over", lock.Failures, lock.LockedUntil)
+	}
+}
+
+func TestLockoutBackoffIsCapped(t *testing.T) {
+	now := time.Now()
+	lock := &Lockout{Failures: 100, LastFailure: now}
+	if retry, _ := lock.retryAfter(now, time.Second, time.Minute); retry != time.Minute {
+		t.Errorf("retry = %v, want the one minute cap", retry)
+	}
+}
+
+func TestLockoutForgiveRestoresTheAttempt(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	now := time.Now()
+	key := [2]string{LockoutIP, "192.0.2.1"}
+	prev := Lockout{Kind: LockoutIP, Key: "192.0.2.1", Failures: 2, LastFailure: before}
+	attempt := &LoginAttempt{At: now, prev: map[[2]string]Lockout{key: prev}}
+
+	lock := prev
+	lock.fail(now, 3, time.Minute)
+	if lock.LockedUntil == nil {
+		t.Fatal("the counted attempt should have reached the threshold")
+	}
+	if drop := lock.forgive(attempt, 3); drop || lock != prev {
+		t.Errorf("got %+v drop = %v, want the lock from before the attempt", lock, drop)
+	}
+
+	fresh := &LoginAttempt{At: now, prev: map[[2]string]Lockout{key: {Kind: LockoutIP, Key: "192.0.2.1"}}}
+	lock = Lockout{Kind: LockoutIP, Key: "192.0.2.1"}
+	lock.fail(now, 3, time.Minute)
+	if drop := lock.forgive(fresh, 3); !drop {
+		t.Error("a lock the attempt made should be dropped")
+	}
+}
+
+func TestLockoutForgiveKeepsLaterFailures(t *testing.T) {
+	now := time.Now()
+	key := [2]string{LockoutUsername, "johnny"}
+	attempt := &LoginAttempt{At: now, prev: map[[2]string]Lockout{key: {Kind: LockoutUsername, Key: "johnny"}}}
+
+	lock := Lockout{Kind: LockoutUsername, Key: "johnny"}
+	lock.fail(now, 2, time.Minute)
+	lock.fail(now.Add(time.Millisecond), 2, time.Minute) // a parallel guess that failed
+	if drop := lock.forgive(attempt, 2); drop || lock.Failures != 1 || lock.LockedUntil != nil {
+		t.Errorf("got %+v drop = %v, want the other failure still counted and no lockout", lock, drop)
+	}
+}
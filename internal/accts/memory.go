@@ -0,0 +1,1153 @@
+package accts
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/ScriptMang/conch/internal/totp"
+)
+
+// an AccountStore and TokenStore that keeps everything in memory
+// instead of the bikeshop database, so the http api can be tested without one.
+// it answers with the same errors Postgres does and is safe for concurrent use
+type Memory struct {
+	*settings
+	mu       sync.Mutex
+	lastID   map[string]int         // the last id handed out, by table
+	users    map[int]*memUser       // by user id
+	sessions map[int]*Tokens        // by id, holding digests like the tokens table
+	resets   map[int]*passwordReset // by id
+	lockouts map[[2]string]*Lockout // by kind and key
+	apiKeys  map[int]*APIKey        // by id
+	onDelete []func(userID int)     // called after an account is deleted
+}
+
+// an account along with the rows that hang off it in the database
+type memUser struct {
+	Usernames
+	contact  UserContacts
+	password []byte
+	totp     *totpSecret
+	recovery map[string]*time.Time // digest of each recovery code to when it was used
+}
+
+var (
+	_ AccountStore = (*Memory)(nil)
+	_ TokenStore   = (*Memory)(nil)
+)
+
+// returns an empty in-memory store with the token lifetimes,
+// hashing and policies of the config
+func NewMemory(cfg *config.Config) (*Memory, error) {
+	set, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Memory{
+		settings: set,
+		lastID:   make(map[string]int),
+		users:    make(map[int]*memUser),
+		sessions: make(map[int]*Tokens),
+		resets:   make(map[int]*passwordReset),
+		lockouts: make(map[[2]string]*Lockout),
+		apiKeys:  make(map[int]*APIKey),
+	}, nil
+}
+
+// registers fn to be called with the id of every deleted account once its
+// rows are gone. it's how rows kept outside the store, like invoices,
+// follow the database's ON DELETE CASCADE
+func (mem *Memory) OnDelete(fn func(userID int)) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.onDelete = append(mem.onDelete, fn)
+}
+
+// hands out the next id for table like its serial column would
+// the caller must hold mu
+func (mem *Memory) nextID(table string) int {
+	mem.lastID[table]++
+	return mem.lastID[table]
+}
+
+// adds the error for a request that ended before it reached the store
+func memCtxErr(ctx context.Context, fieldErr *fields.GrammarError) bool {
+	return fieldErr.AddCtxErr(ctx, ctx.Err())
+}
+
+// returns the account with username, or nil
+// the caller must hold mu
+func (mem *Memory) userByName(username string) *memUser {
+	for _, user := range mem.users {
+		if user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
+
+// returns every account ordered by id
+// the caller must hold mu
+func (mem *Memory) sortedUsers() []*memUser {
+	users := make([]*memUser, 0, len(mem.users))
+	for _, user := range mem.users {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b *memUser) int { return a.ID - b.ID })
+	return users
+}
+
+// returns the error the unique constraints on Usernames and UserContacts
+// raise when an account other than userID has the same username or contact
+// the caller must hold mu
+func (mem *Memory) checkUnique(userID int, username, fname, lname, address string) error {
+	for _, user := range mem.users {
+		if user.ID != userID && user.Username == username {
+			return fields.ErrDuplicateUsername
+		}
+	}
+	for _, user := range mem.users {
+		contact := user.contact
+		if user.ID != userID && contact.Fname == fname && contact.Lname == lname && contact.Address == address {
+			return fields.ErrDuplicateContact
+		}
+	}
+	return nil
+}
+
+// the account as shown to admins
+func (user *memUser) info() AccountInfo {
+	return AccountInfo{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Fname:    user.contact.Fname,
+		Lname:    user.contact.Lname,
+		Address:  user.contact.Address,
+	}
+}
+
+func (mem *Memory) AddAccount(ctx context.Context, acct *Account) (*Registered, fields.GrammarError) {
+	acctErr := &fields.GrammarError{}
+	mem.validateAccount(acct, acctErr)
+	if acctErr.ErrMsgs != nil {
+		return nil, *acctErr
+	}
+	hashedPswd := mem.hashPassword(acct, acctErr)
+	if acctErr.ErrMsgs != nil || memCtxErr(ctx, acctErr) {
+		return nil, *acctErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if err := mem.checkUnique(0, acct.Username, acct.Fname, acct.Lname, acct.Address); err != nil {
+		acctErr.AddDBErr(err)
+		return nil, *acctErr
+	}
+
+	acct.ID = mem.nextID("usernames")
+	mem.users[acct.ID] = &memUser{
+		Usernames: Usernames{ID: acct.ID, Username: acct.Username, Role: RoleCustomer},
+		contact: UserContacts{
+			ID:      mem.nextID("usercontacts"),
+			UserID:  acct.ID,
+			Fname:   acct.Fname,
+			Lname:   acct.Lname,
+			Address: acct.Address,
+		},
+		password: hashedPswd,
+	}
+	return &Registered{acct.ID, "registered"}, *acctErr
+}
+
+func (mem *Memory) Authenticate(ctx context.Context, username, password string, fieldErr *fields.GrammarError) int {
+	if memCtxErr(ctx, fieldErr) {
+		return 0
+	}
+
+	var userID int
+	var hash []byte
+	mem.mu.Lock()
+	if user := mem.userByName(username); user != nil {
+		userID, hash = user.ID, user.password
+	}
+	mem.mu.Unlock()
+
+	// the hash is checked without holding the lock since it's slow
+	// an unknown username is checked against a dummy hash to take as long
+	ok, stale := false, false
+	if userID != 0 {
+		ok, stale = verifySecret(hash, password, mem.conf)
+	} else {
+		verifySecret(dummyHash(mem.conf), password, mem.conf)
+	}
+	if !ok {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeInvalidCreds, "Unauthorized: username or password is incorrect")
+		return 0
+	}
+	if stale {
+		mem.rehashPassword(userID, hash, password)
+	}
+	return userID
+}
+
+// replaces a hash made with old settings, like rehashPassword
+// it's only swapped if it's still the one that was checked
+func (mem *Memory) rehashPassword(userID int, old []byte, password string) {
+	hash, err := hashSecret(password, mem.conf)
+	if err != nil {
+		log.Printf("rehashing the password of user %d: %v", userID, err)
+		return
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if user, ok := mem.users[userID]; ok && bytes.Equal(user.password, old) {
+		user.password = hash
+	}
+}
+
+func (mem *Memory) VerifySecondFactor(ctx context.Context, userID int, code string, fieldErr *fields.GrammarError) {
+	if memCtxErr(ctx, fieldErr) {
+		return
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok || user.totp == nil || user.totp.ConfirmedAt == nil {
+		return
+	}
+
+	if code == "" {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeTOTPRequired,
+			"Unauthorized: two-factor login is enabled, send a code in the X-TOTP-Code header")
+		return
+	}
+
+	now := time.Now()
+	if step, ok := totp.Validate(user.totp.Secret, code, now, totpSkew); ok && step > user.totp.LastStep {
+		user.totp.LastStep = step
+		return
+	}
+	hashed := digest(normalizeRecoveryCode(code))
+	if usedAt, ok := user.recovery[hashed]; ok && usedAt == nil {
+		user.recovery[hashed] = &now
+		return
+	}
+	fieldErr.AddMsg(unauthorized, "", fields.CodeInvalidTOTP, "Unauthorized: two-factor code is incorrect")
+}
+
+func (mem *Memory) ReadUserContact(ctx context.Context) ([]*UserContacts, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	var usrContacts []*UserContacts
+	for _, user := range mem.sortedUsers() {
+		contact := user.contact
+		usrContacts = append(usrContacts, &contact)
+	}
+	return usrContacts, fieldErr
+}
+
+func (mem *Memory) ReadUserContactByID(ctx context.Context, id int) ([]*UserContacts, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[id]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id does not exist")
+		return nil, fieldErr
+	}
+	contact := user.contact
+	return []*UserContacts{&contact}, fieldErr
+}
+
+func (mem *Memory) ReadUsernameByID(ctx context.Context, userID int, fieldErr *fields.GrammarError) []*Usernames {
+	if memCtxErr(ctx, fieldErr) {
+		return nil
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: username with specified id doesn't exist")
+		return nil
+	}
+	usr := user.Usernames
+	return []*Usernames{&usr}
+}
+
+func (mem *Memory) ReadAccounts(ctx context.Context) ([]*AccountInfo, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	var accounts []*AccountInfo
+	for _, user := range mem.sortedUsers() {
+		account := user.info()
+		accounts = append(accounts, &account)
+	}
+	return accounts, fieldErr
+}
+
+func (mem *Memory) ReadAccountByID(ctx context.Context, userID int) ([]*AccountInfo, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id does not exist")
+		return nil, fieldErr
+	}
+	account := user.info()
+	return []*AccountInfo{&account}, fieldErr
+}
+
+func (mem *Memory) SetRole(ctx context.Context, userID int, role string) ([]*Usernames, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if !ValidRole(role) {
+		fieldErr.AddMsg(BadRequest, "role", fields.CodeInvalidRole,
+			"Error: role must be one of "+RoleCustomer+", "+RoleStaff+" or "+RoleAdmin)
+		return nil, fieldErr
+	}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id does not exist")
+		return nil, fieldErr
+	}
+	user.Role = role
+	mem.revokeSessions(mem.userSessions(userID, "")...)
+
+	usr := user.Usernames
+	return []*Usernames{&usr}, fieldErr
+}
+
+func (mem *Memory) UpdateProfile(ctx context.Context, userID int, edit ProfileEdit) ([]*AccountInfo, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	edit.check(&fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	return mem.editProfile(ctx, userID, &edit, false)
+}
+
+func (mem *Memory) PatchProfile(ctx context.Context, userID int, edit ProfileEdit) ([]*AccountInfo, fields.GrammarError) {
+	return mem.editProfile(ctx, userID, &edit, true)
+}
+
+// changes the account's username and contact, like editProfile
+func (mem *Memory) editProfile(ctx context.Context, userID int, edit *ProfileEdit, patch bool) ([]*AccountInfo, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return nil, fieldErr
+	}
+
+	if patch {
+		edit.patch(user.info(), &fieldErr)
+		if fieldErr.ErrMsgs != nil {
+			return nil, fieldErr
+		}
+	}
+	if err := mem.checkUnique(userID, edit.Username, edit.Fname, edit.Lname, edit.Address); err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+
+	user.Username = edit.Username
+	user.contact.Fname = edit.Fname
+	user.contact.Lname = edit.Lname
+	user.contact.Address = edit.Address
+	account := user.info()
+	return []*AccountInfo{&account}, fieldErr
+}
+
+func (mem *Memory) DeleteAcct(ctx context.Context, user Usernames) ([]*Usernames, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	if mem.userByName(user.Username) == nil {
+		mem.mu.Unlock()
+		fieldErr.AddMsg(resourceNotFound, "username", fields.CodeNotFound, "Error: username is incorrect")
+		return nil, fieldErr
+	}
+	deleted, ok := mem.users[user.ID]
+	if !ok {
+		mem.mu.Unlock()
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return nil, fieldErr
+	}
+
+	mem.endSessions(user.ID, "")
+	delete(mem.users, user.ID)
+	for id, reset := range mem.resets {
+		if reset.UserID == user.ID {
+			delete(mem.resets, id)
+		}
+	}
+	for id, key := range mem.apiKeys {
+		if key.UserID == user.ID {
+			delete(mem.apiKeys, id)
+		}
+	}
+	onDelete := mem.onDelete
+	mem.mu.Unlock()
+
+	// called without the lock so they're free to use the store
+	for _, fn := range onDelete {
+		fn(user.ID)
+	}
+	usr := deleted.Usernames
+	return []*Usernames{&usr}, fieldErr
+}
+
+// returns the account's username and password hash
+func (mem *Memory) readCredentials(ctx context.Context, userID int, fieldErr *fields.GrammarError) (string, []byte) {
+	if memCtxErr(ctx, fieldErr) {
+		return "", nil
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return "", nil
+	}
+	return user.Username, user.password
+}
+
+// confirms that password is the user's current one, like checkPassword
+func (mem *Memory) checkPassword(ctx context.Context, userID int, field, password string, fieldErr *fields.GrammarError) {
+	_, hash := mem.readCredentials(ctx, userID, fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return
+	}
+	if ok, _ := verifySecret(hash, password, mem.conf); !ok {
+		fieldErr.AddMsg(fields.Forbidden, field, fields.CodeInvalidCreds, "Forbidden: "+strings.ReplaceAll(field, "_", " ")+" is incorrect")
+	}
+}
+
+// replaces the user's password hash and logs out every session
+// but the one whose digest is keep. the caller must hold mu
+func (mem *Memory) setPassword(userID int, hashedPswd []byte, keep string, fieldErr *fields.GrammarError) {
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return
+	}
+	user.password = hashedPswd
+	mem.endSessions(userID, keep)
+}
+
+func (mem *Memory) ChangePassword(ctx context.Context, userID int, rqstToken, current, next string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	mem.checkPassword(ctx, userID, "current_password", current, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	username, _ := mem.readCredentials(ctx, userID, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	hashedPswd := mem.checkNewPassword(next, username, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.setPassword(userID, hashedPswd, mem.tokenDigest(rqstToken), &fieldErr)
+	return fieldErr
+}
+
+// the token is issued before returning rather than in the background,
+// nothing here takes long enough to reveal whether the username exists
+func (mem *Memory) RequestPasswordReset(ctx context.Context, username, ip string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return fieldErr
+	}
+
+	token, _ := randHex(32)
+	now := time.Now()
+	expiresAt := now.Add(mem.conf.ResetTokenTTL.Duration)
+
+	mem.mu.Lock()
+	var locks []*Lockout
+	for _, key := range resetKeys(username, ip) {
+		lock, ok := mem.lockouts[key]
+		if !ok {
+			lock = &Lockout{Kind: key[0], Key: key[1]}
+		}
+		locks = append(locks, lock)
+	}
+	if mem.checkResets(locks, now, &fieldErr); fieldErr.ErrMsgs != nil {
+		mem.mu.Unlock()
+		return fieldErr
+	}
+	for _, lock := range locks {
+		lock.fail(now, mem.lockoutThreshold(lock.Kind), mem.conf.LockoutDuration.Duration)
+		mem.lockouts[[2]string{lock.Kind, lock.Key}] = lock
+	}
+
+	user := mem.userByName(username)
+	if user == nil {
+		mem.mu.Unlock()
+		return fieldErr
+	}
+	userID := user.ID
+	for id, reset := range mem.resets {
+		if reset.UserID == userID && reset.UsedAt == nil {
+			delete(mem.resets, id)
+		}
+	}
+	id := mem.nextID("password_resets")
+	mem.resets[id] = &passwordReset{ID: id, UserID: userID, Token: digest(token), CreatedAt: now, ExpiresAt: expiresAt}
+	mem.mu.Unlock()
+
+	mem.sendResetToken(ctx, userID, username, token, expiresAt)
+	return fieldErr
+}
+
+// returns the reset whose token has the given digest, or nil
+// the caller must hold mu
+func (mem *Memory) resetByToken(hashed string) *passwordReset {
+	for _, reset := range mem.resets {
+		if reset.Token == hashed {
+			return reset
+		}
+	}
+	return nil
+}
+
+func (mem *Memory) ResetPassword(ctx context.Context, token, next string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return fieldErr
+	}
+
+	// the token is checked first and the password is
+	// hashed before the lock is taken, like ResetPassword
+	var userID int
+	mem.mu.Lock()
+	if reset := mem.resetByToken(digest(token)); reset == nil {
+		fieldErr.AddMsg(BadRequest, "token", fields.CodeInvalidToken, "Error: reset token isn't valid")
+	} else if reset.usable(time.Now(), &fieldErr) {
+		userID = reset.UserID
+	}
+	mem.mu.Unlock()
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	username, _ := mem.readCredentials(ctx, userID, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	hashedPswd := mem.checkNewPassword(next, username, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	reset := mem.resetByToken(digest(token))
+	if reset == nil {
+		fieldErr.AddMsg(BadRequest, "token", fields.CodeInvalidToken, "Error: reset token isn't valid")
+		return fieldErr
+	}
+	now := time.Now()
+	if !reset.usable(now, &fieldErr) {
+		return fieldErr
+	}
+	mem.setPassword(reset.UserID, hashedPswd, "", &fieldErr)
+	if fieldErr.ErrMsgs == nil {
+		reset.UsedAt = &now
+	}
+	return fieldErr
+}
+
+func (mem *Memory) EnrollTOTP(ctx context.Context, userID int) ([]*TOTPEnrollment, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	secret, _ := totp.NewSecret()
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: username with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	if user.totp != nil && user.totp.ConfirmedAt != nil {
+		fieldErr.AddMsg(fields.Conflict, "", fields.CodeTOTPEnabled,
+			"Conflict: two-factor login is already enabled, disable it before enrolling again")
+		return nil, fieldErr
+	}
+
+	user.totp = &totpSecret{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+	enrollment := &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Username, secret),
+	}
+	return []*TOTPEnrollment{enrollment}, fieldErr
+}
+
+func (mem *Memory) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok || user.totp == nil {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound,
+			"Resource Not Found: start enrolling with POST /user/2fa before confirming")
+		return nil, fieldErr
+	}
+	if user.totp.ConfirmedAt != nil {
+		fieldErr.AddMsg(fields.Conflict, "", fields.CodeTOTPEnabled, "Conflict: two-factor login is already enabled")
+		return nil, fieldErr
+	}
+
+	now := time.Now()
+	step, ok := totp.Validate(user.totp.Secret, code, now, totpSkew)
+	if !ok {
+		fieldErr.AddMsg(BadRequest, "code", fields.CodeInvalidTOTP, "Error: two-factor code is incorrect")
+		return nil, fieldErr
+	}
+	user.totp.ConfirmedAt = &now
+	user.totp.LastStep = step
+
+	codes := make([]string, recoveryCodeCount)
+	user.recovery = make(map[string]*time.Time, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		user.recovery[digest(normalizeRecoveryCode(codes[i]))] = nil
+	}
+	return codes, fieldErr
+}
+
+func (mem *Memory) DisableTOTP(ctx context.Context, userID int, password string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	mem.checkPassword(ctx, userID, "password", password, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	return mem.RemoveTOTP(ctx, userID)
+}
+
+func (mem *Memory) RemoveTOTP(ctx context.Context, userID int) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok || user.totp == nil {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound,
+			"Resource Not Found: two-factor login isn't enabled for the user")
+		return fieldErr
+	}
+	user.totp = nil
+	user.recovery = nil
+	return fieldErr
+}
+
+func (mem *Memory) BeginLogin(ctx context.Context, username, ip string, fieldErr *fields.GrammarError) (*LoginAttempt, time.Duration) {
+	if memCtxErr(ctx, fieldErr) {
+		return nil, 0
+	}
+
+	attempt := &LoginAttempt{
+		Username: username,
+		IP:       ip,
+		At:       time.Now().Truncate(time.Microsecond),
+		prev:     make(map[[2]string]Lockout),
+	}
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	var locks []*Lockout
+	for _, key := range loginKeys(username, ip) {
+		lock, ok := mem.lockouts[key]
+		if !ok {
+			lock = &Lockout{Kind: key[0], Key: key[1]}
+		}
+		attempt.prev[key] = *lock
+		locks = append(locks, lock)
+	}
+
+	if wait := mem.checkLocks(locks, attempt.At, fieldErr); fieldErr.ErrMsgs != nil {
+		return nil, wait
+	}
+	for _, lock := range locks {
+		lock.fail(attempt.At, mem.lockoutThreshold(lock.Kind), mem.conf.LockoutDuration.Duration)
+		mem.lockouts[[2]string{lock.Kind, lock.Key}] = lock
+	}
+	return attempt, 0
+}
+
+func (mem *Memory) ForgiveLogin(ctx context.Context, attempt *LoginAttempt, fieldErr *fields.GrammarError) {
+	if memCtxErr(ctx, fieldErr) {
+		return
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	for _, key := range loginKeys(attempt.Username, attempt.IP) {
+		lock, ok := mem.lockouts[key]
+		if ok && lock.forgive(attempt, mem.lockoutThreshold(key[0])) {
+			delete(mem.lockouts, key)
+		}
+	}
+}
+
+func (mem *Memory) ClearLoginFailures(ctx context.Context, username string, fieldErr *fields.GrammarError) {
+	if memCtxErr(ctx, fieldErr) {
+		return
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	delete(mem.lockouts, [2]string{LockoutUsername, truncate(username, 255)})
+}
+
+func (mem *Memory) ReadLockouts(ctx context.Context) ([]*Lockout, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	now := time.Now()
+	var locks []*Lockout
+	for _, lock := range mem.lockouts {
+		locked := lock.LockedUntil != nil && lock.LockedUntil.After(now)
+		if locked || lock.LastFailure.After(now.Add(-mem.conf.LockoutDuration.Duration)) {
+			copied := *lock
+			locks = append(locks, &copied)
+		}
+	}
+	slices.SortFunc(locks, func(a, b *Lockout) int { return b.LastFailure.Compare(a.LastFailure) })
+	return locks, fieldErr
+}
+
+func (mem *Memory) ClearLockout(ctx context.Context, kind, key string) ([]*Lockout, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if !validLockoutKind(kind) {
+		fieldErr.AddMsg(BadRequest, "kind", fields.CodeValidation,
+			"Error: kind must be "+LockoutUsername+", "+LockoutIP+", "+ResetUsername+" or "+ResetIP)
+		return nil, fieldErr
+	}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	lock, ok := mem.lockouts[[2]string{kind, key}]
+	if !ok {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: no failed logins are counted against "+kind+" "+key)
+		return nil, fieldErr
+	}
+	delete(mem.lockouts, [2]string{kind, key})
+	return []*Lockout{lock}, fieldErr
+}
+
+// remembers that signed tokens were logged out, like revokeToken
+// does nothing in opaque token mode, where dropping the session is enough
+func (set *settings) revokeSessions(sessions ...*Tokens) {
+	if set.signer == nil || len(sessions) == 0 {
+		return
+	}
+	rows := make([]revokedToken, len(sessions))
+	for i, session := range sessions {
+		rows[i] = revokedToken{string(session.Token), session.ExpiresAt}
+	}
+	set.revoked.add(time.Now(), rows...)
+}
+
+// returns the user's sessions except the one whose digest is keep
+// the caller must hold mu
+func (mem *Memory) userSessions(userID int, keep string) []*Tokens {
+	var sessions []*Tokens
+	for _, session := range mem.sessions {
+		if session.UserID == userID && string(session.Token) != keep {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// logs the user out of every session except the one whose digest is keep
+// the caller must hold mu
+func (mem *Memory) endSessions(userID int, keep string) {
+	sessions := mem.userSessions(userID, keep)
+	mem.revokeSessions(sessions...)
+	for _, session := range sessions {
+		delete(mem.sessions, session.ID)
+	}
+}
+
+// returns the session whose access token, or refresh token when
+// refresh is set, has the given digest. the caller must hold mu
+func (mem *Memory) sessionByDigest(hashed string, refresh bool) *Tokens {
+	for _, session := range mem.sessions {
+		if (!refresh && string(session.Token) == hashed) || (refresh && session.RefreshToken == hashed) {
+			return session
+		}
+	}
+	return nil
+}
+
+// the session a tokens row is shown as, it's the current one
+// when the token's digest is current
+func sessionOf(token *Tokens, current string) *Session {
+	return &Session{
+		ID:         token.ID,
+		Token:      string(token.Token),
+		UserAgent:  token.UserAgent,
+		IPAddress:  token.IPAddress,
+		IssuedAt:   token.IssuedAt,
+		LastUsedAt: token.LastUsedAt,
+		ExpiresAt:  token.RefreshExpiresAt,
+		Current:    current != "" && string(token.Token) == current,
+	}
+}
+
+func (mem *Memory) GenerateToken(ctx context.Context, userID int, device Device, fieldErr *fields.GrammarError) Tokens {
+	if memCtxErr(ctx, fieldErr) {
+		return Tokens{}
+	}
+
+	token, _ := randHex(20)
+	refreshToken, _ := randHex(32)
+	now := time.Now()
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	user, ok := mem.users[userID]
+	if !ok {
+		fieldErr.AddDBErr(fields.ErrMissingReference)
+		return Tokens{}
+	}
+	session := &Tokens{
+		ID:               mem.nextID("tokens"),
+		UserID:           userID,
+		Token:            []byte(digest(token)),
+		IssuedAt:         now,
+		ExpiresAt:        now.Add(mem.conf.AccessTokenTTL.Duration),
+		RefreshToken:     digest(refreshToken),
+		RefreshExpiresAt: now.Add(mem.conf.RefreshTokenTTL.Duration),
+		LastUsedAt:       now,
+		UserAgent:        truncate(device.UserAgent, 255),
+		IPAddress:        truncate(device.IPAddress, 45),
+	}
+
+	newToken := *session
+	newToken.Token = []byte(token)
+	newToken.RefreshToken = refreshToken
+	mem.signAs(&newToken, user.Role, fieldErr)
+	if fieldErr.ErrMsgs == nil {
+		mem.sessions[session.ID] = session
+	}
+	return newToken
+}
+
+func (mem *Memory) ReadUserByToken(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) TokenUser {
+	if mem.signer != nil {
+		return mem.readUserBySignedToken(tgtToken, fieldErr)
+	}
+	if memCtxErr(ctx, fieldErr) {
+		return TokenUser{}
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	session := mem.sessionByDigest(digest(tgtToken), false)
+	if session == nil {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeUnauthorized, "Unauthorized: token doesn't belong to any user")
+		return TokenUser{}
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		fieldErr.AddMsg(unauthorized, "", fields.CodeTokenExpired, "Unauthorized: token has expired, use the refresh token to get a new one")
+		return TokenUser{}
+	}
+	return TokenUser{session.UserID, mem.users[session.UserID].Role, session.ExpiresAt}
+}
+
+func (mem *Memory) TouchToken(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) {
+	if mem.signer != nil || memCtxErr(ctx, fieldErr) {
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now
+	if mem.conf.SlidingSessions {
+		expiresAt = now.Add(mem.conf.AccessTokenTTL.Duration)
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if session := mem.sessionByDigest(digest(tgtToken), false); session != nil {
+		session.LastUsedAt = now
+		if expiresAt.After(session.ExpiresAt) {
+			session.ExpiresAt = expiresAt
+		}
+	}
+}
+
+func (mem *Memory) RefreshToken(ctx context.Context, refreshToken string, fieldErr *fields.GrammarError) Tokens {
+	if memCtxErr(ctx, fieldErr) {
+		return Tokens{}
+	}
+
+	token, _ := randHex(20)
+	newRefreshToken, _ := randHex(32)
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	session := mem.sessionByDigest(digest(refreshToken), true)
+	if session == nil {
+		fieldErr.AddMsg(unauthorized, "refresh_token", fields.CodeUnauthorized, "Unauthorized: refresh token isn't valid")
+		return Tokens{}
+	}
+	now := time.Now()
+	if !now.Before(session.RefreshExpiresAt) {
+		fieldErr.AddMsg(unauthorized, "refresh_token", fields.CodeTokenExpired, "Unauthorized: refresh token has expired, log in again")
+		return Tokens{}
+	}
+
+	replaced := *session
+	replaced.Token = []byte(digest(token))
+	replaced.IssuedAt = now
+	replaced.ExpiresAt = now.Add(mem.conf.AccessTokenTTL.Duration)
+	replaced.RefreshToken = digest(newRefreshToken)
+	replaced.RefreshExpiresAt = now.Add(mem.conf.RefreshTokenTTL.Duration)
+	replaced.LastUsedAt = now
+
+	newToken := replaced
+	newToken.Token = []byte(token)
+	newToken.RefreshToken = newRefreshToken
+	mem.signAs(&newToken, mem.users[session.UserID].Role, fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return Tokens{}
+	}
+
+	// the old signed token would stay valid until it expired
+	mem.revokeSessions(session)
+	*session = replaced
+	return newToken
+}
+
+func (mem *Memory) LogOut(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) Tokens {
+	hashed := mem.tokenDigest(tgtToken)
+	if memCtxErr(ctx, fieldErr) {
+		return Tokens{}
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	session := mem.sessionByDigest(hashed, false)
+	if session == nil {
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: session has already ended")
+		return Tokens{}
+	}
+	delete(mem.sessions, session.ID)
+	mem.revokeSessions(session)
+	return *session
+}
+
+func (mem *Memory) ReadSessions(ctx context.Context, userID int, tgtToken string) ([]*Session, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	current := mem.tokenDigest(tgtToken)
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	var sessions []*Session
+	for _, session := range mem.userSessions(userID, "") {
+		sessions = append(sessions, sessionOf(session, current))
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		if order := b.LastUsedAt.Compare(a.LastUsedAt); order != 0 {
+			return order
+		}
+		return b.ID - a.ID
+	})
+	return sessions, fieldErr
+}
+
+func (mem *Memory) RevokeSession(ctx context.Context, userID, sessionID int) ([]*Session, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	session, ok := mem.sessions[sessionID]
+	if !ok || session.UserID != userID {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: session with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	delete(mem.sessions, sessionID)
+	mem.revokeSessions(session)
+	return []*Session{sessionOf(session, "")}, fieldErr
+}
+
+func (mem *Memory) CreateAPIKey(ctx context.Context, userID int, name string, scopes []string) ([]*NewAPIKey, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	scopes = checkAPIKey(name, scopes, &fieldErr)
+	if fieldErr.ErrMsgs != nil || memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	key, prefix := newAPIKey()
+	name = strings.TrimSpace(name)
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if _, ok := mem.users[userID]; !ok {
+		fieldErr.AddDBErr(fields.ErrMissingReference)
+		return nil, fieldErr
+	}
+	for _, existing := range mem.apiKeys {
+		if existing.UserID == userID && existing.Name == name {
+			fieldErr.AddDBErr(fields.ErrDuplicateAPIKeyName)
+			return nil, fieldErr
+		}
+	}
+
+	stored := &APIKey{
+		ID:        mem.nextID("api_keys"),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Digest:    digest(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	mem.apiKeys[stored.ID] = stored
+	return []*NewAPIKey{{APIKey: *stored, Key: key}}, fieldErr
+}
+
+func (mem *Memory) ReadAPIKeys(ctx context.Context, userID int) ([]*APIKey, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	keys := []*APIKey{}
+	for _, key := range mem.apiKeys {
+		if key.UserID == userID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	slices.SortFunc(keys, func(a, b *APIKey) int {
+		if order := b.CreatedAt.Compare(a.CreatedAt); order != 0 {
+			return order
+		}
+		return b.ID - a.ID
+	})
+	return keys, fieldErr
+}
+
+func (mem *Memory) RevokeAPIKey(ctx context.Context, userID, keyID int) ([]*APIKey, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if memCtxErr(ctx, &fieldErr) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	key, ok := mem.apiKeys[keyID]
+	if !ok || key.UserID != userID {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: api key with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	delete(mem.apiKeys, keyID)
+	return []*APIKey{key}, fieldErr
+}
+
+func (mem *Memory) ReadUserByAPIKey(ctx context.Context, key string, fieldErr *fields.GrammarError) KeyUser {
+	if memCtxErr(ctx, fieldErr) {
+		return KeyUser{}
+	}
+
+	hashed := digest(key)
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	for _, stored := range mem.apiKeys {
+		if stored.Digest == hashed {
+			return KeyUser{stored.ID, stored.UserID, mem.users[stored.UserID].Role, stored.Scopes}
+		}
+	}
+	fieldErr.AddMsg(unauthorized, "", fields.CodeUnauthorized, "Unauthorized: api key doesn't belong to any user")
+	return KeyUser{}
+}
+
+func (mem *Memory) TouchAPIKey(ctx context.Context, keyID int, fieldErr *fields.GrammarError) {
+	if memCtxErr(ctx, fieldErr) {
+		return
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	if key, ok := mem.apiKeys[keyID]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+	}
+}
@@ -0,0 +1,198 @@
+package accts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/ScriptMang/conch/internal/notify"
+)
+
+// returns an empty Memory that hashes quickly
+func newTestMemory(t *testing.T) *Memory {
+	t.Helper()
+	mem, err := NewMemory(testHashConfig(config.HashBcrypt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mem
+}
+
+func TestMemoryRegistersUsernamesOnce(t *testing.T) {
+	mem := newTestMemory(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	registered := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			acct := &Account{Fname: "Johnny", Lname: "TwoTap", Username: "johnnytwotap", Password: "Sunflower7",
+				Address: fmt.Sprintf("%d Bingus Ave", i)}
+			if _, fieldErr := mem.AddAccount(ctx, acct); fieldErr.ErrMsgs == nil {
+				mu.Lock()
+				registered++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if registered != 1 {
+		t.Errorf("%d accounts registered the same username, want 1", registered)
+	}
+}
+
+func TestMemorySessionLifecycle(t *testing.T) {
+	mem := newTestMemory(t)
+	ctx := context.Background()
+	acct := &Account{Fname: "Johnny", Lname: "TwoTap", Address: "578 Bingus Ave", Username: "johnnytwotap", Password: "Sunflower7"}
+	if _, fieldErr := mem.AddAccount(ctx, acct); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+
+	var fieldErr fields.GrammarError
+	userID := mem.Authenticate(ctx, "johnnytwotap", "Sunflower7", &fieldErr)
+	token := mem.GenerateToken(ctx, userID, Device{}, &fieldErr)
+	if user := mem.ReadUserByToken(ctx, string(token.Token), &fieldErr); user.UserID != userID || fieldErr.ErrMsgs != nil {
+		t.Fatalf("token belongs to user %d (%v), want %d", user.UserID, fieldErr.ErrMsgs, userID)
+	}
+
+	refreshed := mem.RefreshToken(ctx, token.RefreshToken, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	var oldErr fields.GrammarError
+	if mem.ReadUserByToken(ctx, string(token.Token), &oldErr); oldErr.ErrMsgs == nil {
+		t.Error("the old token still works after a refresh")
+	}
+
+	if mem.LogOut(ctx, string(refreshed.Token), &fieldErr); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	var outErr fields.GrammarError
+	if mem.ReadUserByToken(ctx, string(refreshed.Token), &outErr); outErr.ErrMsgs == nil {
+		t.Error("the token still works after logging out")
+	}
+}
+
+func TestMemoryStoresKeepTheirOwnConfig(t *testing.T) {
+	strict := testHashConfig(config.HashBcrypt)
+	strict.PasswordPolicy.MinLength = 16
+	strictMem, err := NewMemory(strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := newTestMemory(t)
+	ctx := context.Background()
+
+	acct := Account{Fname: "Johnny", Lname: "TwoTap", Address: "578 Bingus Ave", Username: "johnnytwotap", Password: "Sunflower7"}
+	if _, fieldErr := strictMem.AddAccount(ctx, &acct); fieldErr.ErrMsgs == nil {
+		t.Error("the strict store took a password shorter than its policy allows")
+	}
+	acct.ID = 0
+	if _, fieldErr := mem.AddAccount(ctx, &acct); fieldErr.ErrMsgs != nil {
+		t.Errorf("the default store refused the password: %v", fieldErr.ErrMsgs)
+	}
+}
+
+// keeps the messages it's asked to send
+type sentMessages struct {
+	msgs []notify.Message
+}
+
+func (sent *sentMessages) Notify(ctx context.Context, msg notify.Message) error {
+	sent.msgs = append(sent.msgs, msg)
+	return nil
+}
+
+func TestMemoryRefusesAUsedResetTokenBeforeThePassword(t *testing.T) {
+	mem := newTestMemory(t)
+	sent := &sentMessages{}
+	mem.SetNotifier(sent)
+	ctx := context.Background()
+	acct := &Account{Fname: "Johnny", Lname: "TwoTap", Address: "578 Bingus Ave", Username: "johnnytwotap", Password: "Sunflower7"}
+	if _, fieldErr := mem.AddAccount(ctx, acct); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+
+	if fieldErr := mem.RequestPasswordReset(ctx, "johnnytwotap", "10.0.0.1"); fieldErr.ErrMsgs != nil || len(sent.msgs) != 1 {
+		t.Fatalf("sent %d messages: %v", len(sent.msgs), fieldErr.ErrMsgs)
+	}
+	token := strings.Split(sent.msgs[0].Body, "\n")[1]
+	if fieldErr := mem.ResetPassword(ctx, token, "Moonflower8"); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+
+	// the password breaks the policy too, but the token is what's wrong
+	fieldErr := mem.ResetPassword(ctx, token, "weak")
+	if prob := fieldErr.Problem(); prob.Code != fields.CodeInvalidToken || len(fieldErr.ErrMsgs) != 1 {
+		t.Errorf("got %+v, want only the used token reported", prob)
+	}
+}
+
+func TestMemoryLimitsResetRequests(t *testing.T) {
+	mem := newTestMemory(t)
+	sent := &sentMessages{}
+	mem.SetNotifier(sent)
+	ctx := context.Background()
+	acct := &Account{Fname: "Johnny", Lname: "TwoTap", Address: "578 Bingus Ave", Username: "johnnytwotap", Password: "Sunflower7"}
+	if _, fieldErr := mem.AddAccount(ctx, acct); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+
+	// a known and an unknown username are limited the same way
+	limit := mem.conf.ResetThreshold
+	for _, username := range []string{"johnnytwotap", "nobody"} {
+		for i := 0; i < limit; i++ {
+			ip := fmt.Sprintf("10.0.0.%d", i)
+			if fieldErr := mem.RequestPasswordReset(ctx, username, ip); fieldErr.ErrMsgs != nil {
+				t.Fatalf("request %d for %s: %v", i+1, username, fieldErr.ErrMsgs)
+			}
+		}
+		fieldErr := mem.RequestPasswordReset(ctx, username, "10.0.1.1")
+		if fieldErr.Status != fields.TooManyRequests {
+			t.Errorf("request %d for %s got status %d, want %d", limit+1, username, fieldErr.Status, fields.TooManyRequests)
+		}
+	}
+	if len(sent.msgs) != limit {
+		t.Errorf("sent %d messages, want %d", len(sent.msgs), limit)
+	}
+
+	// so is an ip asking for many usernames
+	for i := 0; i < mem.conf.ResetIPThreshold; i++ {
+		if fieldErr := mem.RequestPasswordReset(ctx, fmt.Sprintf("user%d", i), "10.0.2.1"); fieldErr.ErrMsgs != nil {
+			t.Fatalf("request %d from the ip: %v", i+1, fieldErr.ErrMsgs)
+		}
+	}
+	if fieldErr := mem.RequestPasswordReset(ctx, "someone", "10.0.2.1"); fieldErr.Status != fields.TooManyRequests {
+		t.Errorf("the ip's last request got status %d, want %d", fieldErr.Status, fields.TooManyRequests)
+	}
+}
+
+func TestMemoryReadsTheContactOfTheUserID(t *testing.T) {
+	mem := newTestMemory(t)
+	ctx := context.Background()
+	// like a failed insert using up a value of the usercontacts sequence
+	mem.nextID("usercontacts")
+
+	acct := &Account{Fname: "Johnny", Lname: "TwoTap", Address: "578 Bingus Ave", Username: "johnnytwotap", Password: "Sunflower7"}
+	registered, fieldErr := mem.AddAccount(ctx, acct)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+
+	contacts, fieldErr := mem.ReadUserContactByID(ctx, registered.UserID)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if contact := contacts[0]; contact.ID == registered.UserID || contact.UserID != registered.UserID || contact.Fname != "Johnny" {
+		t.Errorf("got contact %+v, want Johnny's with a contact id other than user %d", contact, registered.UserID)
+	}
+}
@@ -0,0 +1,311 @@
+package accts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/ScriptMang/conch/internal/notify"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// a row of the password_resets table
+type passwordReset struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	Token     string     `db:"token"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// reports whether the reset token can still be used,
+// adding the reason to fieldErr when it can't
+func (reset *passwordReset) usable(now time.Time, fieldErr *fields.GrammarError) bool {
+	switch {
+	case reset.UsedAt != nil:
+		fieldErr.AddMsg(BadRequest, "token", fields.CodeInvalidToken, "Error: reset token has already been used")
+		return false
+	case !now.Before(reset.ExpiresAt):
+		fieldErr.AddMsg(BadRequest, "token", fields.CodeTokenExpired, "Error: reset token has expired, request a new one")
+		return false
+	}
+	return true
+}
+
+// logs the user out of every session except the one whose digest is keep,
+// pass "" to log them out everywhere. the caller drops the user from
+// the cache once the transaction commits
+func (pg *Postgres) endOtherSessions(ctx context.Context, q bikeshop.Querier, userID int, keep string, fieldErr *fields.GrammarError) {
+	pg.revokeUserTokens(ctx, q, userID, keep, fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return
+	}
+
+	_, err := q.Exec(ctx, `DELETE FROM tokens WHERE user_id=$1 AND token<>$2`, userID, keep)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+	}
+}
+
+// returns the username of the user
+func readUsername(ctx context.Context, q bikeshop.Querier, userID int, fieldErr *fields.GrammarError) string {
+	var username string
+	err := q.QueryRow(ctx, `SELECT username FROM Usernames WHERE id=$1`, userID).Scan(&username)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return ""
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return ""
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return ""
+	}
+	return username
+}
+
+// checks a new password against the password policy and hashes it
+func (set *settings) checkNewPassword(password, username string, fieldErr *fields.GrammarError) []byte {
+	set.policy.Check(password, username, fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil
+	}
+	return set.hashPassword(&Account{Password: password}, fieldErr)
+}
+
+// helper funct that replaces the user's password hash
+func updatePassword(ctx context.Context, q bikeshop.Querier, userID int, hashedPswd []byte, fieldErr *fields.GrammarError) {
+	tag, err := q.Exec(ctx, `UPDATE Passwords SET password=$2 WHERE user_id=$1`, userID, hashedPswd)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+	}
+}
+
+// confirms that password is the user's current one
+// a wrong password is reported as a 403 against field
+func (pg *Postgres) checkPassword(ctx context.Context, q bikeshop.Querier, userID int, field, password string, fieldErr *fields.GrammarError) {
+	var hash []byte
+	err := q.QueryRow(ctx, `SELECT password FROM Passwords WHERE user_id=$1`, userID).Scan(&hash)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return
+	}
+	if ok, _ := verifySecret(hash, password, pg.conf); !ok {
+		fieldErr.AddMsg(fields.Forbidden, field, fields.CodeInvalidCreds, "Forbidden: "+strings.ReplaceAll(field, "_", " ")+" is incorrect")
+	}
+}
+
+// changes the user's password once their current one is confirmed.
+// every session except the one making the request is logged out
+func (pg *Postgres) ChangePassword(ctx context.Context, userID int, rqstToken, current, next string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	pg.checkPassword(ctx, pg.store.Pool, userID, "current_password", current, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+
+	username := readUsername(ctx, pg.store.Pool, userID, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	hashedPswd := pg.checkNewPassword(next, username, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		updatePassword(ctx, q, userID, hashedPswd, &fieldErr)
+		pg.endOtherSessions(ctx, q, userID, pg.tokenDigest(rqstToken), &fieldErr)
+		if fieldErr.ErrMsgs != nil {
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+	pg.cache.removeUser(userID)
+	return fieldErr
+}
+
+// issues a single-use reset token for the user and sends it through the notifier.
+// requests are counted against the username and the ip, and once counted
+// the token is issued in the background so an unknown username answers
+// just as fast as a known one and the response doesn't reveal which exist.
+// any older unused token stops working
+func (pg *Postgres) RequestPasswordReset(ctx context.Context, username, ip string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	pg.countReset(ctx, username, ip, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+
+	// the request's deadline ends with its response, so the token gets its own
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pg.conf.QueryTimeout.Duration)
+	pg.resets.Add(1)
+	go func() {
+		defer pg.resets.Done()
+		defer cancel()
+		if err := pg.issueResetToken(bgCtx, username); err != nil {
+			log.Printf("password reset: %v", err)
+		}
+	}()
+	return fieldErr
+}
+
+// helper funct that replaces the user's unused reset token with a new one
+// and sends it. an unknown username does nothing
+func (pg *Postgres) issueResetToken(ctx context.Context, username string) error {
+	db := pg.store.Pool
+
+	var userID int
+	err := db.QueryRow(ctx, `SELECT id FROM Usernames WHERE username=$1`, username).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, _ := randHex(32)
+	expiresAt := time.Now().Add(pg.conf.ResetTokenTTL.Duration)
+	err = pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		_, err := q.Exec(ctx, `DELETE FROM password_resets WHERE user_id=$1 AND used_at IS NULL`, userID)
+		if err == nil {
+			_, err = q.Exec(ctx,
+				`INSERT INTO password_resets (user_id, token, expires_at) VALUES($1, $2, $3)`,
+				userID, digest(token), expiresAt,
+			)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("user %d: %w", userID, err)
+	}
+
+	pg.sendResetToken(ctx, userID, username, token, expiresAt)
+	return nil
+}
+
+// waits for the reset tokens still being issued in the background
+// call it before closing the store
+func (pg *Postgres) Wait() {
+	pg.resets.Wait()
+}
+
+// hands the reset token to the notifier. a failed delivery is only
+// logged, answering with an error would reveal that the username exists
+func (set *settings) sendResetToken(ctx context.Context, userID int, username, token string, expiresAt time.Time) {
+	err := set.notifier.Notify(ctx, notify.Message{
+		To:      username,
+		Subject: "Reset your conch password",
+		Body: "Use this token to reset your password before " + expiresAt.Format(time.RFC1123) + ":\n" +
+			token + "\n" +
+			`Send it to POST /password/reset/confirm as {"token": string, "new_password": string}`,
+	})
+	if err != nil {
+		log.Printf("password reset for user %d: %v", userID, err)
+	}
+}
+
+// sets a new password using a reset token.
+// the token can only be used once and every session is logged out
+func (pg *Postgres) ResetPassword(ctx context.Context, token, next string) fields.GrammarError {
+	db := pg.store.Pool
+	fieldErr := fields.GrammarError{}
+
+	// a used or expired token is refused before the new password is looked at.
+	// the password is checked against the username of the token's user
+	// and hashed before the transaction, so the slow hash doesn't hold
+	// the token's lock. the token itself is checked again once locked
+	var found passwordReset
+	rows, _ := db.Query(ctx, `SELECT * FROM password_resets WHERE token=$1`, digest(token))
+	err := pgxscan.ScanOne(&found, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(BadRequest, "token", fields.CodeInvalidToken, "Error: reset token isn't valid")
+		return fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return fieldErr
+	}
+	if !found.usable(time.Now(), &fieldErr) {
+		return fieldErr
+	}
+	userID := found.UserID
+	username := readUsername(ctx, db, userID, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	hashedPswd := pg.checkNewPassword(next, username, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var reset passwordReset
+		rows, _ := q.Query(ctx, `SELECT * FROM password_resets WHERE token=$1 FOR UPDATE`, digest(token))
+		err := pgxscan.ScanOne(&reset, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			fieldErr.AddMsg(BadRequest, "token", fields.CodeInvalidToken, "Error: reset token isn't valid")
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+
+		now := time.Now()
+		if !reset.usable(now, &fieldErr) {
+			return bikeshop.ErrRollback
+		}
+
+		_, err = q.Exec(ctx, `UPDATE password_resets SET used_at=$2 WHERE id=$1`, reset.ID, now)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+
+		updatePassword(ctx, q, reset.UserID, hashedPswd, &fieldErr)
+		pg.endOtherSessions(ctx, q, reset.UserID, "", &fieldErr)
+		if fieldErr.ErrMsgs != nil {
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+	pg.cache.removeUser(userID)
+	return fieldErr
+}
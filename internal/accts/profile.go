@@ -0,0 +1,135 @@
+package accts
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// meant to be binded to the changes a user makes to their own account
+// the password is changed through its own route
+type ProfileEdit struct {
+	Fname    string `json:"fname" form:"fname"`
+	Lname    string `json:"lname" form:"lname"`
+	Address  string `json:"address" form:"address"`
+	Username string `json:"username" form:"username"`
+}
+
+// a text field along with the name its grammar is checked under
+type textField struct {
+	name string
+	val  *string
+}
+
+// pairs each field of the edit with its name for the grammar checks
+func (edit *ProfileEdit) textFields() []textField {
+	return []textField{
+		{"Fname", &edit.Fname},
+		{"Lname", &edit.Lname},
+		{"Address", &edit.Address},
+		{"Username", &edit.Username},
+	}
+}
+
+// fills the fields left out of a patch with their current values
+// and checks the grammar of the ones that were sent
+func (edit *ProfileEdit) patch(orig AccountInfo, fieldErr *fields.GrammarError) {
+	origVals := []string{orig.Fname, orig.Lname, orig.Address, orig.Username}
+	for i, field := range edit.textFields() {
+		fields.CheckGrammarForPatch(field.val, field.name, origVals[i], fieldErr)
+	}
+}
+
+// checks the grammar of every field, each one is required
+func (edit *ProfileEdit) check(fieldErr *fields.GrammarError) {
+	for _, field := range edit.textFields() {
+		fields.CheckGrammar(field.name, field.val, fieldErr)
+	}
+}
+
+// replaces every field of the user's profile,
+// each one is required just like when the account was made
+func (pg *Postgres) UpdateProfile(ctx context.Context, userID int, edit ProfileEdit) ([]*AccountInfo, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	edit.check(&fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	return pg.editProfile(ctx, userID, &edit, false)
+}
+
+// changes any of the fields of the user's profile,
+// the fields left empty keep their current values
+func (pg *Postgres) PatchProfile(ctx context.Context, userID int, edit ProfileEdit) ([]*AccountInfo, fields.GrammarError) {
+	return pg.editProfile(ctx, userID, &edit, true)
+}
+
+// updates the Usernames and UserContacts rows of the user as one unit of work.
+// both rows are locked while they're read so two edits can't overwrite
+// each other's fields. a taken username or an identical contact is
+// reported as a conflict by the tables' unique constraints
+func (pg *Postgres) editProfile(ctx context.Context, userID int, edit *ProfileEdit, patch bool) ([]*AccountInfo, fields.GrammarError) {
+	var fieldErr fields.GrammarError
+	var account AccountInfo
+
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var orig AccountInfo
+		rows, _ := q.Query(ctx, accountInfoQuery+` WHERE u.id=$1 FOR UPDATE`, userID)
+		err := pgxscan.ScanOne(&orig, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+
+		if patch {
+			edit.patch(orig, &fieldErr)
+			if fieldErr.ErrMsgs != nil {
+				return bikeshop.ErrRollback
+			}
+		}
+
+		_, err = q.Exec(ctx, `UPDATE Usernames SET username=$2 WHERE id=$1`, userID, edit.Username)
+		if err == nil {
+			_, err = q.Exec(ctx,
+				`UPDATE UserContacts SET fname=$2, lname=$3, address=$4 WHERE user_id=$1`,
+				userID, edit.Fname, edit.Lname, edit.Address,
+			)
+		}
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+
+		rows, _ = q.Query(ctx, accountInfoQuery+` WHERE u.id=$1`, userID)
+		err = pgxscan.ScanOne(&account, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+
+	// the commit itself can fail after the updates went through
+	fieldErr.AddTxErr(ctx, txErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	return []*AccountInfo{&account}, fieldErr
+}
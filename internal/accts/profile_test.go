@@ -0,0 +1,37 @@
+package accts
+
+import (
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/fields"
+)
+
+func TestProfilePatchKeepsLeftOutFields(t *testing.T) {
+	orig := AccountInfo{Username: "bikerider", Fname: "Ada", Lname: "Lovelace", Address: "12 Crank St"}
+	edit := ProfileEdit{Lname: "Byron"}
+
+	var fieldErr fields.GrammarError
+	edit.patch(orig, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatalf("unexpected errors: %v", fieldErr.ErrMsgs)
+	}
+	want := ProfileEdit{Fname: "Ada", Lname: "Byron", Address: "12 Crank St", Username: "bikerider"}
+	if edit != want {
+		t.Errorf("edit = %+v, want %+v", edit, want)
+	}
+}
+
+func TestProfilePatchChecksSentFields(t *testing.T) {
+	orig := AccountInfo{Username: "bikerider", Fname: "Ada", Lname: "Lovelace", Address: "12 Crank St"}
+	edit := ProfileEdit{Fname: "Ada2", Username: "short"}
+
+	var fieldErr fields.GrammarError
+	edit.patch(orig, &fieldErr)
+	got := map[string]string{}
+	for _, v := range fieldErr.Violations {
+		got[v.Field] = v.Code
+	}
+	if got["fname"] != fields.CodeHasDigits || got["username"] != fields.CodeTooShort {
+		t.Errorf("violations = %v, want fname has_digits and username too_short", fieldErr.Violations)
+	}
+}
@@ -0,0 +1,143 @@
+package accts
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// the digests of signed tokens that were logged out before they expired.
+// signed tokens are checked without the database so revoking one means
+// remembering it here until it would have expired anyway.
+// the list is kept in postgres, loaded into memory at startup
+// and reloaded by WatchRevocations to pick up other instances' revocations
+type revocationList struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time // digest to expiry
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{tokens: make(map[string]time.Time)}
+}
+
+// reports whether the token with the given digest was revoked
+func (list *revocationList) has(digest string) bool {
+	list.mu.RLock()
+	defer list.mu.RUnlock()
+	_, ok := list.tokens[digest]
+	return ok
+}
+
+// adds revoked tokens and drops the ones that have since expired
+func (list *revocationList) add(now time.Time, revoked ...revokedToken) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+
+	for digest, expiresAt := range list.tokens {
+		if !now.Before(expiresAt) {
+			delete(list.tokens, digest)
+		}
+	}
+	for _, token := range revoked {
+		if now.Before(token.ExpiresAt) {
+			list.tokens[token.Token] = token.ExpiresAt
+		}
+	}
+}
+
+// a row of the revoked_tokens table
+type revokedToken struct {
+	Token     string    `db:"token"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// loads the revoked tokens that haven't expired yet into memory
+// and deletes the rest. must be called at startup in jwt token mode
+func (pg *Postgres) LoadRevocations(ctx context.Context) error {
+	db := pg.store.Pool
+
+	now := time.Now()
+	if _, err := db.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= $1`, now); err != nil {
+		return err
+	}
+
+	var rows []revokedToken
+	if err := pgxscan.Select(ctx, db, &rows, `SELECT token, expires_at FROM revoked_tokens`); err != nil {
+		return err
+	}
+	pg.revoked.add(now, rows...)
+	return nil
+}
+
+// reloads the revoked tokens every interval until ctx is done, so a token
+// logged out on another instance is refused here too, and expired ones
+// keep being deleted. a failed reload is logged and tried at the next interval
+func (pg *Postgres) WatchRevocations(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		loadCtx, cancel := context.WithTimeout(ctx, pg.conf.QueryTimeout.Duration)
+		err := pg.LoadRevocations(loadCtx)
+		cancel()
+		if err != nil {
+			log.Printf("reloading revoked tokens: %v", err)
+		}
+	}
+}
+
+// revokes the signed token whose id has the given digest
+// does nothing in opaque token mode, where deleting the token's row is enough
+func (set *settings) revokeToken(ctx context.Context, q bikeshop.Querier, digest string, expiresAt time.Time, fieldErr *fields.GrammarError) {
+	if set.signer == nil {
+		return
+	}
+
+	_, err := q.Exec(ctx,
+		`INSERT INTO revoked_tokens (token, expires_at) VALUES($1, $2) ON CONFLICT DO NOTHING`,
+		digest, expiresAt,
+	)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return
+	}
+	set.revoked.add(time.Now(), revokedToken{digest, expiresAt})
+}
+
+// revokes every signed token the user has been issued
+// except the one whose digest is keep, pass "" to revoke them all.
+// must be called before the user's token rows are deleted
+func (set *settings) revokeUserTokens(ctx context.Context, q bikeshop.Querier, userID int, keep string, fieldErr *fields.GrammarError) {
+	if set.signer == nil {
+		return
+	}
+
+	var rows []revokedToken
+	err := pgxscan.Select(ctx, q, &rows,
+		`INSERT INTO revoked_tokens (token, expires_at)
+		SELECT token, expires_at FROM tokens WHERE user_id=$1 AND token<>$2 AND expires_at > $3
+		ON CONFLICT DO NOTHING RETURNING token, expires_at`,
+		userID, keep, time.Now(),
+	)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return
+	}
+	set.revoked.add(time.Now(), rows...)
+}
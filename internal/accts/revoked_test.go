@@ -0,0 +1,25 @@
+package accts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevocationListDropsExpired(t *testing.T) {
+	list := newRevocationList()
+	now := time.Now()
+	list.add(now, revokedToken{"live", now.Add(time.Minute)}, revokedToken{"stale", now.Add(-time.Minute)})
+
+	if !list.has("live") {
+		t.Error("a revoked token that hasn't expired should be listed")
+	}
+	if list.has("stale") {
+		t.Error("an already expired token shouldn't be listed")
+	}
+
+	// once it expires the next add prunes it
+	list.add(now.Add(2 * time.Minute))
+	if list.has("live") {
+		t.Error("expired tokens should be pruned on add")
+	}
+}
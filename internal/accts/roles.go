@@ -0,0 +1,135 @@
+package accts
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+// the roles a user can have
+// every new account starts out as a customer
+const (
+	RoleCustomer = "customer" // manages their own account and invoices
+	RoleStaff    = "staff"    // can also read every user and invoice
+	RoleAdmin    = "admin"    // can also manage other users' accounts and invoices
+)
+
+// reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleCustomer, RoleStaff, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// a user's account as shown to admins, without their password
+type AccountInfo struct {
+	UserID   int    `db:"user_id" json:"user_id"`
+	Username string `db:"username" json:"username"`
+	Role     string `db:"role" json:"role"`
+	Fname    string `db:"fname" json:"fname"`
+	Lname    string `db:"lname" json:"lname"`
+	Address  string `db:"address" json:"address"`
+}
+
+const accountInfoQuery = `SELECT u.id AS user_id, u.username, u.role, c.fname, c.lname, c.address
+	FROM Usernames u JOIN UserContacts c ON c.user_id = u.id`
+
+// returns the role of the user
+func readRole(ctx context.Context, q bikeshop.Querier, userID int, fieldErr *fields.GrammarError) string {
+	var role string
+	err := q.QueryRow(ctx, `SELECT role FROM Usernames WHERE id=$1`, userID).Scan(&role)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return ""
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: username with specified id doesn't exist")
+		return ""
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return ""
+	}
+	return role
+}
+
+// returns every account along with its role
+func (pg *Postgres) ReadAccounts(ctx context.Context) ([]*AccountInfo, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var accounts []*AccountInfo
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx, accountInfoQuery+` ORDER BY u.id`)
+	err := pgxscan.ScanAll(&accounts, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return accounts, fieldErr
+}
+
+// returns the account with the given user id
+func (pg *Postgres) ReadAccountByID(ctx context.Context, userID int) ([]*AccountInfo, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var account AccountInfo
+	fieldErr := fields.GrammarError{}
+	rows, _ := db.Query(ctx, accountInfoQuery+` WHERE u.id=$1`, userID)
+	err := pgxscan.ScanOne(&account, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id does not exist")
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	return []*AccountInfo{&account}, fieldErr
+}
+
+// changes the role of the user.
+// their cached and signed tokens are dropped so the old role
+// stops working right away instead of when the tokens expire
+func (pg *Postgres) SetRole(ctx context.Context, userID int, role string) ([]*Usernames, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var usr Usernames
+	fieldErr := fields.GrammarError{}
+	if !ValidRole(role) {
+		fieldErr.AddMsg(BadRequest, "role", fields.CodeInvalidRole,
+			"Error: role must be one of "+RoleCustomer+", "+RoleStaff+" or "+RoleAdmin)
+		return nil, fieldErr
+	}
+
+	rows, _ := db.Query(ctx, `UPDATE Usernames SET role=$2 WHERE id=$1 RETURNING *`, userID, role)
+	err := pgxscan.ScanOne(&usr, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id does not exist")
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+
+	pg.cache.removeUser(userID)
+	pg.revokeUserTokens(ctx, db, userID, "", &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	return []*Usernames{&usr}, fieldErr
+}
@@ -0,0 +1,88 @@
+package accts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/fields"
+)
+
+// everything the handlers do with accounts: registering and logging in,
+// profiles, roles, passwords, two-factor logins and login lockouts
+type AccountStore interface {
+	AddAccount(ctx context.Context, acct *Account) (*Registered, fields.GrammarError)
+	Authenticate(ctx context.Context, username, password string, fieldErr *fields.GrammarError) int
+	VerifySecondFactor(ctx context.Context, userID int, code string, fieldErr *fields.GrammarError)
+
+	ReadUserContact(ctx context.Context) ([]*UserContacts, fields.GrammarError)
+	ReadUserContactByID(ctx context.Context, id int) ([]*UserContacts, fields.GrammarError)
+	ReadUsernameByID(ctx context.Context, userID int, fieldErr *fields.GrammarError) []*Usernames
+	ReadAccounts(ctx context.Context) ([]*AccountInfo, fields.GrammarError)
+	ReadAccountByID(ctx context.Context, userID int) ([]*AccountInfo, fields.GrammarError)
+	SetRole(ctx context.Context, userID int, role string) ([]*Usernames, fields.GrammarError)
+	UpdateProfile(ctx context.Context, userID int, edit ProfileEdit) ([]*AccountInfo, fields.GrammarError)
+	PatchProfile(ctx context.Context, userID int, edit ProfileEdit) ([]*AccountInfo, fields.GrammarError)
+	DeleteAcct(ctx context.Context, user Usernames) ([]*Usernames, fields.GrammarError)
+
+	ChangePassword(ctx context.Context, userID int, rqstToken, current, next string) fields.GrammarError
+	RequestPasswordReset(ctx context.Context, username, ip string) fields.GrammarError
+	ResetPassword(ctx context.Context, token, next string) fields.GrammarError
+
+	EnrollTOTP(ctx context.Context, userID int) ([]*TOTPEnrollment, fields.GrammarError)
+	ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, fields.GrammarError)
+	DisableTOTP(ctx context.Context, userID int, password string) fields.GrammarError
+	RemoveTOTP(ctx context.Context, userID int) fields.GrammarError
+
+	BeginLogin(ctx context.Context, username, ip string, fieldErr *fields.GrammarError) (*LoginAttempt, time.Duration)
+	ForgiveLogin(ctx context.Context, attempt *LoginAttempt, fieldErr *fields.GrammarError)
+	ClearLoginFailures(ctx context.Context, username string, fieldErr *fields.GrammarError)
+	ReadLockouts(ctx context.Context) ([]*Lockout, fields.GrammarError)
+	ClearLockout(ctx context.Context, kind, key string) ([]*Lockout, fields.GrammarError)
+
+	PasswordPolicy() *fields.PasswordPolicy
+}
+
+// everything the handlers do with the credentials a user is issued:
+// login sessions and their tokens, and personal api keys
+type TokenStore interface {
+	GenerateToken(ctx context.Context, userID int, device Device, fieldErr *fields.GrammarError) Tokens
+	ReadUserByToken(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) TokenUser
+	TouchToken(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError)
+	RefreshToken(ctx context.Context, refreshToken string, fieldErr *fields.GrammarError) Tokens
+	LogOut(ctx context.Context, tgtToken string, fieldErr *fields.GrammarError) Tokens
+	ReadSessions(ctx context.Context, userID int, tgtToken string) ([]*Session, fields.GrammarError)
+	RevokeSession(ctx context.Context, userID, sessionID int) ([]*Session, fields.GrammarError)
+
+	CreateAPIKey(ctx context.Context, userID int, name string, scopes []string) ([]*NewAPIKey, fields.GrammarError)
+	ReadAPIKeys(ctx context.Context, userID int) ([]*APIKey, fields.GrammarError)
+	RevokeAPIKey(ctx context.Context, userID, keyID int) ([]*APIKey, fields.GrammarError)
+	ReadUserByAPIKey(ctx context.Context, key string, fieldErr *fields.GrammarError) KeyUser
+	TouchAPIKey(ctx context.Context, keyID int, fieldErr *fields.GrammarError)
+}
+
+// the AccountStore and TokenStore backed by the bikeshop database
+type Postgres struct {
+	*settings
+	store *bikeshop.Store // connection pool every query runs on
+	cache *tokenCache     // recent token lookups, nil when caching is off
+
+	resets sync.WaitGroup // reset tokens being issued in the background
+}
+
+var (
+	_ AccountStore = (*Postgres)(nil)
+	_ TokenStore   = (*Postgres)(nil)
+)
+
+// returns a Postgres that runs its queries against the store
+// with the token lifetimes, hashing and policies of the config
+func NewPostgres(cfg *config.Config, s *bikeshop.Store) (*Postgres, error) {
+	set, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{settings: set, store: s, cache: newTokenCache(cfg.TokenCacheSize)}, nil
+}
@@ -0,0 +1,266 @@
+package accts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/ScriptMang/conch/internal/totp"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	totpIssuer        = "conch" // the name authenticator apps list the account under
+	totpSkew          = 1       // steps either side of now a code is accepted from
+	recoveryCodeCount = 10
+)
+
+// a row of the totp_secrets table
+// the secret has to be kept as is since codes are generated from it
+type totpSecret struct {
+	UserID      int        `db:"user_id"`
+	Secret      string     `db:"secret"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ConfirmedAt *time.Time `db:"confirmed_at"`
+	LastStep    int64      `db:"last_step"`
+}
+
+// what an authenticator app needs to start generating codes
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// returns a new recovery code, formatted to be easy to copy down
+func newRecoveryCode() string {
+	code, _ := randHex(5)
+	return code[:5] + "-" + code[5:]
+}
+
+// strips what people tend to add when typing a recovery code back in
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// starts enrolling the user in two-factor logins with a new secret.
+// the secret isn't required at login until it's confirmed with a code,
+// enrolling again before then replaces it
+func (pg *Postgres) EnrollTOTP(ctx context.Context, userID int) ([]*TOTPEnrollment, fields.GrammarError) {
+	db := pg.store.Pool
+
+	fieldErr := fields.GrammarError{}
+	usr := pg.ReadUsernameByID(ctx, userID, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	// a confirmed secret is left alone
+	secret, _ := totp.NewSecret()
+	tag, err := db.Exec(ctx,
+		`INSERT INTO totp_secrets (user_id, secret) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret=EXCLUDED.secret, created_at=now(), last_step=0
+		WHERE totp_secrets.confirmed_at IS NULL`,
+		userID, secret,
+	)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+	if tag.RowsAffected() == 0 {
+		fieldErr.AddMsg(fields.Conflict, "", fields.CodeTOTPEnabled,
+			"Conflict: two-factor login is already enabled, disable it before enrolling again")
+		return nil, fieldErr
+	}
+
+	enrollment := &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, usr[0].Username, secret),
+	}
+	return []*TOTPEnrollment{enrollment}, fieldErr
+}
+
+// turns on two-factor logins once the user proves their app
+// generates the right codes. returns the recovery codes, which
+// are only stored as digests so this is the one time they're shown
+func (pg *Postgres) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	var codes []string
+
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var secret totpSecret
+		rows, _ := q.Query(ctx, `SELECT * FROM totp_secrets WHERE user_id=$1 FOR UPDATE`, userID)
+		err := pgxscan.ScanOne(&secret, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound,
+				"Resource Not Found: start enrolling with POST /user/2fa before confirming")
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+		if secret.ConfirmedAt != nil {
+			fieldErr.AddMsg(fields.Conflict, "", fields.CodeTOTPEnabled, "Conflict: two-factor login is already enabled")
+			return bikeshop.ErrRollback
+		}
+
+		step, ok := totp.Validate(secret.Secret, code, time.Now(), totpSkew)
+		if !ok {
+			fieldErr.AddMsg(BadRequest, "code", fields.CodeInvalidTOTP, "Error: two-factor code is incorrect")
+			return bikeshop.ErrRollback
+		}
+
+		_, err = q.Exec(ctx, `UPDATE totp_secrets SET confirmed_at=now(), last_step=$2 WHERE user_id=$1`, userID, step)
+		if err == nil {
+			codes, err = replaceRecoveryCodes(ctx, q, userID)
+		}
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	return codes, fieldErr
+}
+
+// helper funct that swaps the user's recovery codes for a new set
+func replaceRecoveryCodes(ctx context.Context, q bikeshop.Querier, userID int) ([]string, error) {
+	_, err := q.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i] = newRecoveryCode()
+		_, err := q.Exec(ctx,
+			`INSERT INTO recovery_codes (user_id, code) VALUES($1, $2)`,
+			userID, digest(normalizeRecoveryCode(codes[i])),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// turns off two-factor logins after the user confirms their password
+func (pg *Postgres) DisableTOTP(ctx context.Context, userID int, password string) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+	pg.checkPassword(ctx, pg.store.Pool, userID, "password", password, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		return fieldErr
+	}
+	return pg.RemoveTOTP(ctx, userID)
+}
+
+// turns off two-factor logins for the user and drops their recovery codes.
+// meant for admins helping someone who lost their device
+func (pg *Postgres) RemoveTOTP(ctx context.Context, userID int) fields.GrammarError {
+	fieldErr := fields.GrammarError{}
+
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		tag, err := q.Exec(ctx, `DELETE FROM totp_secrets WHERE user_id=$1`, userID)
+		if err == nil {
+			_, err = q.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id=$1`, userID)
+		}
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+		if tag.RowsAffected() == 0 {
+			fieldErr.AddMsg(resourceNotFound, "", fields.CodeNotFound,
+				"Resource Not Found: two-factor login isn't enabled for the user")
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+	return fieldErr
+}
+
+// checks the second factor of a login after the password was accepted.
+// users who haven't confirmed a secret pass without a code,
+// everyone else needs a current code or one of their unused recovery codes.
+// a code can't be used twice, even within the step it's current for
+func (pg *Postgres) VerifySecondFactor(ctx context.Context, userID int, code string, fieldErr *fields.GrammarError) {
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var secret totpSecret
+		rows, _ := q.Query(ctx,
+			`SELECT * FROM totp_secrets WHERE user_id=$1 AND confirmed_at IS NOT NULL FOR UPDATE`,
+			userID,
+		)
+		err := pgxscan.ScanOne(&secret, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+
+		if code == "" {
+			fieldErr.AddMsg(unauthorized, "", fields.CodeTOTPRequired,
+				"Unauthorized: two-factor login is enabled, send a code in the X-TOTP-Code header")
+			return bikeshop.ErrRollback
+		}
+
+		used := false
+		if step, ok := totp.Validate(secret.Secret, code, time.Now(), totpSkew); ok && step > secret.LastStep {
+			_, err = q.Exec(ctx, `UPDATE totp_secrets SET last_step=$2 WHERE user_id=$1`, userID, step)
+			used = err == nil
+		} else {
+			used, err = useRecoveryCode(ctx, q, userID, code)
+		}
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
+		if !used {
+			fieldErr.AddMsg(unauthorized, "", fields.CodeInvalidTOTP, "Unauthorized: two-factor code is incorrect")
+			return bikeshop.ErrRollback
+		}
+		return nil
+	})
+	fieldErr.AddTxErr(ctx, txErr)
+}
+
+// helper funct that marks one of the user's recovery codes as used,
+// returns false if code isn't one of their unused ones
+func useRecoveryCode(ctx context.Context, q bikeshop.Querier, userID int, code string) (bool, error) {
+	tag, err := q.Exec(ctx,
+		`UPDATE recovery_codes SET used_at=now() WHERE user_id=$1 AND code=$2 AND used_at IS NULL`,
+		userID, digest(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,21 @@
+package accts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodeNormalizes(t *testing.T) {
+	code := newRecoveryCode()
+	if len(code) != 11 || code[5] != '-' {
+		t.Fatalf("code = %q, want two groups of five", code)
+	}
+
+	typed := " " + strings.ToUpper(code[:5]) + " " + code[6:]
+	if normalizeRecoveryCode(typed) != normalizeRecoveryCode(code) {
+		t.Errorf("%q and %q should normalize the same", typed, code)
+	}
+	if normalizeRecoveryCode(newRecoveryCode()) == normalizeRecoveryCode(code) {
+		t.Error("two recovery codes were the same")
+	}
+}
@@ -2,23 +2,70 @@ package bikeshop
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Create a New Database Connection to bikeshop
-func Connect() (context.Context, *pgxpool.Pool) {
-	uri := "postgres://username@localhost:5432/bikeshop"
-	os.Setenv("DATABASE_URL", uri)
-	ctx := context.Background()
+// holds the application-wide connection pool to bikeshop
+// it's opened once at startup and shared by every request
+type Store struct {
+	Pool *pgxpool.Pool
+}
+
+// Opens the connection pool to bikeshop
+// using the connection and pool settings from cfg
+func Open(ctx context.Context, cfg *config.Config) (*Store, error) {
+	poolConf, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse database url: %w", err)
+	}
+	poolConf.MaxConns = cfg.MaxConns
+	poolConf.MinConns = cfg.MinConns
+	poolConf.HealthCheckPeriod = cfg.HealthCheckPeriod.Duration
+	poolConf.MaxConnLifetime = cfg.MaxConnLifetime.Duration
+	poolConf.MaxConnIdleTime = cfg.MaxConnIdleTime.Duration
+	poolConf.ConnConfig.ConnectTimeout = cfg.ConnectTimeout.Duration
 
-	db, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
+	pool, err := pgxpool.NewWithConfig(ctx, poolConf)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to a database: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("unable to connect to a database: %w", err)
 	}
+	return &Store{Pool: pool}, nil
+}
+
+// checks that the database can be reached
+func (s *Store) Ping(ctx context.Context) error {
+	return s.Pool.Ping(ctx)
+}
+
+// closes every connection in the pool
+func (s *Store) Close() {
+	s.Pool.Close()
+}
+
+// the query methods shared by the pool and a transaction
+// helpers that take a Querier can run either on their own or as
+// one step of a larger unit of work
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// returned by a unit of work to undo its changes
+// when the failure has already been recorded elsewhere
+var ErrRollback = errors.New("transaction rolled back")
 
-	return ctx, db
+// InTx runs fn as a single unit of work.
+// every statement fn runs through q is committed together when fn
+// returns nil, and all of them are rolled back when it returns an error
+func (s *Store) InTx(ctx context.Context, fn func(q Querier) error) error {
+	return pgx.BeginFunc(ctx, s.Pool, func(tx pgx.Tx) error {
+		return fn(tx)
+	})
 }
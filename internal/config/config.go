@@ -0,0 +1,556 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration wraps time.Duration so it can be read
+// from a config file as a string like "5s" or "250ms"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = dur
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// paths to the certificate and key used to serve https
+// if both are empty the server listens on plain http
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// a key used to sign access tokens in jwt token mode
+type SigningKey struct {
+	ID  string `json:"kid"`
+	Alg string `json:"alg"` // HS256 or EdDSA
+	// base64 encoded hmac secret, or the 32 byte seed of an ed25519 key
+	Key string `json:"key"`
+}
+
+// the rules new passwords have to follow
+type PasswordPolicy struct {
+	MinLength int `json:"min_length"`
+	// in bytes, bcrypt ignores everything past the 72nd
+	MaxLength        int  `json:"max_length"`
+	RequireUpper     bool `json:"require_upper"`
+	RequireLower     bool `json:"require_lower"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	DisallowUsername bool `json:"disallow_username"`
+	// file of common passwords to reject, one per line
+	DenylistFile string `json:"denylist_file"`
+	// estimated bits of entropy a password needs, zero turns the check off
+	MinEntropy float64 `json:"min_entropy"`
+}
+
+// the cost settings of argon2id password hashes
+type Argon2 struct {
+	Time      uint32 `json:"time"`       // passes over the memory
+	MemoryKiB uint32 `json:"memory_kib"` // memory used per hash
+	Threads   uint8  `json:"threads"`
+}
+
+// the algorithms passwords can be hashed with
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// the most bytes of a password bcrypt looks at
+const MaxPasswordBytes = 72
+
+// the kinds of access token conch can issue
+const (
+	TokenModeOpaque = "opaque" // random tokens checked against the database
+	TokenModeJWT    = "jwt"    // signed tokens checked without the database
+)
+
+// holds every setting conch needs at startup
+type Config struct {
+	DatabaseURL       string   `json:"database_url"`
+	MaxConns          int32    `json:"max_conns"`
+	MinConns          int32    `json:"min_conns"`
+	HealthCheckPeriod Duration `json:"health_check_period"`
+	MaxConnLifetime   Duration `json:"max_conn_lifetime"`
+	MaxConnIdleTime   Duration `json:"max_conn_idle_time"`
+	ConnectTimeout    Duration `json:"connect_timeout"`
+	QueryTimeout      Duration `json:"query_timeout"`
+	// applies any pending schema migrations before the server starts
+	MigrateOnStart bool `json:"migrate_on_start"`
+
+	// per-route deadlines keyed by "METHOD /path", e.g. "GET /invoices"
+	// routes that aren't listed use QueryTimeout
+	RouteTimeouts map[string]Duration `json:"route_timeouts"`
+
+	ListenAddr   string   `json:"listen_addr"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	IdleTimeout  Duration `json:"idle_timeout"`
+	TLS          TLS      `json:"tls"`
+	// addresses or cidr ranges of the proxies whose X-Forwarded-For
+	// is believed. empty trusts none, so the client ip is the peer's
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	AccessTokenTTL  Duration `json:"access_token_ttl"`
+	RefreshTokenTTL Duration `json:"refresh_token_ttl"`
+	// when true every authenticated request pushes
+	// the access token's expiry out by AccessTokenTTL
+	SlidingSessions bool `json:"sliding_sessions"`
+	// max number of token lookups kept in memory
+	// zero turns the cache off so every request asks the database
+	TokenCacheSize int `json:"token_cache_size"`
+
+	TokenMode   string       `json:"token_mode"`
+	SigningKeys []SigningKey `json:"signing_keys"`
+	// the key new tokens are signed with, the rest only
+	// verify tokens signed before the key was rotated
+	ActiveKeyID string `json:"active_key_id"`
+	// how often the revoked tokens are reloaded in jwt token mode,
+	// so a token logged out on another instance is refused here too
+	RevocationRefresh Duration `json:"revocation_refresh"`
+
+	// how long a password reset token can be used for
+	ResetTokenTTL Duration `json:"reset_token_ttl"`
+	// file that password reset messages are appended to
+	// when empty they're written to the log instead
+	NotifyFile string `json:"notify_file"`
+
+	// failed logins in a row before a username or ip is locked out
+	LockoutThreshold   int      `json:"lockout_threshold"`
+	LockoutIPThreshold int      `json:"lockout_ip_threshold"`
+	LockoutDuration    Duration `json:"lockout_duration"`
+	// wait after the first failed login, doubled with each one after it
+	LoginBackoff Duration `json:"login_backoff"`
+	// password reset requests for a username or from an ip
+	// allowed within the lockout duration
+	ResetThreshold   int `json:"reset_threshold"`
+	ResetIPThreshold int `json:"reset_ip_threshold"`
+
+	PasswordPolicy PasswordPolicy `json:"password_policy"`
+	// the algorithm new password hashes use, hashes made with
+	// another algorithm or a lower cost are replaced at the next login
+	PasswordHash string `json:"password_hash"`
+	BcryptCost   int    `json:"bcrypt_cost"`
+	Argon2       Argon2 `json:"argon2"`
+}
+
+// returns the config used when nothing else is provided
+func Default() *Config {
+	return &Config{
+		DatabaseURL:        "postgres://localhost:5432/bikeshop",
+		MaxConns:           10,
+		MinConns:           2,
+		HealthCheckPeriod:  Duration{time.Minute},
+		MaxConnLifetime:    Duration{time.Hour},
+		MaxConnIdleTime:    Duration{30 * time.Minute},
+		ConnectTimeout:     Duration{5 * time.Second},
+		QueryTimeout:       Duration{5 * time.Second},
+		ListenAddr:         ":8080",
+		ReadTimeout:        Duration{10 * time.Second},
+		WriteTimeout:       Duration{10 * time.Second},
+		IdleTimeout:        Duration{60 * time.Second},
+		AccessTokenTTL:     Duration{15 * time.Minute},
+		RefreshTokenTTL:    Duration{7 * 24 * time.Hour},
+		SlidingSessions:    true,
+		TokenMode:          TokenModeOpaque,
+		RevocationRefresh:  Duration{30 * time.Second},
+		ResetTokenTTL:      Duration{30 * time.Minute},
+		LockoutThreshold:   5,
+		LockoutIPThreshold: 20,
+		LockoutDuration:    Duration{15 * time.Minute},
+		LoginBackoff:       Duration{time.Second},
+		ResetThreshold:     3,
+		ResetIPThreshold:   10,
+		PasswordPolicy: PasswordPolicy{
+			MinLength:        8,
+			MaxLength:        MaxPasswordBytes,
+			RequireUpper:     true,
+			RequireDigit:     true,
+			DisallowUsername: true,
+			MinEntropy:       30,
+		},
+		PasswordHash: HashBcrypt,
+		BcryptCost:   14, // what hashes were made with before it could be set
+		Argon2:       Argon2{Time: 3, MemoryKiB: 64 * 1024, Threads: 4},
+	}
+}
+
+// Load builds the config from the defaults, a json config file,
+// environment variables and command-line flags, in that order.
+// Each source overrides the values set by the ones before it.
+// The config file is named by the -config flag or CONCH_CONFIG.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("conch", flag.ContinueOnError)
+	var flags Config
+	configPath := fs.String("config", "", "path to a json config file")
+	fs.StringVar(&flags.DatabaseURL, "db", "", "postgres connection string")
+	maxConns := fs.Int("max-conns", 0, "max number of pooled database connections")
+	minConns := fs.Int("min-conns", 0, "number of database connections kept open when idle")
+	fs.DurationVar(&flags.HealthCheckPeriod.Duration, "health-check-period", 0, "how often idle database connections are checked")
+	fs.DurationVar(&flags.ConnectTimeout.Duration, "connect-timeout", 0, "timeout for opening a database connection")
+	fs.DurationVar(&flags.QueryTimeout.Duration, "query-timeout", 0, "default deadline for a request's database queries")
+	fs.BoolVar(&flags.MigrateOnStart, "migrate", false, "apply pending schema migrations before serving")
+	fs.StringVar(&flags.ListenAddr, "addr", "", "address the http server listens on")
+	fs.DurationVar(&flags.ReadTimeout.Duration, "read-timeout", 0, "http server read timeout")
+	fs.DurationVar(&flags.WriteTimeout.Duration, "write-timeout", 0, "http server write timeout")
+	fs.DurationVar(&flags.IdleTimeout.Duration, "idle-timeout", 0, "http server idle timeout")
+	fs.StringVar(&flags.TLS.CertFile, "tls-cert", "", "path to the tls certificate")
+	fs.StringVar(&flags.TLS.KeyFile, "tls-key", "", "path to the tls private key")
+	trustedProxies := fs.String("trusted-proxies", "", "comma separated proxy addresses or cidr ranges whose X-Forwarded-For is trusted")
+	fs.DurationVar(&flags.AccessTokenTTL.Duration, "access-token-ttl", 0, "how long an access token is valid for")
+	fs.DurationVar(&flags.RefreshTokenTTL.Duration, "refresh-token-ttl", 0, "how long a refresh token is valid for")
+	fs.BoolVar(&flags.SlidingSessions, "sliding-sessions", false, "extend an access token's expiry each time it's used")
+	fs.StringVar(&flags.TokenMode, "token-mode", "", "kind of access token to issue, opaque or jwt")
+	fs.StringVar(&flags.ActiveKeyID, "active-key-id", "", "kid of the key that signs new jwt access tokens")
+	fs.DurationVar(&flags.RevocationRefresh.Duration, "revocation-refresh", 0, "how often revoked jwt access tokens are reloaded")
+	fs.DurationVar(&flags.ResetTokenTTL.Duration, "reset-token-ttl", 0, "how long a password reset token is valid for")
+	fs.StringVar(&flags.NotifyFile, "notify-file", "", "file password reset messages are written to instead of the log")
+	fs.IntVar(&flags.LockoutThreshold, "lockout-threshold", 0, "failed logins before a username is locked out")
+	fs.IntVar(&flags.LockoutIPThreshold, "lockout-ip-threshold", 0, "failed logins before an ip address is locked out")
+	fs.DurationVar(&flags.LockoutDuration.Duration, "lockout-duration", 0, "how long a lockout lasts")
+	fs.DurationVar(&flags.LoginBackoff.Duration, "login-backoff", 0, "wait after a failed login, doubled each time")
+	fs.IntVar(&flags.ResetThreshold, "reset-threshold", 0, "password resets a username can request within the lockout duration")
+	fs.IntVar(&flags.ResetIPThreshold, "reset-ip-threshold", 0, "password resets an ip address can request within the lockout duration")
+	fs.IntVar(&flags.PasswordPolicy.MinLength, "password-min-length", 0, "fewest characters a password can have")
+	fs.IntVar(&flags.PasswordPolicy.MaxLength, "password-max-length", 0, "most bytes a password can have, up to 72")
+	fs.StringVar(&flags.PasswordPolicy.DenylistFile, "password-denylist", "", "file of common passwords to reject, one per line")
+	fs.Float64Var(&flags.PasswordPolicy.MinEntropy, "password-min-entropy", 0, "estimated bits of entropy a password needs")
+	fs.StringVar(&flags.PasswordHash, "password-hash", "", "algorithm new password hashes use, bcrypt or argon2id")
+	fs.IntVar(&flags.BcryptCost, "bcrypt-cost", 0, "cost of new bcrypt password hashes, 4-31")
+	fs.IntVar(&flags.TokenCacheSize, "token-cache-size", 0, "number of token lookups cached in memory, 0 turns the cache off")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path = getenv("CONCH_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.readEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// only flags given on the command line override earlier sources
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db":
+			cfg.DatabaseURL = flags.DatabaseURL
+		case "max-conns":
+			cfg.MaxConns = int32(*maxConns)
+		case "min-conns":
+			cfg.MinConns = int32(*minConns)
+		case "health-check-period":
+			cfg.HealthCheckPeriod = flags.HealthCheckPeriod
+		case "connect-timeout":
+			cfg.ConnectTimeout = flags.ConnectTimeout
+		case "query-timeout":
+			cfg.QueryTimeout = flags.QueryTimeout
+		case "migrate":
+			cfg.MigrateOnStart = flags.MigrateOnStart
+		case "addr":
+			cfg.ListenAddr = flags.ListenAddr
+		case "read-timeout":
+			cfg.ReadTimeout = flags.ReadTimeout
+		case "write-timeout":
+			cfg.WriteTimeout = flags.WriteTimeout
+		case "idle-timeout":
+			cfg.IdleTimeout = flags.IdleTimeout
+		case "tls-cert":
+			cfg.TLS.CertFile = flags.TLS.CertFile
+		case "tls-key":
+			cfg.TLS.KeyFile = flags.TLS.KeyFile
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*trustedProxies)
+		case "access-token-ttl":
+			cfg.AccessTokenTTL = flags.AccessTokenTTL
+		case "refresh-token-ttl":
+			cfg.RefreshTokenTTL = flags.RefreshTokenTTL
+		case "sliding-sessions":
+			cfg.SlidingSessions = flags.SlidingSessions
+		case "token-cache-size":
+			cfg.TokenCacheSize = flags.TokenCacheSize
+		case "reset-token-ttl":
+			cfg.ResetTokenTTL = flags.ResetTokenTTL
+		case "notify-file":
+			cfg.NotifyFile = flags.NotifyFile
+		case "lockout-threshold":
+			cfg.LockoutThreshold = flags.LockoutThreshold
+		case "lockout-ip-threshold":
+			cfg.LockoutIPThreshold = flags.LockoutIPThreshold
+		case "lockout-duration":
+			cfg.LockoutDuration = flags.LockoutDuration
+		case "login-backoff":
+			cfg.LoginBackoff = flags.LoginBackoff
+		case "reset-threshold":
+			cfg.ResetThreshold = flags.ResetThreshold
+		case "reset-ip-threshold":
+			cfg.ResetIPThreshold = flags.ResetIPThreshold
+		case "token-mode":
+			cfg.TokenMode = flags.TokenMode
+		case "active-key-id":
+			cfg.ActiveKeyID = flags.ActiveKeyID
+		case "revocation-refresh":
+			cfg.RevocationRefresh = flags.RevocationRefresh
+		case "password-hash":
+			cfg.PasswordHash = flags.PasswordHash
+		case "bcrypt-cost":
+			cfg.BcryptCost = flags.BcryptCost
+		case "password-min-length":
+			cfg.PasswordPolicy.MinLength = flags.PasswordPolicy.MinLength
+		case "password-max-length":
+			cfg.PasswordPolicy.MaxLength = flags.PasswordPolicy.MaxLength
+		case "password-denylist":
+			cfg.PasswordPolicy.DenylistFile = flags.PasswordPolicy.DenylistFile
+		case "password-min-entropy":
+			cfg.PasswordPolicy.MinEntropy = flags.PasswordPolicy.MinEntropy
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// overlays the values found in the json file onto cfg
+func (cfg *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// overlays the values of any CONCH_* environment variables onto cfg
+// DATABASE_URL is honored when CONCH_DATABASE_URL isn't set
+func (cfg *Config) readEnv(getenv func(string) string) error {
+	if v := getenv("DATABASE_URL"); v != "" {
+		cfg.DatabaseURL = v
+	}
+	if v := getenv("CONCH_DATABASE_URL"); v != "" {
+		cfg.DatabaseURL = v
+	}
+
+	conns := map[string]*int32{
+		"CONCH_MAX_CONNS": &cfg.MaxConns,
+		"CONCH_MIN_CONNS": &cfg.MinConns,
+	}
+	for name, n := range conns {
+		v := getenv(name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+		*n = int32(i)
+	}
+	if v := getenv("CONCH_LISTEN_ADDR"); v != "" {
+		cfg.ListenAddr = v
+	}
+	if v := getenv("CONCH_TLS_CERT"); v != "" {
+		cfg.TLS.CertFile = v
+	}
+	if v := getenv("CONCH_TLS_KEY"); v != "" {
+		cfg.TLS.KeyFile = v
+	}
+	if v := getenv("CONCH_TRUSTED_PROXIES"); v != "" {
+		cfg.TrustedProxies = splitList(v)
+	}
+	if v := getenv("CONCH_NOTIFY_FILE"); v != "" {
+		cfg.NotifyFile = v
+	}
+	if v := getenv("CONCH_TOKEN_MODE"); v != "" {
+		cfg.TokenMode = v
+	}
+	if v := getenv("CONCH_ACTIVE_KEY_ID"); v != "" {
+		cfg.ActiveKeyID = v
+	}
+	if v := getenv("CONCH_PASSWORD_HASH"); v != "" {
+		cfg.PasswordHash = v
+	}
+	if v := getenv("CONCH_PASSWORD_DENYLIST"); v != "" {
+		cfg.PasswordPolicy.DenylistFile = v
+	}
+	if v := getenv("CONCH_PASSWORD_MIN_ENTROPY"); v != "" {
+		bits, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("config: CONCH_PASSWORD_MIN_ENTROPY: %w", err)
+		}
+		cfg.PasswordPolicy.MinEntropy = bits
+	}
+	if v := getenv("CONCH_MIGRATE_ON_START"); v != "" {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("config: CONCH_MIGRATE_ON_START: %w", err)
+		}
+		cfg.MigrateOnStart = migrate
+	}
+	if v := getenv("CONCH_SLIDING_SESSIONS"); v != "" {
+		sliding, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("config: CONCH_SLIDING_SESSIONS: %w", err)
+		}
+		cfg.SlidingSessions = sliding
+	}
+	ints := map[string]*int{
+		"CONCH_TOKEN_CACHE_SIZE":     &cfg.TokenCacheSize,
+		"CONCH_LOCKOUT_THRESHOLD":    &cfg.LockoutThreshold,
+		"CONCH_LOCKOUT_IP_THRESHOLD": &cfg.LockoutIPThreshold,
+		"CONCH_RESET_THRESHOLD":      &cfg.ResetThreshold,
+		"CONCH_RESET_IP_THRESHOLD":   &cfg.ResetIPThreshold,
+		"CONCH_PASSWORD_MIN_LENGTH":  &cfg.PasswordPolicy.MinLength,
+		"CONCH_PASSWORD_MAX_LENGTH":  &cfg.PasswordPolicy.MaxLength,
+		"CONCH_BCRYPT_COST":          &cfg.BcryptCost,
+	}
+	for name, n := range ints {
+		v := getenv(name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+		*n = i
+	}
+
+	durations := map[string]*Duration{
+		"CONCH_HEALTH_CHECK_PERIOD": &cfg.HealthCheckPeriod,
+		"CONCH_MAX_CONN_LIFETIME":   &cfg.MaxConnLifetime,
+		"CONCH_MAX_CONN_IDLE_TIME":  &cfg.MaxConnIdleTime,
+		"CONCH_CONNECT_TIMEOUT":     &cfg.ConnectTimeout,
+		"CONCH_QUERY_TIMEOUT":       &cfg.QueryTimeout,
+		"CONCH_READ_TIMEOUT":        &cfg.ReadTimeout,
+		"CONCH_WRITE_TIMEOUT":       &cfg.WriteTimeout,
+		"CONCH_IDLE_TIMEOUT":        &cfg.IdleTimeout,
+		"CONCH_ACCESS_TOKEN_TTL":    &cfg.AccessTokenTTL,
+		"CONCH_REFRESH_TOKEN_TTL":   &cfg.RefreshTokenTTL,
+		"CONCH_RESET_TOKEN_TTL":     &cfg.ResetTokenTTL,
+		"CONCH_REVOCATION_REFRESH":  &cfg.RevocationRefresh,
+		"CONCH_LOCKOUT_DURATION":    &cfg.LockoutDuration,
+		"CONCH_LOGIN_BACKOFF":       &cfg.LoginBackoff,
+	}
+	for name, dur := range durations {
+		v := getenv(name)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+		dur.Duration = d
+	}
+	return nil
+}
+
+// splits a comma separated list, dropping the empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// rejects settings the server can't start with
+func (cfg *Config) validate() error {
+	for _, proxy := range cfg.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("config: trusted proxy %q isn't an ip address or cidr range", proxy)
+			}
+		}
+	}
+	switch {
+	case cfg.DatabaseURL == "":
+		return errors.New("config: database url can't be empty")
+	case cfg.MaxConns < 1:
+		return errors.New("config: max conns must be at least 1")
+	case cfg.MinConns < 0 || cfg.MinConns > cfg.MaxConns:
+		return errors.New("config: min conns must be between 0 and max conns")
+	case (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == ""):
+		return errors.New("config: tls needs both a cert file and a key file")
+	case cfg.AccessTokenTTL.Duration <= 0 || cfg.RefreshTokenTTL.Duration <= 0 || cfg.ResetTokenTTL.Duration <= 0:
+		return errors.New("config: token lifetimes must be positive")
+	case cfg.TokenCacheSize < 0:
+		return errors.New("config: token cache size can't be negative")
+	case cfg.LockoutThreshold < 1 || cfg.LockoutIPThreshold < 1:
+		return errors.New("config: lockout thresholds must be at least 1")
+	case cfg.ResetThreshold < 1 || cfg.ResetIPThreshold < 1:
+		return errors.New("config: reset thresholds must be at least 1")
+	case cfg.LockoutDuration.Duration <= 0 || cfg.LoginBackoff.Duration < 0:
+		return errors.New("config: lockout duration must be positive and login backoff can't be negative")
+	case cfg.TokenMode != TokenModeOpaque && cfg.TokenMode != TokenModeJWT:
+		return fmt.Errorf("config: token mode must be %q or %q", TokenModeOpaque, TokenModeJWT)
+	case cfg.TokenMode == TokenModeJWT && len(cfg.SigningKeys) == 0:
+		return errors.New("config: jwt token mode needs at least one signing key")
+	case cfg.RevocationRefresh.Duration <= 0:
+		return errors.New("config: revocation refresh must be positive")
+	case cfg.PasswordPolicy.MinLength < 1 || cfg.PasswordPolicy.MinLength > cfg.PasswordPolicy.MaxLength:
+		return errors.New("config: password min length must be between 1 and the max length")
+	case cfg.PasswordPolicy.MaxLength > MaxPasswordBytes:
+		return fmt.Errorf("config: password max length can't be more than %d bytes", MaxPasswordBytes)
+	case cfg.PasswordPolicy.MinEntropy < 0:
+		return errors.New("config: password min entropy can't be negative")
+	case cfg.PasswordHash != HashBcrypt && cfg.PasswordHash != HashArgon2id:
+		return fmt.Errorf("config: password hash must be %q or %q", HashBcrypt, HashArgon2id)
+	case cfg.BcryptCost < 4 || cfg.BcryptCost > 31:
+		return errors.New("config: bcrypt cost must be between 4 and 31")
+	case cfg.Argon2.Time < 1 || cfg.Argon2.Threads < 1 || cfg.Argon2.MemoryKiB < 8*uint32(cfg.Argon2.Threads):
+		return errors.New("config: argon2 needs a time and threads of at least 1 and 8 KiB of memory per thread")
+	}
+	return nil
+}
+
+// returns the deadline for a request to the given route
+// zero means the request has no deadline
+func (cfg *Config) TimeoutFor(method, path string) time.Duration {
+	if d, ok := cfg.RouteTimeouts[method+" "+path]; ok {
+		return d.Duration
+	}
+	return cfg.QueryTimeout.Duration
+}
+
+// reports whether the server should listen with https
+func (cfg *Config) UseTLS() bool {
+	return cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != ""
+}
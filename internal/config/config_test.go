@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// returns a getenv funct backed by the given map
+func fakeEnv(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil, fakeEnv(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := Default()
+	if cfg.DatabaseURL != want.DatabaseURL || cfg.ListenAddr != want.ListenAddr {
+		t.Errorf("got %+v, want defaults %+v", cfg, want)
+	}
+	if cfg.UseTLS() {
+		t.Error("tls should be off by default")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conch.json")
+	file := `{
+		"database_url": "postgres://file@localhost/bikeshop",
+		"max_conns": 4,
+		"listen_addr": ":7000",
+		"read_timeout": "3s"
+	}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := fakeEnv(map[string]string{
+		"CONCH_CONFIG":       path,
+		"CONCH_DATABASE_URL": "postgres://env@localhost/bikeshop",
+		"CONCH_LISTEN_ADDR":  ":7001",
+	})
+	cfg, err := Load([]string{"-addr", ":7002"}, env)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.MaxConns != 4 {
+		t.Errorf("MaxConns = %d, want 4 from the file", cfg.MaxConns)
+	}
+	if cfg.ReadTimeout.Duration != 3*time.Second {
+		t.Errorf("ReadTimeout = %v, want 3s from the file", cfg.ReadTimeout)
+	}
+	if cfg.DatabaseURL != "postgres://env@localhost/bikeshop" {
+		t.Errorf("DatabaseURL = %q, want the env value", cfg.DatabaseURL)
+	}
+	if cfg.ListenAddr != ":7002" {
+		t.Errorf("ListenAddr = %q, want the flag value", cfg.ListenAddr)
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	cfg, err := Load(nil, fakeEnv(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 0 {
+		t.Errorf("TrustedProxies = %v, want no proxy trusted by default", cfg.TrustedProxies)
+	}
+
+	env := fakeEnv(map[string]string{"CONCH_TRUSTED_PROXIES": "10.0.0.1"})
+	cfg, err = Load([]string{"-trusted-proxies", "10.0.0.0/8, 192.168.1.7"}, env)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 2 || cfg.TrustedProxies[0] != "10.0.0.0/8" || cfg.TrustedProxies[1] != "192.168.1.7" {
+		t.Errorf("TrustedProxies = %q, want the flag's two entries", cfg.TrustedProxies)
+	}
+
+	if _, err := Load([]string{"-trusted-proxies", "my-proxy"}, fakeEnv(nil)); err == nil {
+		t.Error("expected an error for a proxy that isn't an address")
+	}
+}
+
+func TestLoadRejectsHalfTLS(t *testing.T) {
+	_, err := Load([]string{"-tls-cert", "cert.pem"}, fakeEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error when the tls key is missing")
+	}
+}
+
+func TestTimeoutFor(t *testing.T) {
+	cfg := Default()
+	cfg.QueryTimeout = Duration{2 * time.Second}
+	cfg.RouteTimeouts = map[string]Duration{
+		"GET /invoices": {30 * time.Second},
+	}
+
+	if got := cfg.TimeoutFor("GET", "/invoices"); got != 30*time.Second {
+		t.Errorf("GET /invoices timeout = %v, want 30s", got)
+	}
+	if got := cfg.TimeoutFor("POST", "/invoices/"); got != 2*time.Second {
+		t.Errorf("POST /invoices/ timeout = %v, want the 2s default", got)
+	}
+}
+
+func TestLoadJWTModeNeedsKeys(t *testing.T) {
+	_, err := Load([]string{"-token-mode", "jwt"}, fakeEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error when jwt mode has no signing keys")
+	}
+	_, err = Load(nil, fakeEnv(map[string]string{"CONCH_TOKEN_MODE": "paseto"}))
+	if err == nil {
+		t.Fatal("expected an error for an unknown token mode")
+	}
+}
+
+func TestLoadPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conch.json")
+	file := `{"password_policy": {"require_symbol": true, "min_length": 12}}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load([]string{"-config", path, "-password-max-length", "64"}, fakeEnv(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	policy := cfg.PasswordPolicy
+	if !policy.RequireSymbol || policy.MinLength != 12 || policy.MaxLength != 64 {
+		t.Errorf("policy = %+v, want the file and flag values", policy)
+	}
+	if !policy.RequireUpper || policy.MinEntropy != Default().PasswordPolicy.MinEntropy {
+		t.Errorf("policy = %+v, want the defaults the file left out", policy)
+	}
+
+	if _, err := Load([]string{"-password-max-length", "100"}, fakeEnv(nil)); err == nil {
+		t.Error("expected an error for a max length past bcrypt's limit")
+	}
+}
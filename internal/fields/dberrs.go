@@ -0,0 +1,149 @@
+package fields
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const Conflict = 409
+const UnprocessableEntity = 422
+const InternalServerError = 500
+
+// SQLSTATE codes conch knows how to explain to a client
+// see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	sqlStringTooLong   = "22001" // string_data_right_truncation
+	sqlNumericOverflow = "22003" // numeric_value_out_of_range
+	sqlNotNull         = "23502" // not_null_violation
+	sqlForeignKey      = "23503" // foreign_key_violation
+	sqlUnique          = "23505" // unique_violation
+	sqlCheck           = "23514" // check_violation
+	sqlConnException   = "08"    // class 08, connection exception
+)
+
+// a database failure translated into something the client can act on
+type DomainError struct {
+	Status int
+	Field  string
+	Code   string
+	Msg    string
+}
+
+func (domainErr *DomainError) Error() string {
+	return domainErr.Msg
+}
+
+var (
+	ErrDuplicateUsername = &DomainError{Conflict, "username", CodeDuplicate,
+		"Conflict: username is already taken"}
+	ErrDuplicateContact = &DomainError{Conflict, "", CodeDuplicate,
+		"Conflict: an account with that fname, lname and address already exists"}
+	ErrDuplicateToken = &DomainError{Conflict, "", CodeDuplicate,
+		"Conflict: the generated token is already in use, try logging in again"}
+	ErrDuplicateInvoice = &DomainError{Conflict, "", CodeDuplicate,
+		"Conflict: an identical invoice already exists"}
+	ErrDuplicateAPIKeyName = &DomainError{Conflict, "name", CodeDuplicate,
+		"Conflict: you already have an api key with that name"}
+	ErrDuplicate = &DomainError{Conflict, "", CodeDuplicate,
+		"Conflict: a record with the same values already exists"}
+	ErrVarcharTooLong = &DomainError{UnprocessableEntity, "", CodeVarcharTooLong,
+		"varchar too long, use varchar length between 1-255"}
+	ErrNumericOverflow = &DomainError{UnprocessableEntity, "", CodeNumericOverflow,
+		"numeric field overflow, value is out of range for its column"}
+	ErrMissingValue = &DomainError{UnprocessableEntity, "", CodeRequired,
+		"Error: a required value is missing"}
+	ErrMissingReference = &DomainError{UnprocessableEntity, "", CodeNotFound,
+		"Error: the record this refers to doesn't exist"}
+	ErrCheckFailed = &DomainError{UnprocessableEntity, "", CodeValidation,
+		"Error: a value is outside of its allowed range"}
+	ErrUnavailable = &DomainError{ServiceUnavailable, "", CodeUnavailable,
+		"Unavailable Error: failed to connect to the database"}
+	ErrDatabase = &DomainError{InternalServerError, "", CodeDatabase,
+		"Database Error: the request couldn't be completed"}
+)
+
+// maps the schema's constraint names to the errors they raise
+var constraintErrs = map[string]*DomainError{
+	"usernames_username_key":                                  ErrDuplicateUsername,
+	"usercontacts_fname_lname_address_key":                    ErrDuplicateContact,
+	"tokens_refresh_token_key":                                ErrDuplicateToken,
+	"tokens_token_key":                                        ErrDuplicateToken,
+	"invoices_id_user_id_product_category_price_quantity_key": ErrDuplicateInvoice,
+	"api_keys_user_id_name_key":                               ErrDuplicateAPIKeyName,
+}
+
+// TranslateDBError maps an error returned by pgx to a domain error
+// by its SQLSTATE code and constraint name, never by its msg text.
+// errors that aren't from postgres are reported as ErrDatabase,
+// unless they're already a DomainError
+func TranslateDBError(err error) *DomainError {
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		return domainErr
+	}
+
+	var connErr *pgconn.ConnectError
+	if errors.As(err, &connErr) {
+		return ErrUnavailable
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ErrDatabase
+	}
+	if domainErr, ok := constraintErrs[pgErr.ConstraintName]; ok {
+		return domainErr
+	}
+
+	switch {
+	case pgErr.Code == sqlUnique:
+		domainErr = ErrDuplicate
+	case pgErr.Code == sqlStringTooLong:
+		domainErr = ErrVarcharTooLong
+	case pgErr.Code == sqlNumericOverflow:
+		domainErr = ErrNumericOverflow
+	case pgErr.Code == sqlNotNull:
+		domainErr = ErrMissingValue
+	case pgErr.Code == sqlForeignKey:
+		domainErr = ErrMissingReference
+	case pgErr.Code == sqlCheck:
+		domainErr = ErrCheckFailed
+	case strings.HasPrefix(pgErr.Code, sqlConnException):
+		return ErrUnavailable
+	default:
+		return ErrDatabase
+	}
+
+	// point at the column when postgres tells us which one
+	if pgErr.ColumnName != "" {
+		withField := *domainErr
+		withField.Field = pgErr.ColumnName
+		return &withField
+	}
+	return domainErr
+}
+
+// adds the translated database error to the list of errors
+// unexpected errors are logged since their text isn't sent to the client
+func (fieldErr *GrammarError) AddDBErr(err error) {
+	domainErr := TranslateDBError(err)
+	if domainErr == ErrDatabase {
+		log.Printf("database error: %v", err)
+	}
+	fieldErr.AddMsg(domainErr.Status, domainErr.Field, domainErr.Code, domainErr.Msg)
+}
+
+// records why a unit of work failed, such as its commit being rejected,
+// unless a step inside it already recorded the failure
+func (fieldErr *GrammarError) AddTxErr(ctx context.Context, err error) {
+	if err == nil || fieldErr.ErrMsgs != nil {
+		return
+	}
+	if !fieldErr.AddCtxErr(ctx, err) {
+		fieldErr.AddDBErr(err)
+	}
+}
@@ -1,38 +1,76 @@
 package fields
 
 import (
+	"context"
+	"errors"
 	"strconv"
 	"strings"
 )
 
+// holds the error msgs for a single request along with
+// the http-status code that request should be answered with
 type GrammarError struct {
-	ErrMsgs []string
+	ErrMsgs    []string
+	Violations []Violation
+	Status     int // http-status code for errors
 }
 
-var ErrorCode int // http-status code for errors
 const BadRequest = 400
+const Forbidden = 403
 const ResourceNotFound = 404
-
-// helper funct: takes a pointer to an InvoiceErorr, HttpStatusCode and a string msg
-// as parameters and sets the values for the GrammarError struct.
-// By default content-type is of type 'application/json'
-func (fieldErr *GrammarError) AddMsg(statusCode int, str string) {
-	ErrorCode = statusCode
+const Locked = 423
+const TooManyRequests = 429
+const ServiceUnavailable = 503
+const GatewayTimeout = 504
+
+// helper funct: takes a pointer to an InvoiceErorr, HttpStatusCode, the name of the
+// offending field, a machine-readable code and a string msg as parameters
+// and sets the values for the GrammarError struct.
+// field is left empty when the error isn't about a single field.
+// The status code of the latest msg is the one the request is answered with.
+func (fieldErr *GrammarError) AddMsg(statusCode int, field, code, str string) {
+	fieldErr.Status = statusCode
 	fieldErr.ErrMsgs = append(fieldErr.ErrMsgs, str)
+	fieldErr.Violations = append(fieldErr.Violations, Violation{
+		Field:  field,
+		Code:   code,
+		Detail: str,
+	})
+}
+
+// adds a timeout or cancellation msg when a query failed
+// because the request's context ended, returns true if it did.
+// a deadline is reported as a 504 and a cancelled request as a 503
+func (fieldErr *GrammarError) AddCtxErr(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		fieldErr.AddMsg(GatewayTimeout, "", CodeTimeout, "Timeout Error: the database took too long to respond")
+		return true
+	case errors.Is(err, context.Canceled):
+		fieldErr.AddMsg(ServiceUnavailable, "", CodeCancelled, "Unavailable Error: the request was cancelled before it finished")
+		return true
+	}
+	return false
 }
 
 // checks for empty text-fields in an invoice
 // if there an error its added to an error slice
 func isTextFieldEmpty(fieldName string, val *string, fieldErr *GrammarError) {
 	if *val == "" {
-		fieldErr.AddMsg(BadRequest, "Error: "+fieldName+" can't be empty")
+		fieldErr.AddMsg(BadRequest, JSONName(fieldName), CodeRequired, "Error: "+fieldName+" can't be empty")
 	}
 }
 
 func hasNoDigits(fieldName string, val *string, fieldErr *GrammarError) {
 	digitFilter := "0123456789"
 	if isTextInvalid(*val, digitFilter) {
-		fieldErr.AddMsg(BadRequest, "Error: "+fieldName+" can't have any digits")
+		fieldErr.AddMsg(BadRequest, JSONName(fieldName), CodeHasDigits, "Error: "+fieldName+" can't have any digits")
 	}
 }
 
@@ -49,7 +87,7 @@ func hasNoPunct(fieldName string, val *string, fieldErr *GrammarError) {
 	}
 
 	if isTextInvalid(*val, punctFilter) {
-		fieldErr.AddMsg(BadRequest, "Error: "+fieldName+" can't have any punctuation")
+		fieldErr.AddMsg(BadRequest, JSONName(fieldName), CodeHasPunct, "Error: "+fieldName+" can't have any punctuation")
 	}
 }
 
@@ -67,7 +105,7 @@ func hasNoSymbols(fieldName string, val *string, fieldErr *GrammarError) {
 
 	// check for symbols: first-name, last-name, category, product
 	if isTextInvalid(*val, symbolFilter) {
-		fieldErr.AddMsg(BadRequest, "Error: "+fieldName+" can't have any Symbols")
+		fieldErr.AddMsg(BadRequest, JSONName(fieldName), CodeHasSymbols, "Error: "+fieldName+" can't have any Symbols")
 	}
 }
 
@@ -83,33 +121,15 @@ func isTextInvalid(val, charFilter string) bool {
 func isFieldTooLong(fieldName string, val *string, gramErr *GrammarError, minimum, maximum int) {
 	fieldLen := len(*val)
 	if fieldLen < minimum {
-		gramErr.AddMsg(BadRequest, "Error: "+fieldName+" is too short, expected "+
+		gramErr.AddMsg(BadRequest, JSONName(fieldName), CodeTooShort, "Error: "+fieldName+" is too short, expected "+
 			strconv.Itoa(minimum)+"-"+strconv.Itoa(maximum)+" chars")
 	}
 	if fieldLen > maximum {
-		gramErr.AddMsg(BadRequest, "Error: "+fieldName+" is too long, expected "+
+		gramErr.AddMsg(BadRequest, JSONName(fieldName), CodeTooLong, "Error: "+fieldName+" is too long, expected "+
 			strconv.Itoa(minimum)+"-"+strconv.Itoa(maximum)+" chars")
 	}
 }
 
-// checks to see if there any capital letters in string val
-// adds an new error to fieldErrs if none exist
-func hasCaps(val *string, fieldErr *GrammarError) {
-	capLst := "ABCDEFGHIJKLMNOPQRYTUVWXYZ"
-	if !strings.ContainsAny(*val, capLst) {
-		fieldErr.AddMsg(BadRequest, "Error: Password must contain one or more capital letters")
-	}
-}
-
-// checks to see if there are any digits in string val
-// adds an new error to fieldErrs if none exist
-func hasNums(val *string, fieldErr *GrammarError) {
-	nums := "0123456789"
-	if !strings.ContainsAny(*val, nums) {
-		fieldErr.AddMsg(BadRequest, "Error: Password must contain one or more digits")
-	}
-}
-
 // checks a field for punctuation, digits, and symbols
 func CheckGrammar(fieldName string, val *string, fieldErr *GrammarError) {
 
@@ -130,15 +150,9 @@ func CheckGrammar(fieldName string, val *string, fieldErr *GrammarError) {
 		hasNoSymbols(name, val, fieldErr)
 	}
 
-	if name == "Username" ||
-		name == "Password" {
+	if name == "Username" {
 		isFieldTooLong(name, val, fieldErr, 8, 16)
 	}
-
-	if name == "Password" {
-		hasCaps(val, fieldErr)
-		hasNums(val, fieldErr)
-	}
 }
 
 // swaps the orig values of an invoice for new ones
@@ -162,13 +176,7 @@ func CheckGrammarForPatch(val *string, fieldName string, orig string, fieldErr *
 		hasNoSymbols(fieldName, val, fieldErr)
 	}
 
-	if name == "Username" ||
-		name == "Password" {
+	if name == "Username" {
 		isFieldTooLong(name, val, fieldErr, 8, 16)
 	}
-
-	if name == "Password" {
-		hasCaps(val, fieldErr)
-		hasNums(val, fieldErr)
-	}
 }
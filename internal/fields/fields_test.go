@@ -0,0 +1,142 @@
+package fields
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// each GrammarError keeps the status of its own msgs
+// even when many requests are building errors at once
+// run with -race to catch any shared state
+func TestAddMsgConcurrentStatus(t *testing.T) {
+	statuses := []int{BadRequest, ResourceNotFound, ServiceUnavailable, GatewayTimeout}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		want := statuses[i%len(statuses)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var fieldErr GrammarError
+			for j := 0; j < 50; j++ {
+				fieldErr.AddMsg(want, "", CodeDatabase, "Error: something went wrong")
+			}
+			if fieldErr.Status != want {
+				t.Errorf("Status = %d, want %d", fieldErr.Status, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCheckGrammarConcurrentStatus(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var fieldErr GrammarError
+			val := ""
+			CheckGrammar("Fname", &val, &fieldErr)
+			if fieldErr.Status != BadRequest {
+				t.Errorf("Status = %d, want %d", fieldErr.Status, BadRequest)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var fieldErr GrammarError
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			fieldErr.AddCtxErr(ctx, ctx.Err())
+			if fieldErr.Status != ServiceUnavailable {
+				t.Errorf("Status = %d, want %d", fieldErr.Status, ServiceUnavailable)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestValidFieldHasNoStatus(t *testing.T) {
+	var fieldErr GrammarError
+	val := "Wrench"
+	CheckGrammar("Product", &val, &fieldErr)
+	if fieldErr.ErrMsgs != nil || fieldErr.Status != 0 {
+		t.Errorf("got %+v, want no errors", fieldErr)
+	}
+}
+
+func TestProblemSingleViolation(t *testing.T) {
+	var fieldErr GrammarError
+	val := "R2D2"
+	CheckGrammar("Fname", &val, &fieldErr)
+
+	prob := fieldErr.Problem()
+	if prob.Status != BadRequest || prob.Code != CodeHasDigits || prob.Field != "fname" {
+		t.Errorf("got %+v, want a has_digits problem for fname", prob)
+	}
+	if prob.Type != "/problems/"+CodeHasDigits {
+		t.Errorf("Type = %q", prob.Type)
+	}
+}
+
+func TestProblemManyViolations(t *testing.T) {
+	var fieldErr GrammarError
+	policy, err := NewPasswordPolicy(config.Default().PasswordPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Check("short", "", &fieldErr)
+
+	prob := fieldErr.Problem()
+	if prob.Code != CodeValidation || prob.Field != "" {
+		t.Errorf("got %+v, want a validation_failed problem", prob)
+	}
+	wantCodes := []string{CodeTooShort, CodeMissingCaps, CodeMissingDigits}
+	if len(prob.Violations) != len(wantCodes) {
+		t.Fatalf("got %d violations, want %d", len(prob.Violations), len(wantCodes))
+	}
+	for i, code := range wantCodes {
+		if v := prob.Violations[i]; v.Code != code || v.Field != "password" {
+			t.Errorf("violation %d = %+v, want code %s on password", i, v, code)
+		}
+	}
+}
+
+func TestTranslateDBError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want *DomainError
+	}{
+		{"duplicate username", &pgconn.PgError{Code: "23505", ConstraintName: "usernames_username_key"}, ErrDuplicateUsername},
+		{"duplicate contact", &pgconn.PgError{Code: "23505", ConstraintName: "usercontacts_fname_lname_address_key"}, ErrDuplicateContact},
+		{"duplicate token", &pgconn.PgError{Code: "23505", ConstraintName: "tokens_refresh_token_key"}, ErrDuplicateToken},
+		{"other unique", &pgconn.PgError{Code: "23505", ConstraintName: "some_new_key"}, ErrDuplicate},
+		{"varchar", &pgconn.PgError{Code: "22001"}, ErrVarcharTooLong},
+		{"numeric", &pgconn.PgError{Code: "22003"}, ErrNumericOverflow},
+		{"wrapped", fmt.Errorf("scanning: %w", &pgconn.PgError{Code: "23503"}), ErrMissingReference},
+		{"connection", &pgconn.PgError{Code: "08006"}, ErrUnavailable},
+		{"not postgres", errors.New("boom"), ErrDatabase},
+	}
+	for _, tt := range tests {
+		if got := TranslateDBError(tt.err); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateDBErrorNamesColumn(t *testing.T) {
+	got := TranslateDBError(&pgconn.PgError{Code: "23502", ColumnName: "fname"})
+	if got.Status != UnprocessableEntity || got.Code != CodeRequired || got.Field != "fname" {
+		t.Errorf("got %+v, want a 422 required error on fname", got)
+	}
+	if ErrMissingValue.Field != "" {
+		t.Error("naming the column must not modify the shared ErrMissingValue")
+	}
+}
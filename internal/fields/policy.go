@@ -0,0 +1,182 @@
+package fields
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ScriptMang/conch/internal/config"
+)
+
+// the rules every new password is checked against
+// it's reported to clients as is, minus the denylist itself
+type PasswordPolicy struct {
+	MinLength        int     `json:"min_length"` // in characters
+	MaxLength        int     `json:"max_length"` // in bytes
+	RequireUpper     bool    `json:"require_uppercase"`
+	RequireLower     bool    `json:"require_lowercase"`
+	RequireDigit     bool    `json:"require_digit"`
+	RequireSymbol    bool    `json:"require_symbol"`
+	DisallowUsername bool    `json:"disallow_username"`
+	RejectCommon     bool    `json:"reject_common_passwords"`
+	MinEntropy       float64 `json:"min_entropy_bits"`
+
+	denylist map[string]bool
+}
+
+// builds a policy from the config, reading its denylist file if it has one
+func NewPasswordPolicy(cfg config.PasswordPolicy) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength:        cfg.MinLength,
+		MaxLength:        cfg.MaxLength,
+		RequireUpper:     cfg.RequireUpper,
+		RequireLower:     cfg.RequireLower,
+		RequireDigit:     cfg.RequireDigit,
+		RequireSymbol:    cfg.RequireSymbol,
+		DisallowUsername: cfg.DisallowUsername,
+		MinEntropy:       cfg.MinEntropy,
+	}
+	if cfg.DenylistFile == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(cfg.DenylistFile)
+	if err != nil {
+		return nil, fmt.Errorf("password denylist: %w", err)
+	}
+	defer file.Close()
+
+	policy.denylist = make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.denylist[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("password denylist: %w", err)
+	}
+	policy.RejectCommon = len(policy.denylist) > 0
+	return policy, nil
+}
+
+// checks a new password against the policy
+// pass the account's username so passwords containing it can be refused
+func (policy *PasswordPolicy) Check(password, username string, fieldErr *GrammarError) {
+	isTextFieldEmpty("Password", &password, fieldErr)
+	if password != "" {
+		policy.check(password, username, fieldErr)
+	}
+}
+
+// helper funct that adds a msg for each rule the password breaks.
+// the denylist and entropy are only checked once the password
+// has the right length and characters
+func (policy *PasswordPolicy) check(password, username string, fieldErr *GrammarError) {
+	before := len(fieldErr.ErrMsgs)
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		fieldErr.AddMsg(BadRequest, "password", CodeTooShort,
+			"Error: Password is too short, expected at least "+strconv.Itoa(policy.MinLength)+" chars")
+	}
+	if len(password) > policy.MaxLength {
+		fieldErr.AddMsg(BadRequest, "password", CodeTooLong,
+			"Error: Password is too long, expected at most "+strconv.Itoa(policy.MaxLength)+" bytes")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		fieldErr.AddMsg(BadRequest, "password", CodeMissingCaps, "Error: Password must contain one or more capital letters")
+	}
+	if policy.RequireLower && !hasLower {
+		fieldErr.AddMsg(BadRequest, "password", CodeMissingLower, "Error: Password must contain one or more lowercase letters")
+	}
+	if policy.RequireDigit && !hasDigit {
+		fieldErr.AddMsg(BadRequest, "password", CodeMissingDigits, "Error: Password must contain one or more digits")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		fieldErr.AddMsg(BadRequest, "password", CodeMissingSymbol, "Error: Password must contain one or more symbols")
+	}
+	if len(fieldErr.ErrMsgs) > before {
+		return
+	}
+
+	lowered := strings.ToLower(password)
+	if policy.DisallowUsername && username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		fieldErr.AddMsg(BadRequest, "password", CodeHasUsername, "Error: Password can't contain the username")
+	}
+	if policy.denylist[lowered] {
+		fieldErr.AddMsg(BadRequest, "password", CodeCommonPassword, "Error: Password is too common, pick another one")
+		return
+	}
+	if policy.MinEntropy > 0 && EstimateEntropy(password) < policy.MinEntropy {
+		fieldErr.AddMsg(BadRequest, "password", CodeWeakPassword,
+			"Error: Password is too easy to guess, make it longer or less repetitive")
+	}
+}
+
+// estimates the bits of entropy in a password from the kinds of
+// characters it uses. a character that repeats the one before it or
+// continues a run like "abc" or "321" only counts for one bit
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	prev := rune(-1)
+	for _, r := range password {
+		if prev >= 0 && (r == prev || r == prev+1 || r == prev-1) {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
@@ -0,0 +1,101 @@
+package fields
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/config"
+)
+
+// returns the codes of the violations a password gets under the policy
+func policyCodes(t *testing.T, policy *PasswordPolicy, password, username string) []string {
+	t.Helper()
+	var fieldErr GrammarError
+	policy.check(password, username, &fieldErr)
+	codes := make([]string, len(fieldErr.Violations))
+	for i, v := range fieldErr.Violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicyDefaults(t *testing.T) {
+	policy, err := NewPasswordPolicy(config.Default().PasswordPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		username string
+		want     string
+	}{
+		{"Sunflower7", "", ""}, // S used to be missing from the capital letters
+		{"a long passphrase 7 With Spaces", "", ""},
+		{"sunflower7", "", CodeMissingCaps},
+		{"Sunflower", "", CodeMissingDigits},
+		{"Sunflower7", "sunflower", CodeHasUsername},
+		{"Aaaaaaaaaa1", "", CodeWeakPassword},
+		{"Abcdefghijk1", "", CodeWeakPassword},
+		{"Sunflower7" + strings.Repeat("x", 63), "", CodeTooLong},
+	}
+	for _, tc := range tests {
+		codes := policyCodes(t, policy, tc.password, tc.username)
+		switch {
+		case tc.want == "" && len(codes) != 0:
+			t.Errorf("%q: got %v, want no violations", tc.password, codes)
+		case tc.want != "" && (len(codes) != 1 || codes[0] != tc.want):
+			t.Errorf("%q: got %v, want %s", tc.password, codes, tc.want)
+		}
+	}
+}
+
+func TestPasswordPolicyDenylist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(path, []byte("# common passwords\nPassword1\n\nletmein\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default().PasswordPolicy
+	cfg.DenylistFile = path
+	policy, err := NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.RejectCommon {
+		t.Error("RejectCommon should be reported once a denylist is loaded")
+	}
+
+	if codes := policyCodes(t, policy, "PASSWORD1", ""); len(codes) != 1 || codes[0] != CodeCommonPassword {
+		t.Errorf("got %v, want common_password regardless of case", codes)
+	}
+
+	cfg.DenylistFile = filepath.Join(t.TempDir(), "missing.txt")
+	if _, err := NewPasswordPolicy(cfg); err == nil {
+		t.Error("expected an error for a missing denylist file")
+	}
+}
+
+func TestPasswordPolicyClasses(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 4, MaxLength: 72, RequireLower: true, RequireSymbol: true}
+	codes := policyCodes(t, policy, "ABCD", "")
+	if len(codes) != 2 || codes[0] != CodeMissingLower || codes[1] != CodeMissingSymbol {
+		t.Errorf("got %v, want missing_lowercase and missing_symbol", codes)
+	}
+	if codes := policyCodes(t, policy, "abc!", ""); len(codes) != 0 {
+		t.Errorf("got %v, want no violations", codes)
+	}
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	if EstimateEntropy("") != 0 {
+		t.Error("an empty password should have no entropy")
+	}
+	if run, mixed := EstimateEntropy("abcdefgh"), EstimateEntropy("qzmtrwkp"); run >= mixed {
+		t.Errorf("a run of letters (%.1f bits) should be weaker than mixed letters (%.1f bits)", run, mixed)
+	}
+	if lower, mixed := EstimateEntropy("qzmtrwkp"), EstimateEntropy("qZmT4w!p"); lower >= mixed {
+		t.Errorf("lowercase (%.1f bits) should be weaker than mixed classes (%.1f bits)", lower, mixed)
+	}
+}
@@ -0,0 +1,112 @@
+package fields
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the media type every error response is sent with (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// stable, machine-readable codes sent with every problem
+// clients should match on these rather than on the detail text
+const (
+	CodeRequired        = "required"
+	CodeHasDigits       = "has_digits"
+	CodeHasPunct        = "has_punctuation"
+	CodeHasSymbols      = "has_symbols"
+	CodeTooShort        = "too_short"
+	CodeTooLong         = "too_long"
+	CodeMissingCaps     = "missing_uppercase"
+	CodeMissingDigits   = "missing_digit"
+	CodeMissingLower    = "missing_lowercase"
+	CodeMissingSymbol   = "missing_symbol"
+	CodeHasUsername     = "has_username"
+	CodeCommonPassword  = "common_password"
+	CodeWeakPassword    = "too_weak"
+	CodeZero            = "zero_value"
+	CodeNegative        = "negative_value"
+	CodeVarcharTooLong  = "varchar_too_long"
+	CodeNumericOverflow = "numeric_overflow"
+	CodeIntOverflow     = "integer_overflow"
+	CodeInvalidType     = "invalid_type"
+	CodeMalformedJSON   = "malformed_json"
+	CodeInvalidBody     = "invalid_body"
+	CodeInvalidID       = "invalid_id"
+	CodeInvalidQuery    = "invalid_query"
+	CodeInvalidCursor   = "invalid_cursor"
+	CodeNotFound        = "not_found"
+	CodeDuplicate       = "duplicate"
+	CodeUnauthorized    = "unauthorized"
+	CodeInvalidCreds    = "invalid_credentials"
+	CodeTokenExpired    = "token_expired"
+	CodeForbidden       = "forbidden"
+	CodeInvalidRole     = "invalid_role"
+	CodeInvalidToken    = "invalid_token"
+	CodeAccountLocked   = "account_locked"
+	CodeTooManyAttempts = "too_many_attempts"
+	CodeTOTPRequired    = "totp_required"
+	CodeInvalidTOTP     = "invalid_totp"
+	CodeTOTPEnabled     = "totp_enabled"
+	CodeInvalidScope    = "invalid_scope"
+	CodeMissingScope    = "insufficient_scope"
+	CodeHashing         = "hashing_failed"
+	CodeDatabase        = "database_error"
+	CodeUnavailable     = "unavailable"
+	CodeTimeout         = "timeout"
+	CodeCancelled       = "request_cancelled"
+	CodeValidation      = "validation_failed"
+)
+
+// a single problem with the request, usually tied to one field
+type Violation struct {
+	Field  string `json:"field,omitempty"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// an RFC 7807 problem details document
+type Problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Code       string      `json:"code"`
+	Detail     string      `json:"detail,omitempty"`
+	Field      string      `json:"field,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// returns the name a field goes by in the request's json body
+func JSONName(fieldName string) string {
+	return strings.ToLower(fieldName)
+}
+
+// builds the problem document sent to the client for the error's msgs.
+// a lone violation is promoted to the top level code and field,
+// several violations are reported together as a validation failure
+func (fieldErr GrammarError) Problem() Problem {
+	status := fieldErr.Status
+	if status == 0 {
+		status = BadRequest
+	}
+	prob := Problem{
+		Title:      http.StatusText(status),
+		Status:     status,
+		Violations: fieldErr.Violations,
+	}
+
+	switch len(fieldErr.Violations) {
+	case 0:
+		prob.Code = strings.ReplaceAll(strings.ToLower(prob.Title), " ", "_")
+	case 1:
+		prob.Code = fieldErr.Violations[0].Code
+		prob.Field = fieldErr.Violations[0].Field
+		prob.Detail = fieldErr.Violations[0].Detail
+	default:
+		prob.Code = CodeValidation
+		prob.Detail = strconv.Itoa(len(fieldErr.Violations)) + " problems were found with the request"
+	}
+	prob.Type = "/problems/" + prob.Code
+	return prob
+}
@@ -1,8 +1,9 @@
 package invs
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"math"
 
 	"github.com/ScriptMang/conch/internal/accts"
 	"github.com/ScriptMang/conch/internal/bikeshop"
@@ -22,69 +23,93 @@ type Invoice struct {
 
 type Invoices []*Invoice
 
+// a text field and its name for the grammar checks
+// kept in a slice so the fields are always checked in the same order
+type textField struct {
+	name string
+	val  *string
+}
+
+// the largest price that fits the numeric(5,2) price column
+const maxPrice = 999.99
+
+// rejects a price or quantity too large for its column
+// so the insert never has to fail in the database
+func (inv *Invoice) checkOverflow(fieldErr *fields.GrammarError) {
+	if inv.Price > maxPrice {
+		fieldErr.AddMsg(fields.UnprocessableEntity, "price", fields.CodeNumericOverflow,
+			"numeric field overflow, provide a value between 1.00 - 999.99")
+	}
+	if inv.Quantity > math.MaxInt32 {
+		fieldErr.AddMsg(fields.UnprocessableEntity, "quantity", fields.CodeIntOverflow,
+			"integer overflow, value must be between 1 - 2147483647")
+	}
+}
+
 // takes an invoice and throws an error for any field with an invalid input
 func (inv *Invoice) validateAllFields(userContact accts.UserContacts) fields.GrammarError {
 	// check for empty fields: for all the fields
-	textFields := map[string]*string{
-		"Fname":    &userContact.Fname,
-		"Lname":    &userContact.Lname,
-		"Category": &inv.Category,
-		"Product":  &inv.Product,
-		"Address":  &userContact.Address,
+	textFields := []textField{
+		{"Fname", &userContact.Fname},
+		{"Lname", &userContact.Lname},
+		{"Category", &inv.Category},
+		{"Product", &inv.Product},
+		{"Address", &userContact.Address},
 	}
 	var fieldErr fields.GrammarError
-	for field, val := range textFields {
-		fields.CheckGrammar(field, val, &fieldErr)
+	for _, field := range textFields {
+		fields.CheckGrammar(field.name, field.val, &fieldErr)
 	}
 
 	// check for negative values:  price and quantity
 	if inv.Price == 0.00 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: Price can't be zero")
+		fieldErr.AddMsg(fields.BadRequest, "price", fields.CodeZero, "Error: Price can't be zero")
 	} else if inv.Price < 0.00 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: The price can't be negative")
+		fieldErr.AddMsg(fields.BadRequest, "price", fields.CodeNegative, "Error: The price can't be negative")
 		// fmt.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
 	}
 
 	if inv.Quantity == 0 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: Quantity can't be zero")
+		fieldErr.AddMsg(fields.BadRequest, "quantity", fields.CodeZero, "Error: Quantity can't be zero")
 	} else if inv.Quantity < 0 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: The quantity can't be negative")
+		fieldErr.AddMsg(fields.BadRequest, "quantity", fields.CodeNegative, "Error: The quantity can't be negative")
 		// fmt.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
 	}
+	inv.checkOverflow(&fieldErr)
 	return fieldErr
 }
 
 func (inv *Invoice) validateInvFields() fields.GrammarError {
 	// check for empty fields: for all the fields
-	textFields := map[string]*string{
-		"Category": &inv.Category,
-		"Product":  &inv.Product,
+	textFields := []textField{
+		{"Category", &inv.Category},
+		{"Product", &inv.Product},
 	}
 	var fieldErr fields.GrammarError
-	for field, val := range textFields {
-		fields.CheckGrammar(field, val, &fieldErr)
+	for _, field := range textFields {
+		fields.CheckGrammar(field.name, field.val, &fieldErr)
 	}
 
 	// check for negative values:  price and quantity
 	if inv.Price == 0.00 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: Price can't be zero")
+		fieldErr.AddMsg(fields.BadRequest, "price", fields.CodeZero, "Error: Price can't be zero")
 	} else if inv.Price < 0.00 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: The price can't be negative")
+		fieldErr.AddMsg(fields.BadRequest, "price", fields.CodeNegative, "Error: The price can't be negative")
 		// fmt.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
 	}
 
 	if inv.Quantity == 0 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: Quantity can't be zero")
+		fieldErr.AddMsg(fields.BadRequest, "quantity", fields.CodeZero, "Error: Quantity can't be zero")
 	} else if inv.Quantity < 0 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: The quantity can't be negative")
+		fieldErr.AddMsg(fields.BadRequest, "quantity", fields.CodeNegative, "Error: The quantity can't be negative")
 		// fmt.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
 	}
+	inv.checkOverflow(&fieldErr)
 	return fieldErr
 }
 
-func InsertOp(inv Invoice) ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) InsertOp(ctx context.Context, inv Invoice) ([]*Invoice, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var insertedInv Invoice
 	var invs []*Invoice
@@ -102,24 +127,13 @@ func InsertOp(inv Invoice) ([]*Invoice, fields.GrammarError) {
 	)
 
 	err := pgxscan.ScanOne(&insertedInv, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 	// fmt.Printf("The value of the invoice after InsertOP: %+v\n", &insertedInv)
 	if err != nil {
-		qryError := err.Error()
-
-		switch {
-		case strings.Contains(qryError, "numeric field overflow"):
-			// fmt.Printf("ReadInvoicesByUserID funct: error username doesn't exist\n")
-			fieldErr.AddMsg(fields.BadRequest,
-				"numeric field overflow, provide a value between 1.00 - 999.99")
-		case strings.Contains(qryError, "greater than maximum value for int4"):
-			// fmt.Printf("ReadInvoicesByUserID funct: error invoice with specified id doesn't exist\n")
-			fieldErr.AddMsg(fields.BadRequest,
-				"integer overflow, value must be between 1 - 2147483647")
-		case strings.Contains(qryError, "value too long for type character varying"):
-			fieldErr.AddMsg(fields.BadRequest, "varchar too long, use varchar length between 1-255")
-		default:
-			fieldErr.AddMsg(fields.BadRequest, qryError)
-		}
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
 	}
 
 	invs = append(invs, &insertedInv)
@@ -127,54 +141,52 @@ func InsertOp(inv Invoice) ([]*Invoice, fields.GrammarError) {
 }
 
 // // returns all the invoices in the database a slice []*Invoice
-func ReadInvoices() ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) ReadInvoices(ctx context.Context) ([]*Invoice, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var invs Invoices
 	fieldErr := fields.GrammarError{}
 	rows, _ := db.Query(ctx, `SELECT * FROM invoices`)
 	err := pgxscan.ScanAll(&invs, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 	// fmt.Printf("So Far no errs in ReadInvoices\n")
 	if err != nil {
-		errMsg := err.Error()
 		// fmt.Printf("Houston there's an err in ReadInvoices\n")
-		if strings.Contains(errMsg, "failed to connect to `user=username") {
-			fieldErr.ErrMsgs = nil
-			fieldErr.AddMsg(fields.BadRequest,
-				"Error: failed to connect to database, username doesn't exist")
-		}
-		// fmt.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
 	}
 
 	return invs, fieldErr
 }
 
-func ReadInvoicesByUserID(id int) ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) ReadInvoicesByUserID(ctx context.Context, id int) ([]*Invoice, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var invoices []*Invoice
-	_, fieldErr := ReadInvoices()
+	_, fieldErr := pg.ReadInvoices(ctx)
 
-	if fieldErr.ErrMsgs != nil &&
-		strings.Contains(fieldErr.ErrMsgs[0], "failed to connect to `user=username") {
+	if fieldErr.ErrMsgs != nil {
 		// log.Printf("ReadInvoicesByUserID funct: Error: username doesn't exist")
 		return nil, fieldErr
 	}
 
 	rows, _ := db.Query(ctx, `SELECT * FROM invoices WHERE user_id = $1`, id)
 	err := pgxscan.ScanAll(&invoices, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 
 	if len(invoices) == 0 {
 		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: user with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
 		return nil, fieldErr
 	}
 
 	if err != nil {
 		// log.Println("Found an Error Iterating in Getting All the Invoices for the Specified User")
-		fieldErr.AddMsg(fields.BadRequest, err.Error())
+		fieldErr.AddDBErr(err)
 		return nil, fieldErr
 	}
 
@@ -183,12 +195,11 @@ func ReadInvoicesByUserID(id int) ([]*Invoice, fields.GrammarError) {
 
 // // return the invoice given the user and invoice id
 // // if the ids don't exist it returns an error
-func ReadInvoiceByUserID(userID, invID int) ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) ReadInvoiceByUserID(ctx context.Context, userID, invID int) ([]*Invoice, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var invoices []*Invoice
-	users, fieldErr := accts.ReadUserContactByID(userID)
+	users, fieldErr := pg.accounts.ReadUserContactByID(ctx, userID)
 
 	if fieldErr.ErrMsgs != nil {
 		// log.Printf("ReadInvoicesByUserID funct: error username doesn't exist")
@@ -196,23 +207,26 @@ func ReadInvoiceByUserID(userID, invID int) ([]*Invoice, fields.GrammarError) {
 	}
 
 	if len(users) == 0 {
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: user with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
 		return invoices, fieldErr
 	}
 
 	rows, _ := db.Query(ctx, `SELECT * FROM invoices WHERE user_id = $1 and id = $2`, userID, invID)
 
 	err := pgxscan.ScanAll(&invoices, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 
 	if len(invoices) == 0 {
 		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
 		return nil, fieldErr
 	}
 
 	if err != nil {
 		// log.Println("Found an Error Iterating in Getting All the Invoices for the Specified User")
-		fieldErr.AddMsg(fields.BadRequest, err.Error())
+		fieldErr.AddDBErr(err)
 		return nil, fieldErr
 	}
 
@@ -224,14 +238,13 @@ func (inv *Invoice) validateFieldsForUpdate(userContact accts.UserContacts) fiel
 }
 
 // updates and returns the given invoice by id
-func UpdateInvoiceByUserID(inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) UpdateInvoiceByUserID(ctx context.Context, inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var inv2 Invoice // resulting invoice
 	var invoices []*Invoice
-	usrs, _ := accts.ReadUserContactByID(userID)
-	_, fieldErr := ReadInvoiceByUserID(userID, invID)
+	usrs, _ := pg.accounts.ReadUserContactByID(ctx, userID)
+	_, fieldErr := pg.ReadInvoiceByUserID(ctx, userID, invID)
 
 	// check readuserbyid for errs
 	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
@@ -252,15 +265,18 @@ func UpdateInvoiceByUserID(inv Invoice, userID, invID int) ([]*Invoice, fields.G
 	)
 
 	err := pgxscan.ScanOne(&inv2, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 	if errors.Is(err, pgx.ErrNoRows) {
 		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
 		return nil, fieldErr
 	}
 
 	if err != nil {
 		// log.Println("Found an Error Iterating in Getting All the Invoices for the Specified User")
-		fieldErr.AddMsg(fields.BadRequest, err.Error())
+		fieldErr.AddDBErr(err)
 		return nil, fieldErr
 	}
 
@@ -274,85 +290,88 @@ func validateFieldsForPatch(invEdit *Invoice, origInv Invoice) fields.GrammarErr
 	// validate fields for Grammars
 
 	// the original user and invoice value
-	textFields := map[string]*string{
-		"Product":  &invEdit.Product,
-		"Category": &invEdit.Category,
+	textFields := []textField{
+		{"Product", &invEdit.Product},
+		{"Category", &invEdit.Category},
 	}
 
-	i := 0
 	var fieldErr fields.GrammarError
 	origVals := []string{origInv.Product, origInv.Category}
-	for field, val := range textFields {
-		fields.CheckGrammarForPatch(val, field, origVals[i], &fieldErr)
-		i++
+	for i, field := range textFields {
+		fields.CheckGrammarForPatch(field.val, field.name, origVals[i], &fieldErr)
 	}
 
 	// check for negative values:  price and quantity
 	if invEdit.Price == 0 {
 		invEdit.Price = origInv.Price // unique to patch requests
 	} else if invEdit.Price != 0.00 && invEdit.Price < 0.00 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: The price can't be negative")
+		fieldErr.AddMsg(fields.BadRequest, "price", fields.CodeNegative, "Error: The price can't be negative")
 		// log.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
 	}
 
 	if invEdit.Quantity == 0 {
 		invEdit.Quantity = origInv.Quantity // unique to patch requests
 	} else if invEdit.Quantity != 0 && invEdit.Quantity < 0 {
-		fieldErr.AddMsg(fields.BadRequest, "Error: The quantity can't be negative")
+		fieldErr.AddMsg(fields.BadRequest, "quantity", fields.CodeNegative, "Error: The quantity can't be negative")
 		// log.Printf("ReadOp List: %s\n", fieldErr.ErrMsgs)
 	}
+	invEdit.checkOverflow(&fieldErr)
 	return fieldErr
 }
 
-func PatchInvoice(inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
-
+// patches run as one unit of work: the invoice is locked while it's read
+// so two patches to the same invoice can't overwrite each other's fields
+func (pg *Postgres) PatchInvoice(ctx context.Context, inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError) {
 	inv.ID = invID
 	var inv2 Invoice // resulting invoice
 	var invs []*Invoice
+	var fieldErr fields.GrammarError
 
-	origInv, fieldErr := ReadInvoiceByUserID(userID, invID)
-	// msgLen := len(fieldErr.ErrMsgs)
-	// fmt.Printf("There are %d field err messages\n", msgLen)
-	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
-		return invs, fieldErr
-	}
-
-	fieldErr = validateFieldsForPatch(&inv, *origInv[0])
-	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
-		return invs, fieldErr
-	}
+	txErr := pg.store.InTx(ctx, func(q bikeshop.Querier) error {
+		var origInv Invoice
+		rows, _ := q.Query(ctx,
+			`SELECT * FROM invoices WHERE user_id = $1 and id = $2 FOR UPDATE`,
+			userID, invID,
+		)
+		err := pgxscan.ScanOne(&origInv, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
+		}
 
-	// fmt.Println("PatchInvoice: modified invoice is ", inv)
-	rows, _ := db.Query(
-		ctx,
-		`UPDATE invoices SET product=$1, price=$2, category=$3, quantity=$4 WHERE id=$5 RETURNING *`,
-		inv.Product, inv.Price, inv.Category, inv.Quantity, inv.ID,
-	)
+		fieldErr = validateFieldsForPatch(&inv, origInv)
+		if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
+			return bikeshop.ErrRollback
+		}
 
-	err := pgxscan.ScanOne(&inv2, rows)
-	if errors.Is(err, pgx.ErrNoRows) {
-		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: invoice with specified id doesn't exist")
-		return nil, fieldErr
-	}
+		// fmt.Println("PatchInvoice: modified invoice is ", inv)
+		rows, _ = q.Query(
+			ctx,
+			`UPDATE invoices SET product=$1, price=$2, category=$3, quantity=$4 WHERE user_id=$5 and id=$6 RETURNING *`,
+			inv.Product, inv.Price, inv.Category, inv.Quantity, userID, inv.ID,
+		)
 
-	if err != nil {
-		qryError := err.Error()
-		switch {
-		case strings.Contains(qryError, "numeric field overflow"):
-			fieldErr.AddMsg(fields.BadRequest,
-				"numeric field overflow, provide a value between 1.00 - 999.99")
-		case strings.Contains(qryError, "greater than maximum value for int4"):
-			// fmt.Printf("ReadInvoicesByUserID funct: error invoice with specified id doesn't exist\n")
-			fieldErr.AddMsg(fields.BadRequest,
-				"integer overflow, value must be between 1 - 2147483647")
-		case strings.Contains(qryError, "value too long for type character varying"):
-			fieldErr.AddMsg(fields.BadRequest, "varchar too long, use varchar length between 1-255")
-		default:
-			fieldErr.AddMsg(fields.BadRequest, qryError)
+		err = pgxscan.ScanOne(&inv2, rows)
+		if fieldErr.AddCtxErr(ctx, err) {
+			return bikeshop.ErrRollback
+		}
+		if err != nil {
+			fieldErr.AddDBErr(err)
+			return bikeshop.ErrRollback
 		}
+		return nil
+	})
+
+	// the commit itself can fail after the update went through
+	fieldErr.AddTxErr(ctx, txErr)
+	if fieldErr.ErrMsgs != nil {
 		return nil, fieldErr
 	}
 
@@ -362,13 +381,12 @@ func PatchInvoice(inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarErr
 
 // delete's the given invoice based on id
 // and return the deleted invoice
-func DeleteInvoice(invID, userID int) ([]*Invoice, fields.GrammarError) {
-	ctx, db := bikeshop.Connect()
-	defer db.Close()
+func (pg *Postgres) DeleteInvoice(ctx context.Context, invID, userID int) ([]*Invoice, fields.GrammarError) {
+	db := pg.store.Pool
 
 	var inv Invoice
 	var invoices []*Invoice
-	_, fieldErr := accts.ReadUserContactByID(userID)
+	_, fieldErr := pg.accounts.ReadUserContactByID(ctx, userID)
 
 	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
 		// fmt.Printf("Error messages is empty for Delete-OP")
@@ -380,15 +398,18 @@ func DeleteInvoice(invID, userID int) ([]*Invoice, fields.GrammarError) {
 		userID, invID)
 
 	err := pgxscan.ScanOne(&inv, row)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
 	if errors.Is(err, pgx.ErrNoRows) {
 		// log.Println("Err: No Rows were Found for the Specified User")
-		fieldErr.AddMsg(fields.ResourceNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
 		return nil, fieldErr
 	}
 
 	if err != nil {
 		// log.Println("Found an Error Iterating in Getting All the Invoices for the Specified User")
-		fieldErr.AddMsg(fields.BadRequest, err.Error())
+		fieldErr.AddDBErr(err)
 		return nil, fieldErr
 	}
 
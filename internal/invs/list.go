@@ -0,0 +1,415 @@
+package invs
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/georgysavva/scany/v2/pgxscan"
+)
+
+// how many invoices a page holds when the request doesn't say, and at most
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// the fields invoices can be sorted by and the sql each one sorts on
+// text is compared byte by byte so every store orders it the same way
+var sortColumns = map[string]string{
+	"id":       "id",
+	"price":    "price",
+	"quantity": "quantity",
+	"product":  `product COLLATE "C"`,
+	"category": `category COLLATE "C"`,
+}
+
+// a field to sort by and its direction
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// how a list of invoices is filtered, sorted and paged
+// nil bounds and empty strings don't filter anything
+type ListQuery struct {
+	Limit       int
+	Sort        []SortKey // always ends with id, so no two invoices tie
+	After       *Cursor   // nil for the first page
+	Category    string    // matched whole, ignoring case
+	Product     string    // matched anywhere in the product, ignoring case
+	MinPrice    *int64    // in cents
+	MaxPrice    *int64    // in cents
+	MinQuantity *int
+	MaxQuantity *int
+}
+
+// one page of invoices, Next is empty on the last page
+type Page struct {
+	Invoices []*Invoice
+	Total    int    // how many invoices match the filters across every page
+	Next     string // the after value that fetches the following page
+}
+
+// where a page ends: the sort it was made with and its last invoice,
+// the next page starts with the first invoice sorted after it
+type Cursor struct {
+	Sort     string `json:"s"`
+	ID       int    `json:"i"`
+	Price    int64  `json:"p"` // in cents
+	Quantity int    `json:"q"`
+	Product  string `json:"pr"`
+	Category string `json:"c"`
+}
+
+// returns the price in cents, the numeric(5,2) price column keeps no more
+func Cents(price float32) int64 {
+	return int64(math.Round(float64(price) * 100))
+}
+
+// writes the sort back out the way the sort parameter takes it
+func sortString(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key.Field
+		if key.Desc {
+			parts[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodes where the page ending with inv leaves off
+func encodeCursor(keys []SortKey, inv *Invoice) string {
+	data, _ := json.Marshal(Cursor{
+		Sort:     sortString(keys),
+		ID:       inv.ID,
+		Price:    Cents(inv.Price),
+		Quantity: inv.Quantity,
+		Product:  inv.Product,
+		Category: inv.Category,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// reads the query parameters of an invoice list:
+// limit, after, sort, category, product, min_price, max_price, min_quantity and max_quantity
+// sort is a comma separated list of fields, each one descending when it starts with -
+func ParseListQuery(values url.Values) (ListQuery, fields.GrammarError) {
+	var fieldErr fields.GrammarError
+	q := ListQuery{Limit: DefaultLimit}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxLimit {
+			fieldErr.AddMsg(fields.BadRequest, "limit", fields.CodeInvalidQuery,
+				fmt.Sprintf("Bad Request: limit has to be a number between 1 - %d", MaxLimit))
+		}
+		q.Limit = n
+	}
+
+	q.Sort = parseSort(values.Get("sort"), &fieldErr)
+	if after := values.Get("after"); after != "" {
+		q.After = parseCursor(after, q.Sort, &fieldErr)
+	}
+
+	q.Category = strings.TrimSpace(values.Get("category"))
+	q.Product = strings.TrimSpace(values.Get("product"))
+	q.MinPrice = parsePrice(values, "min_price", &fieldErr)
+	q.MaxPrice = parsePrice(values, "max_price", &fieldErr)
+	q.MinQuantity = parseQuantity(values, "min_quantity", &fieldErr)
+	q.MaxQuantity = parseQuantity(values, "max_quantity", &fieldErr)
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		fieldErr.AddMsg(fields.BadRequest, "min_price", fields.CodeInvalidQuery,
+			"Bad Request: min_price can't be more than max_price")
+	}
+	if q.MinQuantity != nil && q.MaxQuantity != nil && *q.MinQuantity > *q.MaxQuantity {
+		fieldErr.AddMsg(fields.BadRequest, "min_quantity", fields.CodeInvalidQuery,
+			"Bad Request: min_quantity can't be more than max_quantity")
+	}
+	return q, fieldErr
+}
+
+// reads the sort parameter, invoices are sorted by id when there's none
+func parseSort(sort string, fieldErr *fields.GrammarError) []SortKey {
+	var keys []SortKey
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key := SortKey{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := sortColumns[key.Field]; !ok {
+			fieldErr.AddMsg(fields.BadRequest, "sort", fields.CodeInvalidQuery,
+				fmt.Sprintf("Bad Request: invoices can't be sorted by %q, use id, price, quantity, product or category", key.Field))
+			continue
+		}
+		if seen[key.Field] {
+			fieldErr.AddMsg(fields.BadRequest, "sort", fields.CodeInvalidQuery,
+				fmt.Sprintf("Bad Request: %s is in the sort more than once", key.Field))
+			continue
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+		if key.Field == "id" {
+			// ids are unique, so nothing after it could change the order
+			return keys
+		}
+	}
+	return append(keys, SortKey{Field: "id"})
+}
+
+// decodes an after parameter, it has to come from a page with the same sort
+func parseCursor(after string, keys []SortKey, fieldErr *fields.GrammarError) *Cursor {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(after)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	switch {
+	case err != nil:
+		fieldErr.AddMsg(fields.BadRequest, "after", fields.CodeInvalidCursor,
+			"Bad Request: after has to be the next value of an earlier page")
+		return nil
+	case c.Sort != sortString(keys):
+		fieldErr.AddMsg(fields.BadRequest, "after", fields.CodeInvalidCursor,
+			"Bad Request: after came from a page with a different sort")
+		return nil
+	}
+	return &c
+}
+
+func parsePrice(values url.Values, name string, fieldErr *fields.GrammarError) *int64 {
+	val := values.Get(name)
+	if val == "" {
+		return nil
+	}
+	price, err := strconv.ParseFloat(val, 64)
+	if err != nil || price < 0 || price > maxPrice {
+		fieldErr.AddMsg(fields.BadRequest, name, fields.CodeInvalidQuery,
+			fmt.Sprintf("Bad Request: %s has to be a price between 0.00 - 999.99", name))
+		return nil
+	}
+	inCents := int64(math.Round(price * 100))
+	return &inCents
+}
+
+func parseQuantity(values url.Values, name string, fieldErr *fields.GrammarError) *int {
+	val := values.Get(name)
+	if val == "" {
+		return nil
+	}
+	quantity, err := strconv.Atoi(val)
+	if err != nil || quantity < 0 || quantity > math.MaxInt32 {
+		fieldErr.AddMsg(fields.BadRequest, name, fields.CodeInvalidQuery,
+			fmt.Sprintf("Bad Request: %s has to be a number between 0 - 2147483647", name))
+		return nil
+	}
+	return &quantity
+}
+
+// reports whether the invoice passes the query's filters
+func (q ListQuery) matches(inv *Invoice) bool {
+	price := Cents(inv.Price)
+	switch {
+	case q.Category != "" && !strings.EqualFold(inv.Category, q.Category):
+		return false
+	case q.Product != "" && !strings.Contains(strings.ToLower(inv.Product), strings.ToLower(q.Product)):
+		return false
+	case q.MinPrice != nil && price < *q.MinPrice, q.MaxPrice != nil && price > *q.MaxPrice:
+		return false
+	case q.MinQuantity != nil && inv.Quantity < *q.MinQuantity, q.MaxQuantity != nil && inv.Quantity > *q.MaxQuantity:
+		return false
+	}
+	return true
+}
+
+// orders two invoices by the query's sort
+func (q ListQuery) compare(a, b *Invoice) int {
+	for _, key := range q.Sort {
+		var c int
+		switch key.Field {
+		case "id":
+			c = cmp.Compare(a.ID, b.ID)
+		case "price":
+			c = cmp.Compare(Cents(a.Price), Cents(b.Price))
+		case "quantity":
+			c = cmp.Compare(a.Quantity, b.Quantity)
+		case "product":
+			c = strings.Compare(a.Product, b.Product)
+		case "category":
+			c = strings.Compare(a.Category, b.Category)
+		}
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// the invoice a cursor points at, for comparing against
+func (c *Cursor) invoice() *Invoice {
+	return &Invoice{
+		ID:       c.ID,
+		Price:    float32(c.Price) / 100,
+		Quantity: c.Quantity,
+		Product:  c.Product,
+		Category: c.Category,
+	}
+}
+
+// builds a where clause whose values are all passed as parameters
+type sqlWhere struct {
+	conds []string
+	args  []any
+}
+
+// adds val to the parameters and returns its placeholder
+func (w *sqlWhere) arg(val any) string {
+	w.args = append(w.args, val)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+func (w *sqlWhere) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// escapes the characters ILIKE treats as wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// the filters of the query, userID limits them to a user's invoices unless it's 0
+func (q ListQuery) filters(userID int) *sqlWhere {
+	w := &sqlWhere{}
+	if userID != 0 {
+		w.conds = append(w.conds, "user_id = "+w.arg(userID))
+	}
+	if q.Category != "" {
+		w.conds = append(w.conds, "lower(category) = lower("+w.arg(q.Category)+")")
+	}
+	if q.Product != "" {
+		w.conds = append(w.conds, `product ILIKE '%' || `+w.arg(likeEscaper.Replace(q.Product))+` || '%'`)
+	}
+	if q.MinPrice != nil {
+		w.conds = append(w.conds, "price >= "+w.arg(*q.MinPrice)+"::bigint / 100.0")
+	}
+	if q.MaxPrice != nil {
+		w.conds = append(w.conds, "price <= "+w.arg(*q.MaxPrice)+"::bigint / 100.0")
+	}
+	if q.MinQuantity != nil {
+		w.conds = append(w.conds, "quantity >= "+w.arg(*q.MinQuantity))
+	}
+	if q.MaxQuantity != nil {
+		w.conds = append(w.conds, "quantity <= "+w.arg(*q.MaxQuantity))
+	}
+	return w
+}
+
+// adds the condition that keeps only the invoices sorted after the cursor.
+// for a sort of a, -b, id it's
+// a > $1 OR (a = $1 AND b < $2) OR (a = $1 AND b = $2 AND id > $3)
+func (q ListQuery) seek(w *sqlWhere) {
+	if q.After == nil {
+		return
+	}
+	vals := make([]string, len(q.Sort))
+	for i, key := range q.Sort {
+		switch key.Field {
+		case "id":
+			vals[i] = w.arg(q.After.ID)
+		case "price":
+			vals[i] = w.arg(q.After.Price) + "::bigint / 100.0"
+		case "quantity":
+			vals[i] = w.arg(q.After.Quantity)
+		case "product":
+			vals[i] = w.arg(q.After.Product) + `::text COLLATE "C"`
+		case "category":
+			vals[i] = w.arg(q.After.Category) + `::text COLLATE "C"`
+		}
+	}
+
+	var alts []string
+	for i, key := range q.Sort {
+		var terms []string
+		for j := range i {
+			terms = append(terms, sortColumns[q.Sort[j].Field]+" = "+vals[j])
+		}
+		op := " > "
+		if key.Desc {
+			op = " < "
+		}
+		terms = append(terms, sortColumns[key.Field]+op+vals[i])
+		alts = append(alts, "("+strings.Join(terms, " AND ")+")")
+	}
+	w.conds = append(w.conds, "("+strings.Join(alts, " OR ")+")")
+}
+
+// the order by clause for the query's sort
+func (q ListQuery) orderBy() string {
+	cols := make([]string, len(q.Sort))
+	for i, key := range q.Sort {
+		cols[i] = sortColumns[key.Field]
+		if key.Desc {
+			cols[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(cols, ", ")
+}
+
+// cuts the extra invoice fetched to tell whether there's another page
+// and sets the cursor that fetches it
+func (q ListQuery) finish(page *Page) {
+	if len(page.Invoices) > q.Limit {
+		page.Invoices = page.Invoices[:q.Limit]
+		page.Next = encodeCursor(q.Sort, page.Invoices[q.Limit-1])
+	}
+	if page.Invoices == nil {
+		page.Invoices = []*Invoice{}
+	}
+}
+
+// returns a page of the invoices that match the query
+// userID limits them to a user's invoices, 0 lists every user's
+func (pg *Postgres) ListInvoices(ctx context.Context, userID int, q ListQuery) (*Page, fields.GrammarError) {
+	db := pg.store.Pool
+
+	var page Page
+	fieldErr := fields.GrammarError{}
+	w := q.filters(userID)
+	err := db.QueryRow(ctx, `SELECT count(*) FROM invoices`+w.String(), w.args...).Scan(&page.Total)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+
+	q.seek(w)
+	sql := `SELECT * FROM invoices` + w.String() + q.orderBy() + ` LIMIT ` + w.arg(q.Limit+1)
+	rows, _ := db.Query(ctx, sql, w.args...)
+	err = pgxscan.ScanAll(&page.Invoices, rows)
+	if fieldErr.AddCtxErr(ctx, err) {
+		return nil, fieldErr
+	}
+	if err != nil {
+		fieldErr.AddDBErr(err)
+		return nil, fieldErr
+	}
+
+	q.finish(&page)
+	return &page, fieldErr
+}
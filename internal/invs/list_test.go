@@ -0,0 +1,157 @@
+package invs
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseListQuery(t *testing.T) {
+	q, fieldErr := ParseListQuery(url.Values{})
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if q.Limit != DefaultLimit || sortString(q.Sort) != "id" {
+		t.Errorf("defaults: got limit %d and sort %q", q.Limit, sortString(q.Sort))
+	}
+
+	q, fieldErr = ParseListQuery(url.Values{"sort": {"price,-quantity"}, "min_price": {"9.99"}, "max_quantity": {"4"}})
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if got := sortString(q.Sort); got != "price,-quantity,id" {
+		t.Errorf("sort: got %q, want price,-quantity,id", got)
+	}
+	if *q.MinPrice != 999 || *q.MaxQuantity != 4 {
+		t.Errorf("bounds: got min_price %d and max_quantity %d", *q.MinPrice, *q.MaxQuantity)
+	}
+
+	tests := []struct {
+		values url.Values
+		field  string
+	}{
+		{url.Values{"limit": {"0"}}, "limit"},
+		{url.Values{"limit": {"201"}}, "limit"},
+		{url.Values{"sort": {"user_id"}}, "sort"},
+		{url.Values{"sort": {"price,-price"}}, "sort"},
+		{url.Values{"after": {"not a cursor"}}, "after"},
+		{url.Values{"min_price": {"-1"}}, "min_price"},
+		{url.Values{"min_price": {"5"}, "max_price": {"4"}}, "min_price"},
+		{url.Values{"max_quantity": {"lots"}}, "max_quantity"},
+	}
+	for _, tc := range tests {
+		_, fieldErr := ParseListQuery(tc.values)
+		if len(fieldErr.Violations) != 1 || fieldErr.Violations[0].Field != tc.field {
+			t.Errorf("%v: got %+v, want one violation for %s", tc.values, fieldErr.Violations, tc.field)
+		}
+	}
+}
+
+func TestMemoryPagesThroughEverySort(t *testing.T) {
+	mem, _, userID := newTestMemory(t)
+	ctx := context.Background()
+	// ties on every field but the id, so the pages only line up if the cursor breaks them
+	for _, inv := range []Invoice{
+		{Product: "Tire", Category: "Parts", Price: 20, Quantity: 2},
+		{Product: "Bell", Category: "Accessories", Price: 5.5, Quantity: 1},
+		{Product: "Tire", Category: "Parts", Price: 20, Quantity: 2},
+		{Product: "Chain", Category: "Parts", Price: 15.25, Quantity: 3},
+		{Product: "Bell", Category: "Accessories", Price: 5.5, Quantity: 4},
+	} {
+		inv.UserID = userID
+		if _, fieldErr := mem.InsertOp(ctx, inv); fieldErr.ErrMsgs != nil {
+			t.Fatal(fieldErr.ErrMsgs)
+		}
+	}
+
+	for _, sort := range []string{"", "-id", "price", "-price,quantity", "product,-id", "category,-quantity"} {
+		all, fieldErr := ParseListQuery(url.Values{"sort": {sort}, "limit": {"50"}})
+		if fieldErr.ErrMsgs != nil {
+			t.Fatal(fieldErr.ErrMsgs)
+		}
+		want, _ := mem.ListInvoices(ctx, userID, all)
+
+		var got []int
+		after := ""
+		for pages := 0; pages < 10; pages++ {
+			q, fieldErr := ParseListQuery(url.Values{"sort": {sort}, "limit": {"2"}, "after": {after}})
+			if fieldErr.ErrMsgs != nil {
+				t.Fatalf("sort %q: %v", sort, fieldErr.ErrMsgs)
+			}
+			page, _ := mem.ListInvoices(ctx, userID, q)
+			if page.Total != 5 {
+				t.Errorf("sort %q: total is %d, want 5", sort, page.Total)
+			}
+			for _, inv := range page.Invoices {
+				got = append(got, inv.ID)
+			}
+			if after = page.Next; after == "" {
+				break
+			}
+		}
+
+		var wantIDs []int
+		for _, inv := range want.Invoices {
+			wantIDs = append(wantIDs, inv.ID)
+		}
+		if !slices.Equal(got, wantIDs) {
+			t.Errorf("sort %q: pages gave %v, want %v", sort, got, wantIDs)
+		}
+	}
+}
+
+func TestMemoryFiltersInvoices(t *testing.T) {
+	mem, _, userID := newTestMemory(t)
+	ctx := context.Background()
+	for _, inv := range []Invoice{
+		{Product: "Road Tire", Category: "Tires", Price: 42, Quantity: 2},
+		{Product: "Inner Tube", Category: "Tires", Price: 7.49, Quantity: 2},
+		{Product: "Bike Lock", Category: "Security", Price: 34.95, Quantity: 1},
+	} {
+		inv.UserID = userID
+		mem.InsertOp(ctx, inv)
+	}
+
+	q, _ := ParseListQuery(url.Values{"category": {"TIRES"}, "product": {"tire"}, "min_price": {"10"}})
+	page, fieldErr := mem.ListInvoices(ctx, userID, q)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if page.Total != 1 || len(page.Invoices) != 1 || page.Invoices[0].Product != "Road Tire" {
+		t.Errorf("got %+v", page)
+	}
+
+	page, _ = mem.ListInvoices(ctx, userID+1, ListQuery{Limit: DefaultLimit, Sort: []SortKey{{Field: "id"}}})
+	if page.Total != 0 || page.Invoices == nil {
+		t.Errorf("another user's list: got %+v, want an empty page", page)
+	}
+}
+
+func TestListSQLIsParameterized(t *testing.T) {
+	values := url.Values{"sort": {"price,-product"}, "product": {"50%_off'; DROP TABLE invoices; --"}, "min_quantity": {"2"}}
+	q, fieldErr := ParseListQuery(values)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	q.After = &Cursor{Sort: "price,-product,id", ID: 7, Price: 1999, Product: "O'Brien"}
+
+	w := q.filters(4)
+	q.seek(w)
+	sql := w.String() + q.orderBy()
+	if strings.Contains(sql, "DROP") || strings.Contains(sql, "Brien") {
+		t.Fatalf("values ended up in the sql: %s", sql)
+	}
+	want := ` WHERE user_id = $1 AND product ILIKE '%' || $2 || '%' AND quantity >= $3 AND ` +
+		`((price > $4::bigint / 100.0) OR ` +
+		`(price = $4::bigint / 100.0 AND product COLLATE "C" < $5::text COLLATE "C") OR ` +
+		`(price = $4::bigint / 100.0 AND product COLLATE "C" = $5::text COLLATE "C" AND id > $6))` +
+		` ORDER BY price, product COLLATE "C" DESC, id`
+	if sql != want {
+		t.Errorf("got  %s\nwant %s", sql, want)
+	}
+	if got := w.args[1]; got != `50\%\_off'; DROP TABLE invoices; --` {
+		t.Errorf("product pattern is %q, its wildcards should be escaped", got)
+	}
+}
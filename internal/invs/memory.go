@@ -0,0 +1,234 @@
+package invs
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/fields"
+)
+
+// an InvoiceStore that keeps invoices in memory, owned by the accounts
+// of an accts.Memory. it answers with the same errors Postgres does and
+// is safe for concurrent use. like the database, deleting an account
+// deletes its invoices
+type Memory struct {
+	accounts *accts.Memory
+	mu       sync.Mutex
+	lastID   int
+	invoices map[int]*Invoice // by id
+}
+
+var _ InvoiceStore = (*Memory)(nil)
+
+// returns an empty in-memory store for the invoices of accounts
+func NewMemory(accounts *accts.Memory) *Memory {
+	mem := &Memory{accounts: accounts, invoices: make(map[int]*Invoice)}
+	accounts.OnDelete(mem.deleteUser)
+	return mem
+}
+
+// drops the invoices of an account that was deleted
+func (mem *Memory) deleteUser(userID int) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	for id, inv := range mem.invoices {
+		if inv.UserID == userID {
+			delete(mem.invoices, id)
+		}
+	}
+}
+
+// rounds the price to the cents the numeric(5,2) price column keeps
+func roundPrice(price float32) float32 {
+	return float32(math.Round(float64(price)*100) / 100)
+}
+
+// returns the user's invoice, or nil when they don't have one with invID
+// the caller must hold mu
+func (mem *Memory) find(userID, invID int) *Invoice {
+	inv, ok := mem.invoices[invID]
+	if !ok || inv.UserID != userID {
+		return nil
+	}
+	return inv
+}
+
+// returns copies of the invoices keep is true for, ordered by id
+// the caller must hold mu
+func (mem *Memory) filter(keep func(inv *Invoice) bool) []*Invoice {
+	var invoices []*Invoice
+	for _, inv := range mem.invoices {
+		if keep(inv) {
+			copied := *inv
+			invoices = append(invoices, &copied)
+		}
+	}
+	slices.SortFunc(invoices, func(a, b *Invoice) int { return a.ID - b.ID })
+	return invoices
+}
+
+func (mem *Memory) InsertOp(ctx context.Context, inv Invoice) ([]*Invoice, fields.GrammarError) {
+	fieldErr := inv.validateInvFields()
+	if len(fieldErr.ErrMsgs) > 0 || fieldErr.AddCtxErr(ctx, ctx.Err()) {
+		return nil, fieldErr
+	}
+
+	// the invoice has to belong to an account like its foreign key demands
+	var userErr fields.GrammarError
+	if mem.accounts.ReadUsernameByID(ctx, inv.UserID, &userErr); userErr.ErrMsgs != nil {
+		fieldErr.AddDBErr(fields.ErrMissingReference)
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	mem.lastID++
+	inv.ID = mem.lastID
+	inv.Price = roundPrice(inv.Price)
+	stored := inv
+	mem.invoices[inv.ID] = &stored
+	return []*Invoice{&inv}, fieldErr
+}
+
+func (mem *Memory) ReadInvoices(ctx context.Context) ([]*Invoice, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if fieldErr.AddCtxErr(ctx, ctx.Err()) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	return mem.filter(func(*Invoice) bool { return true }), fieldErr
+}
+
+func (mem *Memory) ReadInvoicesByUserID(ctx context.Context, id int) ([]*Invoice, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if fieldErr.AddCtxErr(ctx, ctx.Err()) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	invoices := mem.filter(func(inv *Invoice) bool { return inv.UserID == id })
+	if len(invoices) == 0 {
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: user with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	return invoices, fieldErr
+}
+
+func (mem *Memory) ListInvoices(ctx context.Context, userID int, q ListQuery) (*Page, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if fieldErr.AddCtxErr(ctx, ctx.Err()) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	matched := mem.filter(func(inv *Invoice) bool {
+		return (userID == 0 || inv.UserID == userID) && q.matches(inv)
+	})
+	slices.SortFunc(matched, q.compare)
+
+	page := Page{Total: len(matched)}
+	if q.After != nil {
+		after := q.After.invoice()
+		start, _ := slices.BinarySearchFunc(matched, after, q.compare)
+		for start < len(matched) && q.compare(matched[start], after) <= 0 {
+			start++
+		}
+		matched = matched[start:]
+	}
+	page.Invoices = matched[:min(len(matched), q.Limit+1)]
+	q.finish(&page)
+	return &page, fieldErr
+}
+
+func (mem *Memory) ReadInvoiceByUserID(ctx context.Context, userID, invID int) ([]*Invoice, fields.GrammarError) {
+	_, fieldErr := mem.accounts.ReadUserContactByID(ctx, userID)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	inv := mem.find(userID, invID)
+	if inv == nil {
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	copied := *inv
+	return []*Invoice{&copied}, fieldErr
+}
+
+func (mem *Memory) UpdateInvoiceByUserID(ctx context.Context, inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError) {
+	usrs, _ := mem.accounts.ReadUserContactByID(ctx, userID)
+	_, fieldErr := mem.ReadInvoiceByUserID(ctx, userID, invID)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+	fieldErr = inv.validateFieldsForUpdate(*usrs[0])
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	stored := mem.find(userID, invID)
+	if stored == nil {
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	stored.Product = inv.Product
+	stored.Category = inv.Category
+	stored.Price = roundPrice(inv.Price)
+	stored.Quantity = inv.Quantity
+	copied := *stored
+	return []*Invoice{&copied}, fieldErr
+}
+
+func (mem *Memory) PatchInvoice(ctx context.Context, inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError) {
+	fieldErr := fields.GrammarError{}
+	if fieldErr.AddCtxErr(ctx, ctx.Err()) {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	stored := mem.find(userID, invID)
+	if stored == nil {
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	fieldErr = validateFieldsForPatch(&inv, *stored)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	stored.Product = inv.Product
+	stored.Category = inv.Category
+	stored.Price = roundPrice(inv.Price)
+	stored.Quantity = inv.Quantity
+	copied := *stored
+	return []*Invoice{&copied}, fieldErr
+}
+
+func (mem *Memory) DeleteInvoice(ctx context.Context, invID, userID int) ([]*Invoice, fields.GrammarError) {
+	_, fieldErr := mem.accounts.ReadUserContactByID(ctx, userID)
+	if fieldErr.ErrMsgs != nil {
+		return nil, fieldErr
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+	inv := mem.find(userID, invID)
+	if inv == nil {
+		fieldErr.AddMsg(fields.ResourceNotFound, "", fields.CodeNotFound, "Resource Not Found: invoice with specified id doesn't exist")
+		return nil, fieldErr
+	}
+	delete(mem.invoices, invID)
+	return []*Invoice{inv}, fieldErr
+}
@@ -0,0 +1,83 @@
+package invs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/config"
+)
+
+// returns an invoice store with one registered account and that account's id
+func newTestMemory(t *testing.T) (*Memory, *accts.Memory, int) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.BcryptCost = 4
+	accounts, err := accts.NewMemory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acct := &accts.Account{Fname: "Johnny", Lname: "TwoTap", Address: "578 Bingus Ave", Username: "johnnytwotap", Password: "Sunflower7"}
+	registered, fieldErr := accounts.AddAccount(context.Background(), acct)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	return NewMemory(accounts), accounts, registered.UserID
+}
+
+func TestMemoryInsertsConcurrently(t *testing.T) {
+	mem, _, userID := newTestMemory(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inv := Invoice{UserID: userID, Product: "Peashooter", Category: "Toy", Price: 20, Quantity: 1}
+			if _, fieldErr := mem.InsertOp(ctx, inv); fieldErr.ErrMsgs != nil {
+				t.Error(fieldErr.ErrMsgs)
+			}
+		}()
+	}
+	wg.Wait()
+
+	invoices, _ := mem.ReadInvoicesByUserID(ctx, userID)
+	if len(invoices) != 20 {
+		t.Fatalf("got %d invoices, want 20", len(invoices))
+	}
+	for i, inv := range invoices {
+		if inv.ID != i+1 {
+			t.Errorf("invoice %d has id %d, want ids 1 to 20 in order", i, inv.ID)
+		}
+	}
+}
+
+func TestMemoryRejectsUnknownUser(t *testing.T) {
+	mem, _, userID := newTestMemory(t)
+	inv := Invoice{UserID: userID + 1, Product: "Peashooter", Category: "Toy", Price: 20, Quantity: 1}
+	if _, fieldErr := mem.InsertOp(context.Background(), inv); fieldErr.ErrMsgs == nil {
+		t.Error("an invoice was added for a user that doesn't exist")
+	}
+}
+
+func TestMemoryDeletesInvoicesWithAccount(t *testing.T) {
+	mem, accounts, userID := newTestMemory(t)
+	ctx := context.Background()
+	inv := Invoice{UserID: userID, Product: "Peashooter", Category: "Toy", Price: 19.999, Quantity: 1}
+	added, fieldErr := mem.InsertOp(ctx, inv)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if added[0].Price != 20 {
+		t.Errorf("price = %v, want it rounded to cents like numeric(5,2)", added[0].Price)
+	}
+
+	if _, fieldErr := accounts.DeleteAcct(ctx, accts.Usernames{ID: userID, Username: "johnnytwotap"}); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if invoices, _ := mem.ReadInvoices(ctx); len(invoices) != 0 {
+		t.Errorf("%d invoices outlived their account", len(invoices))
+	}
+}
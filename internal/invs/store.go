@@ -0,0 +1,34 @@
+package invs
+
+import (
+	"context"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/fields"
+)
+
+// everything the handlers do with invoices
+type InvoiceStore interface {
+	InsertOp(ctx context.Context, inv Invoice) ([]*Invoice, fields.GrammarError)
+	ReadInvoices(ctx context.Context) ([]*Invoice, fields.GrammarError)
+	ReadInvoicesByUserID(ctx context.Context, id int) ([]*Invoice, fields.GrammarError)
+	ListInvoices(ctx context.Context, userID int, q ListQuery) (*Page, fields.GrammarError)
+	ReadInvoiceByUserID(ctx context.Context, userID, invID int) ([]*Invoice, fields.GrammarError)
+	UpdateInvoiceByUserID(ctx context.Context, inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError)
+	PatchInvoice(ctx context.Context, inv Invoice, userID, invID int) ([]*Invoice, fields.GrammarError)
+	DeleteInvoice(ctx context.Context, invID, userID int) ([]*Invoice, fields.GrammarError)
+}
+
+// the InvoiceStore backed by the bikeshop database
+type Postgres struct {
+	store    *bikeshop.Store    // connection pool every query runs on
+	accounts accts.AccountStore // looks up the user an invoice belongs to
+}
+
+var _ InvoiceStore = (*Postgres)(nil)
+
+// returns a Postgres that runs its queries against the store
+func NewPostgres(s *bikeshop.Store, accounts accts.AccountStore) *Postgres {
+	return &Postgres{store: s, accounts: accounts}
+}
@@ -0,0 +1,186 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/config"
+)
+
+// the signing algs access tokens can use
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformed  = errors.New("jwt: token is malformed")
+	ErrUnknownKey = errors.New("jwt: token was signed with an unknown key")
+	ErrSignature  = errors.New("jwt: signature is invalid")
+	ErrExpired    = errors.New("jwt: token has expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+// the claims carried by an access token
+type Claims struct {
+	ID        string   `json:"jti"`
+	Subject   string   `json:"sub"`
+	UserID    int      `json:"uid"`
+	SessionID int      `json:"sid"`
+	Roles     []string `json:"roles,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// a decoded signing key
+type key struct {
+	id      string
+	alg     string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// signs tokens with the active key and verifies tokens signed
+// by any of its keys, so retired keys keep working until
+// the tokens they signed expire
+type Signer struct {
+	active *key
+	keys   map[string]*key
+}
+
+// builds a signer from the configured keys
+// activeID names the key new tokens are signed with
+func NewSigner(keys []config.SigningKey, activeID string) (*Signer, error) {
+	signer := &Signer{keys: make(map[string]*key, len(keys))}
+	for _, k := range keys {
+		raw, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q isn't valid base64: %w", k.ID, err)
+		}
+
+		decoded := &key{id: k.ID, alg: k.Alg}
+		switch k.Alg {
+		case HS256:
+			if len(raw) < 32 {
+				return nil, fmt.Errorf("jwt: key %q must be at least 32 bytes", k.ID)
+			}
+			decoded.secret = raw
+		case EdDSA:
+			if len(raw) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt: key %q must be a %d byte ed25519 seed", k.ID, ed25519.SeedSize)
+			}
+			decoded.private = ed25519.NewKeyFromSeed(raw)
+			decoded.public = decoded.private.Public().(ed25519.PublicKey)
+		default:
+			return nil, fmt.Errorf("jwt: key %q has unsupported alg %q", k.ID, k.Alg)
+		}
+
+		if _, ok := signer.keys[k.ID]; ok {
+			return nil, fmt.Errorf("jwt: key id %q is used more than once", k.ID)
+		}
+		signer.keys[k.ID] = decoded
+	}
+
+	signer.active = signer.keys[activeID]
+	if signer.active == nil {
+		return nil, fmt.Errorf("jwt: active key %q isn't one of the signing keys", activeID)
+	}
+	return signer, nil
+}
+
+// returns the signed token for the claims
+func (signer *Signer) Sign(claims Claims) (string, error) {
+	hdr, err := json.Marshal(header{Alg: signer.active.alg, Typ: "JWT", Kid: signer.active.id})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := encoding.EncodeToString(hdr) + "." + encoding.EncodeToString(body)
+	return signed + "." + encoding.EncodeToString(signer.active.sign([]byte(signed))), nil
+}
+
+// checks the token's signature and expiry and returns its claims.
+// an expired token returns its claims along with ErrExpired
+func (signer *Signer) Verify(token string, now time.Time) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrMalformed
+	}
+
+	var hdr header
+	if err := decodePart(parts[0], &hdr); err != nil {
+		return claims, err
+	}
+	k := signer.keys[hdr.Kid]
+	if k == nil {
+		return claims, ErrUnknownKey
+	}
+	// the key decides the alg, never the token, so a token
+	// can't ask for a weaker check like "none"
+	if hdr.Alg != k.alg {
+		return claims, ErrSignature
+	}
+
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrMalformed
+	}
+	if !k.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return claims, ErrSignature
+	}
+
+	if err := decodePart(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, ErrExpired
+	}
+	return claims, nil
+}
+
+func decodePart(part string, v any) error {
+	data, err := encoding.DecodeString(part)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (k *key) sign(msg []byte) []byte {
+	if k.alg == EdDSA {
+		return ed25519.Sign(k.private, msg)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+func (k *key) verify(msg, sig []byte) bool {
+	if k.alg == EdDSA {
+		return ed25519.Verify(k.public, msg, sig)
+	}
+	return hmac.Equal(k.sign(msg), sig)
+}
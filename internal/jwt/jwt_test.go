@@ -0,0 +1,108 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/config"
+)
+
+var (
+	hmacKey = config.SigningKey{ID: "h1", Alg: HS256, Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", 32)))}
+	edKey   = config.SigningKey{ID: "e1", Alg: EdDSA, Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("e", 32)))}
+)
+
+func newSigner(t *testing.T, activeID string) *Signer {
+	t.Helper()
+	signer, err := NewSigner([]config.SigningKey{hmacKey, edKey}, activeID)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	return signer
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+	for _, kid := range []string{"h1", "e1"} {
+		signer := newSigner(t, kid)
+		want := Claims{ID: "abc", UserID: 7, SessionID: 3, Roles: []string{"customer"}, ExpiresAt: now.Add(time.Minute).Unix()}
+		token, err := signer.Sign(want)
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", kid, err)
+		}
+
+		got, err := signer.Verify(token, now)
+		if err != nil {
+			t.Fatalf("%s: Verify: %v", kid, err)
+		}
+		if got.ID != want.ID || got.UserID != want.UserID || got.SessionID != want.SessionID || len(got.Roles) != 1 {
+			t.Errorf("%s: got claims %+v, want %+v", kid, got, want)
+		}
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	now := time.Now()
+	token, _ := newSigner(t, "h1").Sign(Claims{UserID: 1, ExpiresAt: now.Add(time.Minute).Unix()})
+
+	// e1 now signs new tokens but h1 is still listed
+	if _, err := newSigner(t, "e1").Verify(token, now); err != nil {
+		t.Errorf("token signed by a retired key should verify: %v", err)
+	}
+
+	retired, err := NewSigner([]config.SigningKey{edKey}, "e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Verify(token, now); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want ErrUnknownKey once h1 is removed", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	signer := newSigner(t, "h1")
+	token, _ := signer.Sign(Claims{UserID: 1, ExpiresAt: now.Add(time.Minute).Unix()})
+	parts := strings.Split(token, ".")
+
+	forged, _ := signer.Sign(Claims{UserID: 2, ExpiresAt: now.Add(time.Minute).Unix()})
+	none := encoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"h1"}`))
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"malformed", "not-a-token", ErrMalformed},
+		{"swapped claims", parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2], ErrSignature},
+		{"alg none", none + "." + parts[1] + ".", ErrSignature},
+		{"expired", token, ErrExpired},
+	}
+	for _, tt := range tests {
+		at := now
+		if tt.name == "expired" {
+			at = now.Add(time.Hour)
+		}
+		if _, err := signer.Verify(tt.token, at); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestNewSignerRejectsBadKeys(t *testing.T) {
+	short := config.SigningKey{ID: "s", Alg: HS256, Key: base64.StdEncoding.EncodeToString([]byte("short"))}
+	unknown := config.SigningKey{ID: "u", Alg: "RS256", Key: hmacKey.Key}
+
+	if _, err := NewSigner([]config.SigningKey{short}, "s"); err == nil {
+		t.Error("expected an error for a short hmac key")
+	}
+	if _, err := NewSigner([]config.SigningKey{unknown}, "u"); err == nil {
+		t.Error("expected an error for an unsupported alg")
+	}
+	if _, err := NewSigner([]config.SigningKey{hmacKey}, "missing"); err == nil {
+		t.Error("expected an error when the active key isn't listed")
+	}
+}
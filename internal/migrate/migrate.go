@@ -0,0 +1,241 @@
+package migrate
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+)
+
+// the schema's migrations, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, applied in order of their version
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// the pg_advisory_xact_lock key held for the length of each migration's
+// transaction, so two instances starting at once don't both apply it
+const lockID = 7_265_646
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// a change to the schema and the sql that undoes it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// a migration and when it was applied, AppliedAt is nil while it's pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// returns the migrations embedded in the binary, ordered by version
+func Migrations() ([]Migration, error) {
+	return load(files)
+}
+
+// reads the migrations in the sql directory of fsys
+// every version needs both an up and a down file, and the
+// versions have to count up from 1 without skipping any
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrate: %s isn't named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		sql, err := fs.ReadFile(fsys, path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i, m := range migrations {
+		switch {
+		case m.Version != i+1:
+			return nil, fmt.Errorf("migrate: expected version %d but found %d", i+1, m.Version)
+		case m.Up == "":
+			return nil, fmt.Errorf("migrate: version %d has no up file", m.Version)
+		case m.Down == "":
+			return nil, fmt.Errorf("migrate: version %d has no down file", m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// creates the table that records which migrations were applied
+func ensureTable(ctx context.Context, q bikeshop.Querier) error {
+	_, err := q.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name character varying(255) NOT NULL,
+		applied_at timestamp with time zone DEFAULT now() NOT NULL
+	)`)
+	return err
+}
+
+// returns when each applied migration was applied, keyed by version
+func applied(ctx context.Context, q bikeshop.Querier) (map[int]time.Time, error) {
+	rows, err := q.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// takes the migration lock and returns the versions applied so far
+// the caller's transaction holds the lock until it ends
+func lock(ctx context.Context, q bikeshop.Querier) (map[int]time.Time, error) {
+	if _, err := q.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return nil, err
+	}
+	if err := ensureTable(ctx, q); err != nil {
+		return nil, err
+	}
+	return applied(ctx, q)
+}
+
+// applies every pending migration in order, each in its own transaction,
+// and returns the ones it applied. it stops at the first one that fails
+func Up(ctx context.Context, s *bikeshop.Store) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range migrations {
+		ran := false
+		err := s.InTx(ctx, func(q bikeshop.Querier) error {
+			// checked under the lock, another instance may have just applied it
+			versions, err := lock(ctx, q)
+			if err != nil {
+				return err
+			}
+			if _, ok := versions[m.Version]; ok {
+				return nil
+			}
+
+			if _, err := q.Exec(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err = q.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			ran = err == nil
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate: applying %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// ErrNothingApplied is returned by Down when there's no migration to undo
+var ErrNothingApplied = errors.New("migrate: no migrations have been applied")
+
+// undoes the most recently applied migration and returns it
+func Down(ctx context.Context, s *bikeshop.Store) (Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return Migration{}, err
+	}
+	var undone Migration
+	err = s.InTx(ctx, func(q bikeshop.Querier) error {
+		versions, err := lock(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		latest := 0
+		for version := range versions {
+			latest = max(latest, version)
+		}
+		if latest == 0 {
+			return ErrNothingApplied
+		}
+		if latest > len(migrations) {
+			return fmt.Errorf("migrate: version %d was applied by a newer build and can't be undone by this one", latest)
+		}
+
+		undone = migrations[latest-1]
+		if _, err := q.Exec(ctx, undone.Down); err != nil {
+			return fmt.Errorf("migrate: undoing %04d_%s: %w", undone.Version, undone.Name, err)
+		}
+		_, err = q.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, undone.Version)
+		return err
+	})
+	return undone, err
+}
+
+// reports every migration along with when it was applied
+func Statuses(ctx context.Context, s *bikeshop.Store) ([]Status, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureTable(ctx, s.Pool); err != nil {
+		return nil, fmt.Errorf("migrate: creating schema_migrations: %w", err)
+	}
+	versions, err := applied(ctx, s.Pool)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: reading schema_migrations: %w", err)
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, m := range migrations {
+		statuses[i].Migration = m
+		if appliedAt, ok := versions[m.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
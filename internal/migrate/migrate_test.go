@@ -0,0 +1,185 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/config"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i, m.Version)
+		}
+		if strings.Contains(m.Up, "<username>") || strings.Contains(m.Down, "<username>") {
+			t.Errorf("%04d_%s still has a <username> placeholder", m.Version, m.Name)
+		}
+	}
+	if first := migrations[0]; first.Name != "create_accounts_and_invoices" {
+		t.Errorf("first migration is %s, want create_accounts_and_invoices", first.Name)
+	}
+}
+
+func TestLoadRejectsBadDirectories(t *testing.T) {
+	file := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"missing down", fstest.MapFS{
+			"sql/0001_users.up.sql": file("CREATE TABLE users ();"),
+		}, "no down file"},
+		{"skipped version", fstest.MapFS{
+			"sql/0001_users.up.sql":   file("CREATE TABLE users ();"),
+			"sql/0001_users.down.sql": file("DROP TABLE users;"),
+			"sql/0003_keys.up.sql":    file("CREATE TABLE keys ();"),
+			"sql/0003_keys.down.sql":  file("DROP TABLE keys;"),
+		}, "expected version 2"},
+		{"bad name", fstest.MapFS{
+			"sql/users.sql": file("CREATE TABLE users ();"),
+		}, "isn't named"},
+		{"mismatched names", fstest.MapFS{
+			"sql/0001_users.up.sql":    file("CREATE TABLE users ();"),
+			"sql/0001_people.down.sql": file("DROP TABLE users;"),
+		}, "named both"},
+	}
+	for _, tc := range tests {
+		_, err := load(tc.fsys)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error about %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// returns a store on the database CONCH_TEST_DATABASE_URL names, with its
+// public schema emptied first. the test is skipped when it isn't set
+func testStore(t *testing.T) *bikeshop.Store {
+	t.Helper()
+	url := os.Getenv("CONCH_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("CONCH_TEST_DATABASE_URL isn't set")
+	}
+	cfg := config.Default()
+	cfg.DatabaseURL = url
+	s, err := bikeshop.Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	if _, err := s.Pool.Exec(context.Background(), `DROP SCHEMA public CASCADE; CREATE SCHEMA public`); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// returns the tables in the public schema besides schema_migrations
+func tables(t *testing.T, s *bikeshop.Store) []string {
+	t.Helper()
+	var names []string
+	rows, _ := s.Pool.Query(context.Background(),
+		`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = 'public' AND table_name <> 'schema_migrations' ORDER BY table_name`)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestUpDownUp(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := Up(ctx, s)
+	if err != nil || len(done) != len(migrations) {
+		t.Fatalf("first up: applied %d of %d: %v", len(done), len(migrations), err)
+	}
+	want := tables(t, s)
+
+	for i := len(migrations); i > 0; i-- {
+		undone, err := Down(ctx, s)
+		if err != nil {
+			t.Fatalf("down to version %d: %v", i-1, err)
+		}
+		if undone.Version != i {
+			t.Fatalf("down undid version %d, want %d", undone.Version, i)
+		}
+	}
+	if _, err := Down(ctx, s); !errors.Is(err, ErrNothingApplied) {
+		t.Fatalf("down with nothing applied: got %v, want ErrNothingApplied", err)
+	}
+	if left := tables(t, s); len(left) != 0 {
+		t.Fatalf("tables left after undoing every migration: %v", left)
+	}
+
+	done, err = Up(ctx, s)
+	if err != nil || len(done) != len(migrations) {
+		t.Fatalf("second up: applied %d of %d: %v", len(done), len(migrations), err)
+	}
+	if got := tables(t, s); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("second up made tables %v, the first made %v", got, want)
+	}
+}
+
+func TestUpUpgradesTheOriginalDump(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	dump, err := os.ReadFile("testdata/bikeshop.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Pool.Exec(ctx, string(dump)); err != nil {
+		t.Fatal(err)
+	}
+	// a user logged in with a raw token, like the original code left them
+	if _, err := s.Pool.Exec(ctx, `INSERT INTO usernames (username) VALUES ('johnnytwotap');
+		INSERT INTO tokens (user_id, token) VALUES (1, 'a0b1c2d3e4f5a0b1c2d3e4f5a0b1c2d3e4f5a0b1')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Up(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	var role string
+	if err := s.Pool.QueryRow(ctx, `SELECT role FROM usernames WHERE id = 1`).Scan(&role); err != nil || role != "customer" {
+		t.Errorf("the existing user has role %q (%v), want customer", role, err)
+	}
+	var sessions int
+	if err := s.Pool.QueryRow(ctx, `SELECT count(*) FROM tokens`).Scan(&sessions); err != nil || sessions != 0 {
+		t.Errorf("%d raw tokens (%v) are left, they can't be used once tokens are digests", sessions, err)
+	}
+	// two sessions for one user, which the dump's user_id_unique refused
+	for i, digest := range []string{strings.Repeat("a", 64), strings.Repeat("b", 64)} {
+		_, err := s.Pool.Exec(ctx,
+			`INSERT INTO tokens (user_id, token, expires_at, refresh_token, refresh_expires_at)
+			VALUES (1, $1, now(), $2, now())`, digest, strings.Repeat("c", i+1))
+		if err != nil {
+			t.Fatalf("session %d: %v", i+1, err)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS passwords;
+DROP TABLE IF EXISTS usercontacts;
+DROP TABLE IF EXISTS usernames;
@@ -0,0 +1,56 @@
+-- the tables of the original bikeshop.sql dump, exactly as it made them.
+-- IF NOT EXISTS lets a database loaded from the dump adopt the migrations,
+-- the ones after this bring it up to date
+CREATE TABLE IF NOT EXISTS usernames (
+    id serial,
+    username character varying(255) NOT NULL,
+    CONSTRAINT usernames_pkey PRIMARY KEY (id),
+    CONSTRAINT usernames_username_key UNIQUE (username)
+);
+
+CREATE TABLE IF NOT EXISTS usercontacts (
+    id serial,
+    user_id integer NOT NULL,
+    fname character varying(80) NOT NULL,
+    lname character varying(80) NOT NULL,
+    address character varying(80) NOT NULL,
+    CONSTRAINT usercontacts_pkey PRIMARY KEY (id),
+    CONSTRAINT usercontacts_fname_lname_address_key UNIQUE (fname, lname, address),
+    CONSTRAINT usercontacts_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS passwords (
+    id serial,
+    user_id integer NOT NULL,
+    password bytea NOT NULL,
+    CONSTRAINT passwords_pkey PRIMARY KEY (id),
+    CONSTRAINT passwords_password_key UNIQUE (password),
+    CONSTRAINT passwords_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id serial,
+    user_id integer NOT NULL,
+    product character varying(80) NOT NULL,
+    category character varying(80) NOT NULL,
+    price numeric(5,2) NOT NULL,
+    quantity integer NOT NULL,
+    CONSTRAINT invoices_pkey PRIMARY KEY (id),
+    CONSTRAINT invoices_id_user_id_product_category_price_quantity_key
+        UNIQUE (id, user_id, product, category, price, quantity),
+    CONSTRAINT invoices_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- one raw token per user
+CREATE TABLE IF NOT EXISTS tokens (
+    id serial,
+    user_id integer NOT NULL,
+    token character varying(80),
+    CONSTRAINT tokens_pkey PRIMARY KEY (id),
+    CONSTRAINT user_id_unique UNIQUE (user_id),
+    CONSTRAINT tokens_fk FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
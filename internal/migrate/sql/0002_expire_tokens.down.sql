@@ -0,0 +1,6 @@
+ALTER TABLE tokens
+    DROP CONSTRAINT IF EXISTS tokens_refresh_token_key,
+    DROP COLUMN IF EXISTS refresh_expires_at,
+    DROP COLUMN IF EXISTS refresh_token,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS issued_at;
@@ -0,0 +1,17 @@
+-- access tokens expire and come with a refresh token to trade for a new pair.
+-- a token from before there were expiries can't be refreshed, so it's
+-- dropped and its user logs in again
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS issued_at timestamp with time zone DEFAULT now() NOT NULL,
+    ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS refresh_token character varying(80),
+    ADD COLUMN IF NOT EXISTS refresh_expires_at timestamp with time zone;
+
+DELETE FROM tokens WHERE expires_at IS NULL OR refresh_token IS NULL OR refresh_expires_at IS NULL;
+
+ALTER TABLE tokens
+    ALTER COLUMN expires_at SET NOT NULL,
+    ALTER COLUMN refresh_token SET NOT NULL,
+    ALTER COLUMN refresh_expires_at SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS tokens_refresh_token_key,
+    ADD CONSTRAINT tokens_refresh_token_key UNIQUE (refresh_token);
@@ -0,0 +1,9 @@
+-- only each user's newest session fits back under one token per user
+DELETE FROM tokens t USING tokens newer WHERE newer.user_id = t.user_id AND newer.id > t.id;
+
+DROP INDEX IF EXISTS tokens_user_id_idx;
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS ip_address,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS last_used_at,
+    ADD CONSTRAINT user_id_unique UNIQUE (user_id);
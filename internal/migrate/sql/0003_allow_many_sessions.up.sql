@@ -0,0 +1,9 @@
+-- a user can be logged in on many devices, each session records
+-- the device it was started on and when it was last used
+ALTER TABLE tokens
+    DROP CONSTRAINT IF EXISTS user_id_unique,
+    ADD COLUMN IF NOT EXISTS last_used_at timestamp with time zone DEFAULT now() NOT NULL,
+    ADD COLUMN IF NOT EXISTS user_agent character varying(255) DEFAULT '' NOT NULL,
+    ADD COLUMN IF NOT EXISTS ip_address character varying(45) DEFAULT '' NOT NULL;
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE tokens
+    DROP CONSTRAINT IF EXISTS tokens_token_key,
+    ALTER COLUMN token DROP NOT NULL;
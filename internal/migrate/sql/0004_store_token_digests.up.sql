@@ -0,0 +1,8 @@
+-- tokens are stored as their hex sha-256 digests. a raw token from before
+-- is shorter than a digest and would never match one, so it's dropped
+DELETE FROM tokens WHERE token IS NULL OR length(token) <> 64;
+
+ALTER TABLE tokens
+    ALTER COLUMN token SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS tokens_token_key,
+    ADD CONSTRAINT tokens_token_key UNIQUE (token);
@@ -0,0 +1,6 @@
+-- jwt access tokens that were logged out before they expired
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token character varying(80) NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (token)
+);
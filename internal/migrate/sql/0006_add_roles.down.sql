@@ -0,0 +1,3 @@
+ALTER TABLE usernames
+    DROP CONSTRAINT IF EXISTS usernames_role_check,
+    DROP COLUMN IF EXISTS role;
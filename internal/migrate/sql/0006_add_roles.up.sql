@@ -0,0 +1,5 @@
+-- every user is a customer, staff or an admin. existing users are customers
+ALTER TABLE usernames
+    ADD COLUMN IF NOT EXISTS role character varying(16) DEFAULT 'customer' NOT NULL,
+    DROP CONSTRAINT IF EXISTS usernames_role_check,
+    ADD CONSTRAINT usernames_role_check CHECK (role IN ('customer', 'staff', 'admin'));
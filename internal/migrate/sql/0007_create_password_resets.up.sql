@@ -0,0 +1,15 @@
+-- single use tokens for resetting a forgotten password, stored as digests
+CREATE TABLE IF NOT EXISTS password_resets (
+    id serial,
+    user_id integer NOT NULL,
+    token character varying(80) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    CONSTRAINT password_resets_pkey PRIMARY KEY (id),
+    CONSTRAINT password_resets_token_key UNIQUE (token),
+    CONSTRAINT password_resets_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id);
@@ -0,0 +1,10 @@
+-- failed logins counted per username and per ip for backoff and lockouts
+CREATE TABLE IF NOT EXISTS login_attempts (
+    kind character varying(16) NOT NULL,
+    key character varying(255) NOT NULL,
+    failures integer DEFAULT 0 NOT NULL,
+    last_failure timestamp with time zone DEFAULT now() NOT NULL,
+    locked_until timestamp with time zone,
+    CONSTRAINT login_attempts_pkey PRIMARY KEY (kind, key),
+    CONSTRAINT login_attempts_kind_check CHECK (kind IN ('username', 'ip'))
+);
@@ -0,0 +1,23 @@
+-- totp secrets for two-factor login, confirmed_at is set once it's turned on
+CREATE TABLE IF NOT EXISTS totp_secrets (
+    user_id integer NOT NULL,
+    secret character varying(64) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    confirmed_at timestamp with time zone,
+    last_step bigint DEFAULT 0 NOT NULL,
+    CONSTRAINT totp_secrets_pkey PRIMARY KEY (user_id),
+    CONSTRAINT totp_secrets_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- digests of the single use codes for logging in without the app
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id serial,
+    user_id integer NOT NULL,
+    code character varying(80) NOT NULL,
+    used_at timestamp with time zone,
+    CONSTRAINT recovery_codes_pkey PRIMARY KEY (id),
+    CONSTRAINT recovery_codes_user_id_code_key UNIQUE (user_id, code),
+    CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+-- personal api keys, stored as digests along with a prefix to tell them apart
+CREATE TABLE IF NOT EXISTS api_keys (
+    id serial,
+    user_id integer NOT NULL,
+    name character varying(64) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key character varying(80) NOT NULL,
+    scopes character varying(32)[] NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    last_used_at timestamp with time zone,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_key_key UNIQUE (key),
+    CONSTRAINT api_keys_user_id_name_key UNIQUE (user_id, name),
+    CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id)
+        REFERENCES usernames(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
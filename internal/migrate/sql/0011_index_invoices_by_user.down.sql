@@ -0,0 +1 @@
+DROP INDEX IF EXISTS invoices_user_id_id_idx;
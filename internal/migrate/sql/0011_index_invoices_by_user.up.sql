@@ -0,0 +1,2 @@
+-- lets a user's invoices be paged through in id order without scanning the table
+CREATE INDEX IF NOT EXISTS invoices_user_id_id_idx ON invoices (user_id, id);
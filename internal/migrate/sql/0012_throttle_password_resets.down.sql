@@ -0,0 +1,4 @@
+DELETE FROM login_attempts WHERE kind IN ('reset_username', 'reset_ip');
+ALTER TABLE login_attempts DROP CONSTRAINT IF EXISTS login_attempts_kind_check;
+ALTER TABLE login_attempts ADD CONSTRAINT login_attempts_kind_check
+    CHECK (kind IN ('username', 'ip'));
@@ -0,0 +1,4 @@
+-- password reset requests are counted per username and per ip like failed logins
+ALTER TABLE login_attempts DROP CONSTRAINT IF EXISTS login_attempts_kind_check;
+ALTER TABLE login_attempts ADD CONSTRAINT login_attempts_kind_check
+    CHECK (kind IN ('username', 'ip', 'reset_username', 'reset_ip'));
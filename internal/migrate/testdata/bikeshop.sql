@@ -0,0 +1,136 @@
+-- the schema of the original bikeshop.sql dump, without its owner and data
+CREATE TABLE public.invoices (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    product character varying(80) NOT NULL,
+    category character varying(80) NOT NULL,
+    price numeric(5,2) NOT NULL,
+    quantity integer NOT NULL
+);
+
+CREATE SEQUENCE public.invoices_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+ALTER SEQUENCE public.invoices_id_seq OWNED BY public.invoices.id;
+
+CREATE TABLE public.passwords (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    password bytea NOT NULL
+);
+
+CREATE SEQUENCE public.passwords_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+ALTER SEQUENCE public.passwords_id_seq OWNED BY public.passwords.id;
+
+CREATE TABLE public.tokens (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    token character varying(80)
+);
+
+CREATE SEQUENCE public.tokens_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+ALTER SEQUENCE public.tokens_id_seq OWNED BY public.tokens.id;
+
+CREATE TABLE public.usercontacts (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    fname character varying(80) NOT NULL,
+    lname character varying(80) NOT NULL,
+    address character varying(80) NOT NULL
+);
+
+CREATE SEQUENCE public.usercontacts_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+ALTER SEQUENCE public.usercontacts_id_seq OWNED BY public.usercontacts.id;
+
+CREATE TABLE public.usernames (
+    id integer NOT NULL,
+    username character varying(255) NOT NULL
+);
+
+CREATE SEQUENCE public.usernames_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+ALTER SEQUENCE public.usernames_id_seq OWNED BY public.usernames.id;
+
+ALTER TABLE ONLY public.invoices ALTER COLUMN id SET DEFAULT nextval('public.invoices_id_seq'::regclass);
+
+ALTER TABLE ONLY public.passwords ALTER COLUMN id SET DEFAULT nextval('public.passwords_id_seq'::regclass);
+
+ALTER TABLE ONLY public.tokens ALTER COLUMN id SET DEFAULT nextval('public.tokens_id_seq'::regclass);
+
+ALTER TABLE ONLY public.usercontacts ALTER COLUMN id SET DEFAULT nextval('public.usercontacts_id_seq'::regclass);
+
+ALTER TABLE ONLY public.usernames ALTER COLUMN id SET DEFAULT nextval('public.usernames_id_seq'::regclass);
+
+ALTER TABLE ONLY public.invoices
+    ADD CONSTRAINT invoices_id_user_id_product_category_price_quantity_key UNIQUE (id, user_id, product, category, price, quantity);
+
+ALTER TABLE ONLY public.invoices
+    ADD CONSTRAINT invoices_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.passwords
+    ADD CONSTRAINT passwords_password_key UNIQUE (password);
+
+ALTER TABLE ONLY public.passwords
+    ADD CONSTRAINT passwords_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.tokens
+    ADD CONSTRAINT tokens_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.tokens
+    ADD CONSTRAINT user_id_unique UNIQUE (user_id);
+
+ALTER TABLE ONLY public.usercontacts
+    ADD CONSTRAINT usercontacts_fname_lname_address_key UNIQUE (fname, lname, address);
+
+ALTER TABLE ONLY public.usercontacts
+    ADD CONSTRAINT usercontacts_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.usernames
+    ADD CONSTRAINT usernames_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.usernames
+    ADD CONSTRAINT usernames_username_key UNIQUE (username);
+
+ALTER TABLE ONLY public.invoices
+    ADD CONSTRAINT invoices_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.usernames(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.passwords
+    ADD CONSTRAINT passwords_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.usernames(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.tokens
+    ADD CONSTRAINT tokens_fk FOREIGN KEY (user_id) REFERENCES public.usernames(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.usercontacts
+    ADD CONSTRAINT usercontacts_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.usernames(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// a message meant for a single user, like a password reset link
+type Message struct {
+	To      string // the username the message is for
+	Subject string
+	Body    string
+}
+
+// delivers messages to users
+// implementations must be safe for concurrent use
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// writes each message to a logger, meant for local development
+type LogNotifier struct {
+	Logger *log.Logger // log.Default() when nil
+}
+
+func (n LogNotifier) Notify(ctx context.Context, msg Message) error {
+	logger := n.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("notify: to=%s subject=%q body=%q", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// appends each message to a file, meant for local development
+// so messages can be read without a mail server
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	return nil
+}
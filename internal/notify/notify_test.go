@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := LogNotifier{Logger: log.New(&buf, "", 0)}
+	if err := n.Notify(context.Background(), Message{To: "bikerider", Subject: "Reset", Body: "code 123"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "to=bikerider") || !strings.Contains(buf.String(), "code 123") {
+		t.Errorf("log is missing the message: %q", buf.String())
+	}
+}
+
+// run with -race to check concurrent messages don't interleave
+func TestFileNotifierAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.txt")
+	n := &FileNotifier{Path: path}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.Notify(context.Background(), Message{To: "bikerider", Subject: "Reset", Body: "body"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "To: bikerider\nSubject: Reset\n\nbody\n"); got != 10 {
+		t.Errorf("found %d whole messages, want 10", got)
+	}
+}
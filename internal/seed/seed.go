@@ -0,0 +1,194 @@
+package seed
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/fields"
+	"github.com/ScriptMang/conch/internal/invs"
+	"gopkg.in/yaml.v3"
+)
+
+// the demo dataset loaded when conch seed isn't given a file
+//
+//go:embed demo.yaml
+var demo []byte
+
+// the accounts and invoices a fixture file holds
+type Fixture struct {
+	Users []User `json:"users" yaml:"users"`
+}
+
+// an account along with its contact info, password and invoices
+// an empty role leaves the account a customer
+type User struct {
+	Username string    `json:"username" yaml:"username"`
+	Password string    `json:"password" yaml:"password"`
+	Role     string    `json:"role" yaml:"role"`
+	Fname    string    `json:"fname" yaml:"fname"`
+	Lname    string    `json:"lname" yaml:"lname"`
+	Address  string    `json:"address" yaml:"address"`
+	Invoices []Invoice `json:"invoices" yaml:"invoices"`
+}
+
+type Invoice struct {
+	Product  string  `json:"product" yaml:"product"`
+	Category string  `json:"category" yaml:"category"`
+	Price    float32 `json:"price" yaml:"price"`
+	Quantity int     `json:"quantity" yaml:"quantity"`
+}
+
+// counts what a seed run added and what it found already there
+type Result struct {
+	UsersAdded    int
+	UsersFound    int
+	InvoicesAdded int
+	InvoicesFound int
+}
+
+// returns the built-in demo dataset
+func Demo() (*Fixture, error) {
+	return Parse(demo, "demo.yaml")
+}
+
+// reads a fixture from a .json, .yaml or .yml file
+func ReadFile(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("seed: %w", err)
+	}
+	return Parse(data, path)
+}
+
+// decodes a fixture, name's extension says whether it's json or yaml
+// fields the fixture types don't have are reported rather than ignored
+func Parse(data []byte, name string) (*Fixture, error) {
+	var fix Fixture
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&fix); err != nil {
+			return nil, fmt.Errorf("seed: %s: %w", name, err)
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(&fix); err != nil {
+			return nil, fmt.Errorf("seed: %s: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("seed: %s: fixtures have to be .json, .yaml or .yml files", name)
+	}
+	return &fix, nil
+}
+
+// turns the msgs of a failed store call into an error
+func storeErr(what string, fieldErr fields.GrammarError) error {
+	return fmt.Errorf("seed: %s: %s", what, strings.Join(fieldErr.ErrMsgs, "; "))
+}
+
+// adds the fixture's accounts and invoices through the stores, so they're
+// validated and hashed the same way the api does it. it can be run again
+// safely: accounts are matched by username and invoices by every field,
+// and only the ones that are missing are added
+func Load(ctx context.Context, accounts accts.AccountStore, invoices invs.InvoiceStore, fix *Fixture) (Result, error) {
+	var result Result
+	existing, fieldErr := accounts.ReadAccounts(ctx)
+	if fieldErr.ErrMsgs != nil {
+		return result, storeErr("reading accounts", fieldErr)
+	}
+	userIDs := make(map[string]int, len(existing))
+	roles := make(map[string]string, len(existing))
+	for _, acct := range existing {
+		userIDs[acct.Username] = acct.UserID
+		roles[acct.Username] = acct.Role
+	}
+
+	for _, user := range fix.Users {
+		userID, found := userIDs[user.Username]
+		if found {
+			result.UsersFound++
+		} else {
+			acct := accts.Account{
+				Fname:    user.Fname,
+				Lname:    user.Lname,
+				Address:  user.Address,
+				Username: user.Username,
+				Password: user.Password,
+			}
+			registered, fieldErr := accounts.AddAccount(ctx, &acct)
+			if fieldErr.ErrMsgs != nil {
+				return result, storeErr("adding user "+user.Username, fieldErr)
+			}
+			userID = registered.UserID
+			userIDs[user.Username] = userID
+			roles[user.Username] = accts.RoleCustomer // what new accounts start as
+			result.UsersAdded++
+		}
+
+		// users who already exist get the fixture's role too. it's only set
+		// when it differs since changing a role logs the user out
+		if user.Role != "" && user.Role != roles[user.Username] {
+			if _, fieldErr := accounts.SetRole(ctx, userID, user.Role); fieldErr.ErrMsgs != nil {
+				return result, storeErr("setting the role of "+user.Username, fieldErr)
+			}
+		}
+
+		if err := loadInvoices(ctx, invoices, userID, user, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// adds the user's invoices that they don't already have
+func loadInvoices(ctx context.Context, invoices invs.InvoiceStore, userID int, user User, result *Result) error {
+	if len(user.Invoices) == 0 {
+		return nil
+	}
+	have, fieldErr := invoices.ReadInvoicesByUserID(ctx, userID)
+	if fieldErr.ErrMsgs != nil && fieldErr.Status != http.StatusNotFound {
+		return storeErr("reading the invoices of "+user.Username, fieldErr)
+	}
+
+	for _, inv := range user.Invoices {
+		add := invs.Invoice{
+			UserID:   userID,
+			Product:  inv.Product,
+			Category: inv.Category,
+			Price:    inv.Price,
+			Quantity: inv.Quantity,
+		}
+		if hasInvoice(have, add) {
+			result.InvoicesFound++
+			continue
+		}
+		added, fieldErr := invoices.InsertOp(ctx, add)
+		if fieldErr.ErrMsgs != nil {
+			return storeErr(fmt.Sprintf("adding %s's invoice for %s", user.Username, inv.Product), fieldErr)
+		}
+		have = append(have, added...)
+		result.InvoicesAdded++
+	}
+	return nil
+}
+
+// reports whether one of the invoices matches inv in everything but its id
+// prices are compared in cents, the database keeps no more than that
+func hasInvoice(invoices []*invs.Invoice, inv invs.Invoice) bool {
+	for _, have := range invoices {
+		if have.Product == inv.Product && have.Category == inv.Category &&
+			have.Quantity == inv.Quantity && invs.Cents(have.Price) == invs.Cents(inv.Price) {
+			return true
+		}
+	}
+	return false
+}
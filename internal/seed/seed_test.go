@@ -0,0 +1,153 @@
+package seed
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/invs"
+)
+
+func newStores(t *testing.T) (*accts.Memory, *invs.Memory) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.BcryptCost = 4 // the cheapest cost bcrypt allows keeps the tests fast
+	accounts, err := accts.NewMemory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accounts, invs.NewMemory(accounts)
+}
+
+func TestDemoSeedsOnce(t *testing.T) {
+	fix, err := Demo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, invoices := newStores(t)
+	ctx := context.Background()
+
+	first, err := Load(ctx, accounts, invoices, fix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantInvoices := 0
+	for _, user := range fix.Users {
+		wantInvoices += len(user.Invoices)
+	}
+	if first.UsersAdded != len(fix.Users) || first.InvoicesAdded != wantInvoices {
+		t.Fatalf("first run: got %+v, want %d users and %d invoices added", first, len(fix.Users), wantInvoices)
+	}
+
+	second, err := Load(ctx, accounts, invoices, fix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{UsersFound: len(fix.Users), InvoicesFound: wantInvoices}
+	if second != want {
+		t.Fatalf("second run: got %+v, want %+v", second, want)
+	}
+
+	all, fieldErr := accounts.ReadAccounts(ctx)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	for _, acct := range all {
+		if acct.Username == "wrenchwendy" && acct.Role != accts.RoleStaff {
+			t.Errorf("wrenchwendy has role %q, want %q", acct.Role, accts.RoleStaff)
+		}
+	}
+}
+
+func TestLoadSetsTheRoleOfExistingUsers(t *testing.T) {
+	fix, err := Demo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, invoices := newStores(t)
+	ctx := context.Background()
+	if _, err := Load(ctx, accounts, invoices, fix); err != nil {
+		t.Fatal(err)
+	}
+
+	var wendy *accts.AccountInfo
+	all, _ := accounts.ReadAccounts(ctx)
+	for _, acct := range all {
+		if acct.Username == "wrenchwendy" {
+			wendy = acct
+		}
+	}
+	if _, fieldErr := accounts.SetRole(ctx, wendy.UserID, accts.RoleCustomer); fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+
+	if _, err := Load(ctx, accounts, invoices, fix); err != nil {
+		t.Fatal(err)
+	}
+	info, fieldErr := accounts.ReadAccountByID(ctx, wendy.UserID)
+	if fieldErr.ErrMsgs != nil {
+		t.Fatal(fieldErr.ErrMsgs)
+	}
+	if info[0].Role != accts.RoleStaff {
+		t.Errorf("wrenchwendy has role %q after seeding again, want %q", info[0].Role, accts.RoleStaff)
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	jsonFix := `{"users":[{"username":"tiretom","password":"Sunflower7","fname":"Tom","lname":"Tread",
+		"address":"12 Spoke St Tulsa OK 74103","invoices":[{"product":"Pump","category":"Tools","price":24.5,"quantity":1}]}]}`
+	yamlFix := `
+users:
+  - username: tiretom
+    password: Sunflower7
+    fname: Tom
+    lname: Tread
+    address: 12 Spoke St Tulsa OK 74103
+    invoices:
+      - {product: Pump, category: Tools, price: 24.5, quantity: 1}
+`
+	for name, data := range map[string]string{"users.json": jsonFix, "users.yml": yamlFix} {
+		fix, err := Parse([]byte(data), name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(fix.Users) != 1 || fix.Users[0].Username != "tiretom" || len(fix.Users[0].Invoices) != 1 ||
+			fix.Users[0].Invoices[0].Price != 24.5 {
+			t.Errorf("%s: parsed %+v", name, fix)
+		}
+	}
+
+	tests := []struct {
+		name, data, want string
+	}{
+		{"users.txt", jsonFix, "have to be .json"},
+		{"users.json", `{"users":[{"nickname":"tom"}]}`, "unknown field"},
+		{"users.yaml", "users:\n  - nickname: tom\n", "not found"},
+	}
+	for _, tc := range tests {
+		_, err := Parse([]byte(tc.data), tc.name)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error about %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidUsers(t *testing.T) {
+	accounts, invoices := newStores(t)
+	fix := &Fixture{Users: []User{{
+		Username: "shortpass",
+		Password: "abc",
+		Fname:    "Short",
+		Lname:    "Pass",
+		Address:  "1 Main St Tulsa OK 74103",
+	}}}
+	result, err := Load(context.Background(), accounts, invoices, fix)
+	if err == nil || !strings.Contains(err.Error(), "shortpass") {
+		t.Fatalf("got %v, want an error naming the user", err)
+	}
+	if result.UsersAdded != 0 {
+		t.Errorf("added %d users", result.UsersAdded)
+	}
+}
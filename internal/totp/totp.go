@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the parameters every code is generated with (RFC 6238 defaults)
+// authenticator apps assume these when the uri leaves them out
+const (
+	Digits = 6
+	Period = 30 // seconds a code stays current
+)
+
+var ErrSecret = errors.New("totp: secret isn't valid base32")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// returns a new random 160 bit secret, base32 encoded
+func NewSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// returns the time step a moment falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// returns the code for a time step (RFC 4226 section 5.3)
+func code(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, truncated%1000000)
+}
+
+// returns the code that's current at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Step(t)), nil
+}
+
+// checks a code against the steps within skew of now
+// and returns the step it matched, so callers can refuse
+// a code that was already used
+func Validate(secret, given string, now time.Time, skew int) (int64, bool) {
+	key, err := decode(secret)
+	if err != nil || len(given) != Digits {
+		return 0, false
+	}
+
+	current := Step(now)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, current+i)), []byte(given)) == 1 {
+			return current + i, true
+		}
+	}
+	return 0, false
+}
+
+// returns the otpauth:// uri authenticator apps enroll a secret from
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// decodes a secret, ignoring case, spaces and padding
+// since people copy them out of apps by hand
+func decode(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrSecret
+	}
+	return key, nil
+}
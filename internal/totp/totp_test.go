@@ -0,0 +1,68 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the sha1 vectors from RFC 6238 appendix B, cut down to six digits
+func TestCodeMatchesRFC(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := Code(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateAllowsSkew(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	previous, _ := Code(secret, now.Add(-Period*time.Second))
+
+	step, ok := Validate(secret, previous, now, 1)
+	if !ok || step != Step(now)-1 {
+		t.Errorf("step = %d ok = %v, want the previous step to match", step, ok)
+	}
+	if _, ok := Validate(secret, previous, now, 0); ok {
+		t.Error("the previous code matched without any skew")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Error("a code with too few digits matched")
+	}
+	if _, ok := Validate("not base32!", previous, now, 1); ok {
+		t.Error("a code matched a bad secret")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("conch", "bike rider", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/conch:bike%20rider?") {
+		t.Fatalf("uri = %s", uri)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "conch" || query.Get("digits") != "6" {
+		t.Errorf("query = %v", query)
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/config"
 	"github.com/ScriptMang/conch/internal/fields"
 	"github.com/ScriptMang/conch/internal/invs"
 	"github.com/gin-gonic/gin"
@@ -41,11 +45,24 @@ type rsltInv struct {
 	Quantity int
 }
 
-var code int //httpstatuscode
 const statusOK = 200
 const statusCreated = 201
 
-var btokens []accts.Tokens // bearer token
+// the stores every handler reads and writes through
+// main fills it with the Postgres stores, tests with in-memory ones
+type Server struct {
+	accounts accts.AccountStore
+	tokens   accts.TokenStore
+	invoices invs.InvoiceStore
+	ping     func(ctx context.Context) error // checks the database can be reached, nil when there's none
+}
+
+// returns a Server for the given stores
+// ping can be nil when there's no database to check on
+func NewServer(accounts accts.AccountStore, tokens accts.TokenStore, invoices invs.InvoiceStore,
+	ping func(ctx context.Context) error) *Server {
+	return &Server{accounts: accounts, tokens: tokens, invoices: invoices, ping: ping}
+}
 
 // configs gin router and renders index-page
 func setRouter() *gin.Engine {
@@ -53,6 +70,22 @@ func setRouter() *gin.Engine {
 	return r
 }
 
+// gives each request a context that ends after the route's configured timeout
+// the context is also cancelled when the client disconnects
+func withDeadline(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := cfg.TimeoutFor(c.Request.Method, c.FullPath())
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
 // assigns an int to an error message that's meant to be modified
 func chosenErrorMsg(errMsg string) int {
 	// assign the val of 1 for json wrong datatype error
@@ -80,6 +113,13 @@ func validateInvoiceBinding(c *gin.Context, rqstData *respBodyData) (invs.Invoic
 		return inv, true
 	}
 
+	// name the offending field when the body had the wrong datatype
+	var field string
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(bindingErr, &typeErr) {
+		field = typeErr.Field
+	}
+
 	err := bindingErr.Error()
 	var editedErrMsg string
 	errMsgChoice := chosenErrorMsg(err)
@@ -97,19 +137,19 @@ func validateInvoiceBinding(c *gin.Context, rqstData *respBodyData) (invs.Invoic
 		}
 		edit5 := strings.Join(wordLst, " ") + temp
 		editedErrMsg = editErrMsg(edit5, "  ", " ")
-		rqstData.FieldErr.AddMsg(fields.BadRequest, editedErrMsg)
+		rqstData.FieldErr.AddMsg(fields.BadRequest, field, fields.CodeInvalidType, editedErrMsg)
 	case 2:
 		edit := editErrMsg(err, "invalid", "Error: invalid")
 		editedErrMsg = editErrMsg(edit,
 			"' looking for beginning of value",
 			"', value must be wrapped in double quotes")
-		rqstData.FieldErr.AddMsg(fields.BadRequest, editedErrMsg)
+		rqstData.FieldErr.AddMsg(fields.BadRequest, field, fields.CodeMalformedJSON, editedErrMsg)
 	default:
 		editedErrMsg = editErrMsg(err, "invalid", "Error: invalid")
-		rqstData.FieldErr.AddMsg(fields.BadRequest, editedErrMsg)
+		rqstData.FieldErr.AddMsg(fields.BadRequest, field, fields.CodeInvalidBody, editedErrMsg)
 	}
 
-	c.AbortWithStatusJSON(fields.ErrorCode, rqstData.FieldErr)
+	sendProblem(c, rqstData.FieldErr)
 	return inv, false
 }
 
@@ -117,11 +157,26 @@ func validateInvoiceBinding(c *gin.Context, rqstData *respBodyData) (invs.Invoic
 func validateRouteInvID(c *gin.Context, rqstData *respBodyData) int {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		rqstData.FieldErr.AddMsg(fields.BadRequest, "Bad Request: invoice id can't be converted to an integer")
+		rqstData.FieldErr.AddMsg(fields.BadRequest, "id", fields.CodeInvalidID, "Bad Request: invoice id can't be converted to an integer")
 	}
 	return id
 }
 
+// serialize a GrammarError as an RFC 7807 problem to the response body
+// and stop any remaining handlers from running
+func sendProblem(c *gin.Context, fieldErr fields.GrammarError) {
+	c.Header("Content-Type", fields.ProblemContentType)
+	prob := fieldErr.Problem()
+	c.AbortWithStatusJSON(prob.Status, prob)
+}
+
+// answers the request with a 401 problem
+func sendUnauthorized(c *gin.Context, detail string) {
+	var authErr fields.GrammarError
+	authErr.AddMsg(http.StatusUnauthorized, "", fields.CodeUnauthorized, detail)
+	sendProblem(c, authErr)
+}
+
 // serialize Invoice or GrammarError as json to response body
 func sendResponse(c *gin.Context, rqstData *respBodyData) {
 	invs := rqstData.Invs
@@ -129,7 +184,7 @@ func sendResponse(c *gin.Context, rqstData *respBodyData) {
 	fieldErr := rqstData.FieldErr
 	switch {
 	case fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "":
-		c.JSON(fields.ErrorCode, fieldErr)
+		sendProblem(c, fieldErr)
 	default:
 
 		var receipt Order
@@ -154,31 +209,36 @@ func sendResponse(c *gin.Context, rqstData *respBodyData) {
 				receipts = append(receipts, receipt)
 			}
 		}
-		c.JSON(code, receipts)
+		c.JSON(statusOK, receipts)
 	}
 }
 
 // post request to create user account
-func createAcct(r *gin.Engine) *gin.Engine {
+func (srv *Server) createAcct(r *gin.Engine) *gin.Engine {
 	r.POST("/users", func(c *gin.Context) {
 		var acct accts.Account
 		var acctErr fields.GrammarError
 		var acctStatus *accts.Registered
 		err := c.ShouldBind(&acct)
 		if err != nil {
-			acctErr.AddMsg(fields.BadRequest,
+			var field string
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				field = typeErr.Field
+			}
+			acctErr.AddMsg(fields.BadRequest, field, fields.CodeInvalidType,
 				"Binding Error: failed to bind fields to account object, mismatched data-types")
-			c.JSON(fields.ErrorCode, acctErr)
+			sendProblem(c, acctErr)
 			return
 		}
 
 		// validate account info
-		acctStatus, acctErr = accts.AddAccount(&acct)
+		acctStatus, acctErr = srv.accounts.AddAccount(c.Request.Context(), &acct)
 		// send response back
 		errMsgSize := len(acctErr.ErrMsgs)
 		switch {
 		case errMsgSize > 0:
-			c.JSON(fields.ErrorCode, acctErr)
+			sendProblem(c, acctErr)
 		default:
 			c.JSON(statusOK, *acctStatus)
 		}
@@ -186,27 +246,109 @@ func createAcct(r *gin.Engine) *gin.Engine {
 	return r
 }
 
-func logIn(c *gin.Context) {
-	var username, ok = c.MustGet(gin.AuthUserKey).(string)
+// checks the basic-auth credentials against the accounts in the database
+// and issues a token to any registered user whose password matches
+func (srv *Server) logIn(c *gin.Context) {
+	username, password, ok := c.Request.BasicAuth()
 	if !ok {
-		log.Fatalf("Couldn't Get user's username from AUTHUserKey\n")
+		c.Header("WWW-Authenticate", `Basic realm="conch"`)
+		sendUnauthorized(c, "Unauthorized: login requires a username and password with basic auth")
+		return
 	}
+
 	var fieldErr fields.GrammarError
-	token := accts.GenerateToken(username, &fieldErr)
+	ip := c.ClientIP()
+	// the attempt is counted as a failure before the password is checked
+	attempt, retryAfter := srv.accounts.BeginLogin(c.Request.Context(), username, ip, &fieldErr)
 	if fieldErr.ErrMsgs != nil {
-		c.JSON(accts.BadRequest, gin.H{
-			"TokenError": fieldErr.ErrMsgs,
-		})
+		if retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+		sendProblem(c, fieldErr)
 		return
 	}
+	// takes the count back for an attempt that didn't fail
+	// the login is answered the same even if that fails
+	forgive := func() {
+		var countErr fields.GrammarError
+		srv.accounts.ForgiveLogin(c.Request.Context(), attempt, &countErr)
+	}
 
-	btokens = append(btokens, token)
-	c.JSON(http.StatusAccepted, gin.H{
-		"token": string(token.Token),
-	})
+	userID := srv.accounts.Authenticate(c.Request.Context(), username, password, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		if fieldErr.Status == http.StatusUnauthorized {
+			c.Header("WWW-Authenticate", `Basic realm="conch"`)
+		} else {
+			forgive()
+		}
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	// a missing code isn't counted as a failure, clients
+	// find out two-factor login is enabled by leaving it out
+	totpCode := c.GetHeader("X-TOTP-Code")
+	srv.accounts.VerifySecondFactor(c.Request.Context(), userID, totpCode, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		if fieldErr.Status != http.StatusUnauthorized || totpCode == "" {
+			forgive()
+		}
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	forgive()
+	var clearErr fields.GrammarError
+	srv.accounts.ClearLoginFailures(c.Request.Context(), username, &clearErr)
+
+	device := accts.Device{UserAgent: c.Request.UserAgent(), IPAddress: c.ClientIP()}
+	token := srv.tokens.GenerateToken(c.Request.Context(), userID, device, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, tokenResponse(token))
 }
 
-func logOut(c *gin.Context) {
+// the json sent back whenever a token is issued
+func tokenResponse(token accts.Tokens) gin.H {
+	return gin.H{
+		"token":              string(token.Token),
+		"token_type":         "Bearer",
+		"expires_at":         token.ExpiresAt,
+		"refresh_token":      token.RefreshToken,
+		"refresh_expires_at": token.RefreshExpiresAt,
+	}
+}
+
+// meant to be binded to a refresh request
+type refreshRqst struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+// trades a refresh token for a new token pair
+// the refresh token can't be used again afterwards
+func (srv *Server) refresh(c *gin.Context) {
+	var rqst refreshRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil || rqst.RefreshToken == "" {
+		fieldErr.AddMsg(fields.BadRequest, "refresh_token", fields.CodeRequired,
+			"Error: refresh_token can't be empty")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	token := srv.tokens.RefreshToken(c.Request.Context(), rqst.RefreshToken, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokenResponse(token))
+}
+
+func (srv *Server) logOut(c *gin.Context) {
 
 	if c.Keys["isAuthorized"] == false {
 		return
@@ -215,27 +357,21 @@ func logOut(c *gin.Context) {
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	var fieldErr fields.GrammarError
 	userID := c.Keys["rqstTokenUserID"].(int)
-	username := accts.ReadUsernameByID(userID, &fieldErr)
+	username := srv.accounts.ReadUsernameByID(c.Request.Context(), userID, &fieldErr)
 	if fieldErr.ErrMsgs != nil {
-		c.JSON(accts.BadRequest, gin.H{
-			"Error": fieldErr.ErrMsgs,
-		})
+		sendProblem(c, fieldErr)
 		return
 	}
 
-	accts.LogOut(userID, &fieldErr)
+	srv.tokens.LogOut(c.Request.Context(), c.Keys["rqstToken"].(string), &fieldErr)
 	if fieldErr.ErrMsgs != nil {
-		c.JSON(accts.BadRequest, gin.H{
-			"TokenError": fieldErr.ErrMsgs,
-		})
+		sendProblem(c, fieldErr)
 		return
 	}
 
@@ -245,7 +381,7 @@ func logOut(c *gin.Context) {
 
 }
 
-func deleteAcct(c *gin.Context) {
+func (srv *Server) deleteAcct(c *gin.Context) {
 
 	if c.Keys["isAuthorized"] == false {
 		return
@@ -257,72 +393,129 @@ func deleteAcct(c *gin.Context) {
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	var fieldErr fields.GrammarError
 	userID := c.Keys["rqstTokenUserID"].(int)
-	user := accts.ReadUsernameByID(userID, &fieldErr)
+	user := srv.accounts.ReadUsernameByID(c.Request.Context(), userID, &fieldErr)
 	if fieldErr.ErrMsgs != nil {
-		c.JSON(accts.BadRequest, gin.H{
-			"Error": fieldErr.ErrMsgs,
-		})
+		sendProblem(c, fieldErr)
 		return
 	}
 
-	rmvUser, rqstData.FieldErr = accts.DeleteAcct(*user[0])
+	rmvUser, rqstData.FieldErr = srv.accounts.DeleteAcct(c.Request.Context(), *user[0])
 	if rqstData.FieldErr.ErrMsgs != nil {
 		sendResponse(c, &rqstData)
 		return
 	}
 
-	code = statusOK
-	c.JSON(code, gin.H{
+	c.JSON(statusOK, gin.H{
 		"message": fmt.Sprintf("User: %s has been deleted", rmvUser[0].Username),
 	})
 }
 
-func protectData(c *gin.Context) {
+func (srv *Server) protectData(c *gin.Context) {
 	c.Keys = make(map[string]any)
+	if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+		srv.protectWithAPIKey(c, apiKey)
+		return
+	}
+
 	bToken := c.Request.Header.Get("Authorization")
-	if bToken == "" {
+	scheme, rqstToken, found := strings.Cut(bToken, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || rqstToken == "" {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	// get the userid for the inputted token
 	var fieldErr fields.GrammarError
-	rqstToken := strings.Split(bToken, " ")[1]
-	rqstTokenUserID := accts.ReadUserIDByToken(rqstToken, &fieldErr)
+	rqstTokenUser := srv.tokens.ReadUserByToken(c.Request.Context(), rqstToken, &fieldErr)
 
 	// return the error retrieving the token
 	if fieldErr.ErrMsgs != nil {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"TokenError": fieldErr.ErrMsgs,
-		})
+		sendProblem(c, fieldErr)
 		return
 	}
-	c.Keys["rqstTokenUserID"] = rqstTokenUserID
+	c.Keys["rqstTokenUserID"] = rqstTokenUser.UserID
+	c.Keys["role"] = rqstTokenUser.Role
+	c.Keys["rqstToken"] = rqstToken
+	c.Keys["isAuthorized"] = true
+
+	// the token is already known to be valid, so failing to
+	// record its use doesn't stop the request
+	srv.tokens.TouchToken(c.Request.Context(), rqstToken, &fieldErr)
+}
 
-	for _, token := range btokens {
-		dbTknUserID := accts.ReadUserIDByToken(string(token.Token), &fieldErr)
-		if dbTknUserID == rqstTokenUserID {
-			c.Keys["isAuthorized"] = true
-			return
-		}
+// the routes an api key can use and the scope each one needs
+// routes that aren't listed, like managing sessions or keys, need a token
+var apiKeyRoutes = map[string]string{
+	"GET /users":          accts.ScopeAccountRead,
+	"GET /user":           accts.ScopeAccountRead,
+	"GET /invoices":       accts.ScopeInvoicesRead,
+	"GET /user/invoices":  accts.ScopeInvoicesRead,
+	"GET /invoice/:id":    accts.ScopeInvoicesRead,
+	"POST /invoices/":     accts.ScopeInvoicesWrite,
+	"PUT /invoice/:id":    accts.ScopeInvoicesWrite,
+	"PATCH /invoice/:id":  accts.ScopeInvoicesWrite,
+	"DELETE /invoice/:id": accts.ScopeInvoicesWrite,
+}
+
+// authorizes a request made with a personal api key
+// the key has to have the scope the route needs
+func (srv *Server) protectWithAPIKey(c *gin.Context, apiKey string) {
+	var fieldErr fields.GrammarError
+	keyUser := srv.tokens.ReadUserByAPIKey(c.Request.Context(), apiKey, &fieldErr)
+	if fieldErr.ErrMsgs != nil {
+		c.Keys["isAuthorized"] = false
+		sendProblem(c, fieldErr)
+		return
 	}
 
-	c.Keys["isAuthorized"] = false
-	c.JSON(http.StatusUnauthorized, gin.H{
-		"message": "unauthorized",
-	})
+	scope, ok := apiKeyRoutes[c.Request.Method+" "+c.FullPath()]
+	switch {
+	case !ok:
+		fieldErr.AddMsg(fields.Forbidden, "", fields.CodeForbidden,
+			"Forbidden: api keys can't use this route, log in for a token instead")
+	case !keyUser.HasScope(scope):
+		fieldErr.AddMsg(fields.Forbidden, "", fields.CodeMissingScope,
+			"Forbidden: api key needs the "+scope+" scope to use this route")
+	}
+	if fieldErr.ErrMsgs != nil {
+		c.Keys["isAuthorized"] = false
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	c.Keys["rqstTokenUserID"] = keyUser.UserID
+	c.Keys["role"] = keyUser.Role
+	c.Keys["rqstToken"] = ""
+	c.Keys["isAuthorized"] = true
+
+	// like a token, failing to record the key's use doesn't stop the request
+	srv.tokens.TouchAPIKey(c.Request.Context(), keyUser.KeyID, &fieldErr)
+}
+
+// only lets users with one of the given roles through
+// must come after protectData in the route's handlers
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Keys["role"].(string)
+		for _, allowed := range roles {
+			if role == allowed {
+				return
+			}
+		}
+
+		var fieldErr fields.GrammarError
+		fieldErr.AddMsg(fields.Forbidden, "", fields.CodeForbidden,
+			"Forbidden: your role isn't allowed to use this route")
+		sendProblem(c, fieldErr)
+	}
 }
 
 // takes an invoice create new invoice struct w/o user_id
@@ -338,7 +531,7 @@ func editedInv(inv invs.Invoice) rsltInv {
 }
 
 // binds json data to an invoice and insert its to the database
-func addInvoice(c *gin.Context) {
+func (srv *Server) addInvoice(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
@@ -351,16 +544,14 @@ func addInvoice(c *gin.Context) {
 	// invoice binding must match along with their userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	if bindingOk {
 		var fieldErr fields.GrammarError
 		inv.UserID = c.Keys["rqstTokenUserID"].(int)
-		rqstData.Invs, fieldErr = invs.InsertOp(inv)
+		rqstData.Invs, fieldErr = srv.invoices.InsertOp(c.Request.Context(), inv)
 		// fmt.Printf("Invoice after InsertOP is: %+v\n", *rqstData.Invs[0])
 		// fmt.Printf("FieldErrs after InsertOP is: %v\n", fieldErr.ErrMsgs)
 		if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
@@ -368,32 +559,30 @@ func addInvoice(c *gin.Context) {
 			sendResponse(c, &rqstData)
 			return
 		}
-		code = statusCreated
 		inv2 := *rqstData.Invs[0]
 		rslt := editedInv(inv2)
-		c.JSON(code, rslt)
+		c.JSON(statusCreated, rslt)
 	}
 }
 
 // returns the list of all users
-func readUserData(c *gin.Context) {
+func (srv *Server) readUserData(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
 	var rqstData respBodyData
-	rqstData.UsrContacts, rqstData.FieldErr = accts.ReadUserContact()
+	rqstData.UsrContacts, rqstData.FieldErr = srv.accounts.ReadUserContact(c.Request.Context())
 	fieldErr := rqstData.FieldErr
 	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
 		// log.Printf("Error in ReadUserData funct: %v\n", fieldErr.ErrMsgs)
 		sendResponse(c, &rqstData)
 		return
 	}
-	code = statusOK
-	c.JSON(code, rqstData.UsrContacts)
+	c.JSON(statusOK, rqstData.UsrContacts)
 }
 
 // returns a user given its id
-func readUserDataByID(c *gin.Context) {
+func (srv *Server) readUserDataByID(c *gin.Context) {
 	// verify first whether the token exist in the databse
 	if c.Keys["isAuthorized"] == false {
 		return
@@ -402,43 +591,61 @@ func readUserDataByID(c *gin.Context) {
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	var rqstData respBodyData
 	id := c.Keys["rqstTokenUserID"].(int)
-	rqstData.UsrContacts, rqstData.FieldErr = accts.ReadUserContactByID(id)
+	rqstData.UsrContacts, rqstData.FieldErr = srv.accounts.ReadUserContactByID(c.Request.Context(), id)
 	fieldErr := rqstData.FieldErr
 	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
 		// fmt.Printf("readUserInovices funct: error is %s\n", fieldErr.ErrMsgs[0])
 		sendResponse(c, &rqstData)
 		return
 	}
-	code = statusOK
-	c.JSON(code, *rqstData.UsrContacts[0])
+	c.JSON(statusOK, *rqstData.UsrContacts[0])
+}
+
+// a page of invoices, the json both invoice lists answer with
+// next is left out on the last page
+type invoicePage struct {
+	Invoices any    `json:"invoices"`
+	Total    int    `json:"total"`
+	Next     string `json:"next,omitempty"`
 }
 
-// // returns all the invoices within the database
-func readInvoiceData(c *gin.Context) {
+// reads the list's query parameters and fetches the page they ask for
+// userID limits it to a user's invoices, 0 lists every user's.
+// the page is nil when an error was sent
+func (srv *Server) listInvoices(c *gin.Context, userID int) *invs.Page {
+	q, fieldErr := invs.ParseListQuery(c.Request.URL.Query())
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return nil
+	}
+	page, fieldErr := srv.invoices.ListInvoices(c.Request.Context(), userID, q)
+	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
+		sendProblem(c, fieldErr)
+		return nil
+	}
+	return page
+}
+
+// returns a page of the invoices within the database
+func (srv *Server) readInvoiceData(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
-	var rqstData respBodyData
-	rqstData.Invs, rqstData.FieldErr = invs.ReadInvoices()
-	fieldErr := rqstData.FieldErr
-	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
-		sendResponse(c, &rqstData)
+	page := srv.listInvoices(c, 0)
+	if page == nil {
 		return
 	}
-	code = statusOK
-	c.JSON(code, rqstData.Invs)
+	c.JSON(statusOK, invoicePage{Invoices: page.Invoices, Total: page.Total, Next: page.Next})
 }
 
-// returns all the invoices for a given user
-func readUserInvoices(c *gin.Context) {
+// returns a page of the invoices for a given user
+func (srv *Server) readUserInvoices(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
@@ -446,70 +653,63 @@ func readUserInvoices(c *gin.Context) {
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
-	var rqstData respBodyData
 	id := c.Keys["rqstTokenUserID"].(int)
-	rqstData.Invs, rqstData.FieldErr = invs.ReadInvoicesByUserID(id)
-	fieldErr := rqstData.FieldErr
-	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
-		// fmt.Printf("readUserInovices funct: error is %s\n", fieldErr.ErrMsgs[0])
-		sendResponse(c, &rqstData)
+	page := srv.listInvoices(c, id)
+	if page == nil {
 		return
 	}
 
-	code = statusOK
-	invLst := rqstData.Invs
-	var editedInvLst []*rsltInv
-	for _, tmpInv := range invLst {
+	editedInvLst := []*rsltInv{}
+	for _, tmpInv := range page.Invoices {
 		rslt := editedInv(*tmpInv)
 		editedInvLst = append(editedInvLst, &rslt)
 	}
 
-	c.JSON(code, editedInvLst)
+	c.JSON(statusOK, invoicePage{Invoices: editedInvLst, Total: page.Total, Next: page.Next})
 }
 
 // returns a specific invoice for a specific user
 // given the user id and invoice id
-func readUserInvoiceByID(c *gin.Context) {
+func (srv *Server) readUserInvoiceByID(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
 
 	var rqstData respBodyData
 	invID := validateRouteInvID(c, &rqstData)
+	if rqstData.FieldErr.ErrMsgs != nil {
+		sendProblem(c, rqstData.FieldErr)
+		return
+	}
 
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	userID := c.Keys["rqstTokenUserID"].(int)
-	rqstData.Invs, rqstData.FieldErr = invs.ReadInvoiceByUserID(userID, invID)
+	rqstData.Invs, rqstData.FieldErr = srv.invoices.ReadInvoiceByUserID(c.Request.Context(), userID, invID)
 	fieldErr := rqstData.FieldErr
 	if fieldErr.ErrMsgs != nil && fieldErr.ErrMsgs[0] != "" {
 		// fmt.Printf("readUserInovices funct: error is %s\n", fieldErr.ErrMsgs[0])
 		sendResponse(c, &rqstData)
 		return
 	}
-	code = statusOK
 	inv := *rqstData.Invs[0]
 	rslt := editedInv(inv)
-	c.JSON(code, rslt)
+	c.JSON(statusOK, rslt)
 }
 
 // updates an invoice entry by id
 // require the user to pass the entire invoice
 // to change any field
-func updateInvoiceEntry(c *gin.Context) {
+func (srv *Server) updateInvoiceEntry(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
@@ -518,13 +718,15 @@ func updateInvoiceEntry(c *gin.Context) {
 
 	var rqstData respBodyData
 	invID := validateRouteInvID(c, &rqstData)
+	if rqstData.FieldErr.ErrMsgs != nil {
+		sendProblem(c, rqstData.FieldErr)
+		return
+	}
 
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
@@ -532,21 +734,20 @@ func updateInvoiceEntry(c *gin.Context) {
 	if bindingOk {
 
 		userID := c.Keys["rqstTokenUserID"].(int)
-		rqstData.Invs, rqstData.FieldErr = invs.UpdateInvoiceByUserID(inv, userID, invID)
+		rqstData.Invs, rqstData.FieldErr = srv.invoices.UpdateInvoiceByUserID(c.Request.Context(), inv, userID, invID)
 		if rqstData.FieldErr.ErrMsgs != nil {
 			sendResponse(c, &rqstData)
 			return
 		}
-		code = statusOK
 		inv2 := *rqstData.Invs[0]
 		rslt := editedInv(inv2)
-		c.JSON(code, rslt)
+		c.JSON(statusOK, rslt)
 	}
 }
 
 // similar to the updateEntry except you don't have
 // to pass all the fields in a invoice to update a field
-func patchEntry(c *gin.Context) {
+func (srv *Server) patchEntry(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
@@ -555,110 +756,599 @@ func patchEntry(c *gin.Context) {
 
 	var rqstData respBodyData
 	invID := validateRouteInvID(c, &rqstData)
+	if rqstData.FieldErr.ErrMsgs != nil {
+		sendProblem(c, rqstData.FieldErr)
+		return
+	}
 
 	// verify that the userID assigned to the token matches the route's userID
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	inv, bindingOk = validateInvoiceBinding(c, &rqstData)
 	if bindingOk {
 		userID := c.Keys["rqstTokenUserID"].(int)
-		rqstData.Invs, rqstData.FieldErr = invs.PatchInvoice(inv, userID, invID)
+		rqstData.Invs, rqstData.FieldErr = srv.invoices.PatchInvoice(c.Request.Context(), inv, userID, invID)
 		if rqstData.FieldErr.ErrMsgs != nil {
 			sendResponse(c, &rqstData)
 			return
 		}
-		code = statusOK
 		inv2 := *rqstData.Invs[0]
 		rslt := editedInv(inv2)
-		c.JSON(code, rslt)
+		c.JSON(statusOK, rslt)
+	}
+}
+
+// meant to be binded to a password change
+type passwordRqst struct {
+	CurrentPassword string `json:"current_password" form:"current_password"`
+	NewPassword     string `json:"new_password" form:"new_password"`
+}
+
+// meant to be binded to a password reset
+type resetRqst struct {
+	Username    string `json:"username" form:"username"`
+	Token       string `json:"token" form:"token"`
+	NewPassword string `json:"new_password" form:"new_password"`
+}
+
+// adds a required msg for each field that's empty
+// each pair holds a field's name and its value
+func requireFields(fieldErr *fields.GrammarError, pairs ...[2]string) {
+	for _, pair := range pairs {
+		if pair[1] == "" {
+			fieldErr.AddMsg(fields.BadRequest, pair[0], fields.CodeRequired, "Error: "+pair[0]+" can't be empty")
+		}
+	}
+}
+
+// changes the user's password given their current one
+// the user's other sessions are logged out
+func (srv *Server) changePassword(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var rqst passwordRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "", fields.CodeInvalidBody,
+			"Binding Error: current_password and new_password must be strings")
+		sendProblem(c, fieldErr)
+		return
+	}
+	requireFields(&fieldErr,
+		[2]string{"current_password", rqst.CurrentPassword},
+		[2]string{"new_password", rqst.NewPassword},
+	)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	fieldErr = srv.accounts.ChangePassword(c.Request.Context(), userID, c.Keys["rqstToken"].(string),
+		rqst.CurrentPassword, rqst.NewPassword)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
 	}
+	c.JSON(statusOK, gin.H{
+		"message": "password changed, your other sessions have been logged out",
+	})
+}
+
+// sends a password reset token to the user
+// it's answered the same way whether or not the username exists
+func (srv *Server) requestPasswordReset(c *gin.Context) {
+	var rqst resetRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil || rqst.Username == "" {
+		fieldErr.AddMsg(fields.BadRequest, "username", fields.CodeRequired, "Error: username can't be empty")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	fieldErr = srv.accounts.RequestPasswordReset(c.Request.Context(), rqst.Username, c.ClientIP())
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "if the account exists a reset token has been sent to it",
+	})
+}
+
+// sets a new password with a reset token
+func (srv *Server) confirmPasswordReset(c *gin.Context) {
+	var rqst resetRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "", fields.CodeInvalidBody,
+			"Binding Error: token and new_password must be strings")
+		sendProblem(c, fieldErr)
+		return
+	}
+	requireFields(&fieldErr,
+		[2]string{"token", rqst.Token},
+		[2]string{"new_password", rqst.NewPassword},
+	)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	fieldErr = srv.accounts.ResetPassword(c.Request.Context(), rqst.Token, rqst.NewPassword)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, gin.H{
+		"message": "password reset, log in with your new password",
+	})
+}
+
+// lists every session the user is logged in with
+func (srv *Server) readSessions(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	sessions, fieldErr := srv.tokens.ReadSessions(c.Request.Context(), userID, c.Keys["rqstToken"].(string))
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, sessions)
+}
+
+// logs out one of the user's sessions by its id
+// revoking the current session works the same as logging out
+func (srv *Server) revokeSession(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var fieldErr fields.GrammarError
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "id", fields.CodeInvalidID, "Bad Request: session id can't be converted to an integer")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	sessions, fieldErr := srv.tokens.RevokeSession(c.Request.Context(), userID, sessionID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, sessions[0])
+}
+
+// replaces or patches the user's own profile
+// PUT needs every field, PATCH keeps the ones left out
+func (srv *Server) editProfile(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var edit accts.ProfileEdit
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&edit); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "", fields.CodeInvalidBody,
+			"Binding Error: fname, lname, address and username must be strings")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	var accounts []*accts.AccountInfo
+	if c.Request.Method == http.MethodPatch {
+		accounts, fieldErr = srv.accounts.PatchProfile(c.Request.Context(), userID, edit)
+	} else {
+		accounts, fieldErr = srv.accounts.UpdateProfile(c.Request.Context(), userID, edit)
+	}
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, accounts[0])
+}
+
+// reports the rules new passwords have to follow
+// so clients can check a password before sending it
+func (srv *Server) readPasswordPolicy(c *gin.Context) {
+	c.JSON(statusOK, srv.accounts.PasswordPolicy())
+}
+
+// the code from an authenticator app
+type totpRqst struct {
+	Code string `json:"code" form:"code"`
+}
+
+// the password confirming two-factor login should be turned off
+type disableTOTPRqst struct {
+	Password string `json:"password" form:"password"`
+}
+
+// starts enrolling the user in two-factor login
+func (srv *Server) enrollTOTP(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	enrollment, fieldErr := srv.accounts.EnrollTOTP(c.Request.Context(), userID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, enrollment[0])
+}
+
+// turns on two-factor login once the user sends a code from their app
+func (srv *Server) confirmTOTP(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var rqst totpRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "code", fields.CodeInvalidBody, "Binding Error: code must be a string")
+		sendProblem(c, fieldErr)
+		return
+	}
+	requireFields(&fieldErr, [2]string{"code", rqst.Code})
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	codes, fieldErr := srv.accounts.ConfirmTOTP(c.Request.Context(), userID, rqst.Code)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, gin.H{
+		"message":        "two-factor login enabled, store the recovery codes somewhere safe, they won't be shown again",
+		"recovery_codes": codes,
+	})
+}
+
+// turns off two-factor login after the user confirms their password
+func (srv *Server) disableTOTP(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var rqst disableTOTPRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "password", fields.CodeInvalidBody, "Binding Error: password must be a string")
+		sendProblem(c, fieldErr)
+		return
+	}
+	requireFields(&fieldErr, [2]string{"password", rqst.Password})
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	fieldErr = srv.accounts.DisableTOTP(c.Request.Context(), userID, rqst.Password)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, gin.H{
+		"message": "two-factor login disabled",
+	})
+}
+
+// the name and scopes of a new api key
+type apiKeyRqst struct {
+	Name   string   `json:"name" form:"name"`
+	Scopes []string `json:"scopes" form:"scopes"`
+}
+
+// creates a personal api key, the key is only shown in this response
+func (srv *Server) createAPIKey(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var rqst apiKeyRqst
+	var fieldErr fields.GrammarError
+	if err := c.ShouldBind(&rqst); err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "", fields.CodeInvalidBody,
+			"Binding Error: name must be a string and scopes a list of strings")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	keys, fieldErr := srv.tokens.CreateAPIKey(c.Request.Context(), userID, rqst.Name, rqst.Scopes)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(http.StatusCreated, keys[0])
+}
+
+// lists the user's api keys without the keys themselves
+func (srv *Server) readAPIKeys(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	keys, fieldErr := srv.tokens.ReadAPIKeys(c.Request.Context(), userID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, keys)
+}
+
+// revokes one of the user's api keys
+func (srv *Server) revokeAPIKey(c *gin.Context) {
+	if c.Keys["isAuthorized"] == false {
+		return
+	}
+
+	var fieldErr fields.GrammarError
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		fieldErr.AddMsg(fields.BadRequest, "id", fields.CodeInvalidID, "Bad Request: api key id can't be converted to an integer")
+		sendProblem(c, fieldErr)
+		return
+	}
+
+	if c.Keys["rqstTokenUserID"] == 0 {
+		c.Keys["isAuthorized"] = false
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
+		return
+	}
+
+	userID := c.Keys["rqstTokenUserID"].(int)
+	keys, fieldErr := srv.tokens.RevokeAPIKey(c.Request.Context(), userID, keyID)
+	if fieldErr.ErrMsgs != nil {
+		sendProblem(c, fieldErr)
+		return
+	}
+	c.JSON(statusOK, keys[0])
 }
 
 // deletes an invoice entry based on id
-func deleteInvEntry(c *gin.Context) {
+func (srv *Server) deleteInvEntry(c *gin.Context) {
 	if c.Keys["isAuthorized"] == false {
 		return
 	}
 
 	var rqstData respBodyData
 	invID := validateRouteInvID(c, &rqstData)
+	if rqstData.FieldErr.ErrMsgs != nil {
+		sendProblem(c, rqstData.FieldErr)
+		return
+	}
 
 	if c.Keys["rqstTokenUserID"] == 0 {
 		c.Keys["isAuthorized"] = false
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"message": "unauthorized",
-		})
+		sendUnauthorized(c, "Unauthorized: a valid bearer token is required")
 		return
 	}
 
 	userID := c.Keys["rqstTokenUserID"].(int)
-	rqstData.Invs, rqstData.FieldErr = invs.DeleteInvoice(invID, userID)
+	rqstData.Invs, rqstData.FieldErr = srv.invoices.DeleteInvoice(c.Request.Context(), invID, userID)
 	if rqstData.FieldErr.ErrMsgs != nil {
 		sendResponse(c, &rqstData)
 		return
 	}
-	code = statusOK
 	inv := *rqstData.Invs[0]
 	rslt := editedInv(inv)
-	c.JSON(code, rslt)
+	c.JSON(statusOK, rslt)
 }
 
-func main() {
+// reports whether the database pool can still reach postgres
+func (srv *Server) healthCheck(c *gin.Context) {
+	if srv.ping != nil {
+		if err := srv.ping(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "unavailable",
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// builds the router with every route
+// the handlers read and write through the stores in srv
+func NewRouter(cfg *config.Config, srv *Server) *gin.Engine {
 	r := setRouter()
-	r = createAcct(r)
+	// gin trusts X-Forwarded-For from anyone by default, which would let
+	// a client pick the ip its failed logins are counted against
+	if len(cfg.TrustedProxies) == 0 || r.SetTrustedProxies(cfg.TrustedProxies) != nil {
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(withDeadline(cfg))
+	r.GET("/healthz", srv.healthCheck)
+	r = srv.createAcct(r)
+
+	r.POST("/login", srv.logIn)
+	r.POST("/refresh", srv.refresh)
+	r.GET("/password/policy", srv.readPasswordPolicy)
+	r.POST("/password/reset", srv.requestPasswordReset)
+	r.POST("/password/reset/confirm", srv.confirmPasswordReset)
+
+	userGroup1 := r.Group("/", srv.protectData)
+	{
+		userGroup1.GET("/users", requireRole(accts.RoleStaff, accts.RoleAdmin), srv.readUserData)
+		userGroup1.GET("/invoices", requireRole(accts.RoleStaff, accts.RoleAdmin), srv.readInvoiceData)
+		userGroup1.DELETE("/users", srv.deleteAcct)
+		userGroup1.POST("/logout", srv.logOut)
+	}
 
-	isHashUnreadable := false
-	const hash_unreadable = "Couldn't read password hash"
-	pswd1, readErr := accts.ReadHashByID(1)
-	if readErr.ErrMsgs != nil {
-		isHashUnreadable = true
-		fmt.Fprintf(os.Stderr, hash_unreadable+" 1\n")
+	createInv := r.Group("/invoices/", srv.protectData)
+	{
+		createInv.POST("", srv.addInvoice)
 	}
 
-	pswd2, readErr := accts.ReadHashByID(2)
-	if readErr.ErrMsgs != nil {
-		isHashUnreadable = true
-		fmt.Fprintf(os.Stderr, hash_unreadable+" 2\n")
+	userGroup2 := r.Group("/", srv.protectData)
+	{
+		userGroup2.GET("/user", srv.readUserDataByID)             // read user by their id
+		userGroup2.PUT("/user", srv.editProfile)                  // replaces the user's profile
+		userGroup2.PATCH("/user", srv.editProfile)                // updates any field of the user's profile
+		userGroup2.GET("/user/invoices", srv.readUserInvoices)    // read all the invoices for a user
+		userGroup2.GET("/invoice/:id", srv.readUserInvoiceByID)   // read a specific invoice from a user
+		userGroup2.PUT("/invoice/:id", srv.updateInvoiceEntry)    // updates the entire invoice
+		userGroup2.PATCH("/invoice/:id", srv.patchEntry)          // updates any field of an invoice
+		userGroup2.DELETE("/invoice/:id", srv.deleteInvEntry)     // deletes a specific invoice
+		userGroup2.GET("/sessions", srv.readSessions)             // lists the user's sessions
+		userGroup2.DELETE("/sessions/:id", srv.revokeSession)     // logs out one of the user's sessions
+		userGroup2.PUT("/user/password", srv.changePassword)      // changes the user's password
+		userGroup2.POST("/user/2fa", srv.enrollTOTP)              // starts enrolling in two-factor login
+		userGroup2.POST("/user/2fa/confirm", srv.confirmTOTP)     // turns on two-factor login
+		userGroup2.DELETE("/user/2fa", srv.disableTOTP)           // turns off two-factor login
+		userGroup2.POST("/user/api-keys", srv.createAPIKey)       // creates a personal api key
+		userGroup2.GET("/user/api-keys", srv.readAPIKeys)         // lists the user's api keys
+		userGroup2.DELETE("/user/api-keys/:id", srv.revokeAPIKey) // revokes one of the user's api keys
 	}
 
-	if !isHashUnreadable {
-		loginRoute := r.Group("/", gin.BasicAuth(gin.Accounts{
-			"wrigglyWart56": string(pswd1[0].Password),
-			"hypnoTonic05":  string(pswd2[0].Password),
-		}))
-		{
-			loginRoute.POST("/login", logIn)
-		}
+	srv.adminRoutes(r)
+	return r
+}
 
-		userGroup1 := r.Group("/", protectData)
-		{
-			userGroup1.GET("/users", readUserData)
-			userGroup1.GET("/invoices", readInvoiceData)
-			userGroup1.DELETE("/users", deleteAcct)
-			userGroup1.POST("/logout", logOut)
-		}
+// serves the router with the listen address, timeouts and tls from cfg
+func serve(r *gin.Engine, cfg *config.Config) error {
+	srv := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      r,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+	}
+	if cfg.UseTLS() {
+		return srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	}
+	return srv.ListenAndServe()
+}
 
-		createInv := r.Group("/invoices/", protectData)
-		{
-			createInv.POST("", addInvoice)
-		}
+func main() {
+	os.Exit(run())
+}
+
+// runs the subcommand or server os.Args asks for and returns the exit code
+// kept apart from main so its deferred calls run before the process exits
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return runMigrate(os.Args[2:], os.Stdout, os.Stderr)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		return runSeed(os.Args[2:], os.Stdout, os.Stderr)
+	}
+
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
 
-		userGroup2 := r.Group("/", protectData)
-		{
-			userGroup2.GET("/user", readUserDataByID)           // read user by their id
-			userGroup2.GET("/user/invoices", readUserInvoices)  // read all the invoices for a user
-			userGroup2.GET("/invoice/:id", readUserInvoiceByID) // read a specific invoice from a user
-			userGroup2.PUT("/invoice/:id", updateInvoiceEntry)  // updates the entire invoice
-			userGroup2.PATCH("/invoice/:id", patchEntry)        // updates any field of an invoice
-			userGroup2.DELETE("/invoice/:id", deleteInvEntry)   // deletes a specific invoice
+	store, err := bikeshop.Open(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer store.Close()
+	if cfg.MigrateOnStart {
+		if err := migrateOnStart(context.Background(), store); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
 		}
 	}
+	accounts, err := accts.NewPostgres(cfg, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	defer accounts.Wait()
+	if cfg.TokenMode == config.TokenModeJWT {
+		if err := accounts.LoadRevocations(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "loading revoked tokens: %v\n", err)
+			return 1
+		}
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		go accounts.WatchRevocations(watchCtx, cfg.RevocationRefresh.Duration)
+	}
 
-	r.Run()
+	srv := NewServer(accounts, accounts, invs.NewPostgres(store, accounts), store.Ping)
+	if err := serve(NewRouter(cfg, srv), cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
 }
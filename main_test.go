@@ -3,72 +3,213 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 
-	db "github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/invs"
+	"github.com/gin-gonic/gin"
 	assert_v2 "github.com/go-playground/assert/v2"
 )
 
-func TestReadInvoice(t *testing.T) {
-	r := setRouter()
-	r = readData(r)
-	w := httptest.NewRecorder()
-	var s string
-	req, err := http.NewRequest("GET", "/crud2", strings.NewReader(s))
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	os.Exit(m.Run())
+}
 
+// returns a router whose stores live in memory, so the tests
+// need no database and each one starts from empty tables
+func newTestRouter(t *testing.T) (*gin.Engine, *accts.Memory) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.BcryptCost = 4 // the cheapest cost bcrypt allows keeps the tests fast
+	return newTestRouterWith(t, cfg)
+}
+
+// like newTestRouter but with the given config
+func newTestRouterWith(t *testing.T, cfg *config.Config) (*gin.Engine, *accts.Memory) {
+	t.Helper()
+	accounts, err := accts.NewMemory(cfg)
 	if err != nil {
-		t.Fatalf("Error:\n %v\n", err)
+		t.Fatal(err)
 	}
+	srv := NewServer(accounts, accounts, invs.NewMemory(accounts), nil)
+	return NewRouter(cfg, srv), accounts
+}
 
+// sends a request to the router and returns what it wrote
+func serveJSON(r *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("content-type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	r.ServeHTTP(w, req)
-	expectedData := `{"fname": "Dante", "lname": "Ferges", "product": "Safety Goggles", "price": 15.99 , "quantity": 3, "category": "Safety Equipment", "shipping": "423 Elm St, Chicago IL 60629"},{"fname": "Michael", "lname": "Wither", "product": "Lubricant", "price": 11.99, "quantity": 1, "category": "Maintenance", "shipping": "230 Furginson Rd, Oklahoma OK 731303"},{"fname": Georgei, "lname": "Ventalin", "product": "Door Hinges", "price": 12.50, "quantity": 5, "category": "Home Improvement", "shipping": "495 Durvington Ave, Topeka KS 66603"},{"fname": "Edart", "lname": "Muskrat", "product": "Wrench", "price": 24.99, "quantity": 1, "category": "Plumbing", "shipping": "134 Pluton St, Boston MA 02108"},{"fname": "Abra", "lname": "Katern", "product": "DiscoBall", "price": 19.99, "quantity": 6, "category": "Party", "shipping": "829 Sherbet St, Portland ME 04102"},{"fname": Charles, "lname": "Tarly", "product": "Zombie book", "price": 14.99, "quantity": 2, "category": "Fiction", "shipping": "134 Pluton St, Boston MA 02108"} `
+	return w
+}
 
-	rslt := w.Body.String()
-	assert_v2.Equal(t, rslt, expectedData)
+var johnny = accts.Account{
+	Fname:    "Johnny",
+	Lname:    "TwoTap",
+	Address:  "578 Bingus Ave Moeberry OK 71203",
+	Username: "johnnytwotap",
+	Password: "Sunflower7",
+}
 
+// registers the account and returns the token it's issued at login
+func signUp(t *testing.T, r *gin.Engine, acct accts.Account) string {
+	t.Helper()
+	body, err := json.Marshal(acct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serveJSON(r, "POST", "/users", "", string(body)); w.Code != http.StatusOK {
+		t.Fatalf("POST /users: got %d %s", w.Code, w.Body)
+	}
+	return logIn(t, r, acct)
+}
+
+// logs in with basic auth and returns the token
+func logIn(t *testing.T, r *gin.Engine, acct accts.Account) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.SetBasicAuth(acct.Username, acct.Password)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /login: got %d %s", w.Code, w.Body)
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &login); err != nil {
+		t.Fatal(err)
+	}
+	return login.Token
 }
 
 // test the creation of an invoice
-// requires the table to empty and serial sequence to be reset to 1.
 func TestPostInvoice(t *testing.T) {
-	r := setRouter()
-	r = addInvoice(r)
-	w := httptest.NewRecorder()
-	inv := db.Invoice{
-		ID:       0,
-		Fname:    "Johnny",
-		Lname:    "TwoTap",
-		Product:  "Peashooter",
-		Price:    20.00,
-		Quantity: 1,
-		Category: "Toy",
-		Shipping: "578 Bingus Ave, Moeberry OK 71203",
+	r, _ := newTestRouter(t)
+	token := signUp(t, r, johnny)
+
+	w := serveJSON(r, "POST", "/invoices/", token, `{"product":"Peashooter","category":"Toy","price":20,"quantity":1}`)
+	assert_v2.Equal(t, w.Code, http.StatusCreated)
+	assert_v2.Equal(t, w.Body.String(), `{"ID":1,"Product":"Peashooter","Category":"Toy","Price":20.00,"Quantity":1}`)
+}
+
+func TestReadInvoice(t *testing.T) {
+	r, _ := newTestRouter(t)
+	token := signUp(t, r, johnny)
+
+	w := serveJSON(r, "GET", "/user/invoices", token, "")
+	assert_v2.Equal(t, w.Code, http.StatusOK)
+	assert_v2.Equal(t, w.Body.String(), `{"invoices":[],"total":0}`)
+
+	serveJSON(r, "POST", "/invoices/", token, `{"product":"Safety Goggles","category":"Safety Equipment","price":15.99,"quantity":3}`)
+	serveJSON(r, "POST", "/invoices/", token, `{"product":"Door Hinges","category":"Home Improvement","price":12.5,"quantity":5}`)
+
+	w = serveJSON(r, "GET", "/user/invoices", token, "")
+	assert_v2.Equal(t, w.Code, http.StatusOK)
+	assert_v2.Equal(t, w.Body.String(), `{"invoices":[{"ID":1,"Product":"Safety Goggles","Category":"Safety Equipment","Price":15.99,"Quantity":3},{"ID":2,"Product":"Door Hinges","Category":"Home Improvement","Price":12.50,"Quantity":5}],"total":2}`)
+}
+
+// fires requests that fail in different ways at once, each one has to be
+// answered with its own status. run with -race to catch any shared state
+func TestConcurrentRequestsGetTheirOwnStatus(t *testing.T) {
+	r, _ := newTestRouter(t)
+	token := signUp(t, r, johnny)
+
+	cases := []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"POST", "/invoices/", token, `{"product":"Peashooter","category":"Toy","price":20,"quantity":1}`, http.StatusCreated},
+		{"POST", "/invoices/", token, `{"product":"Peashooter!","category":"Toy4","price":-3,"quantity":0}`, http.StatusBadRequest},
+		{"POST", "/invoices/", "", `{"product":"Peashooter","category":"Toy","price":20,"quantity":1}`, http.StatusUnauthorized},
+		{"GET", "/invoice/999999", token, "", http.StatusNotFound},
+		{"GET", "/invoice/abc", token, "", http.StatusBadRequest},
+		{"GET", "/user", "not-a-token", "", http.StatusUnauthorized},
 	}
 
-	sampleData, err := json.Marshal(inv)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to Encode  Object into json: %v\n", err)
-		os.Exit(1)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, tc := range cases {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := serveJSON(r, tc.method, tc.path, tc.token, tc.body)
+				if w.Code != tc.want {
+					t.Errorf("%s %s: got %d, want %d: %s", tc.method, tc.path, w.Code, tc.want, w.Body)
+				}
+			}()
+		}
 	}
-	fmt.Printf("Encoding: %s\n", string(sampleData))
+	wg.Wait()
+}
 
-	req, err := http.NewRequest("POST", "/crud1", strings.NewReader(string(sampleData)))
-	req.Header.Set("content-type", "application/json")
+// a client can't reset the failures counted against its ip
+// by sending a different X-Forwarded-For with each guess
+func TestForgedForwardedForIsIgnored(t *testing.T) {
+	cfg := config.Default()
+	cfg.BcryptCost = 4
+	cfg.LoginBackoff.Duration = 0
+	cfg.LockoutIPThreshold = 3
+	r, _ := newTestRouterWith(t, cfg)
 
-	if err != nil {
-		t.Fatalf("Error_v1:\n %v\n", err)
+	guess := func(i int) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/login", nil)
+		// a new username each time so only the ip's count can lock it
+		req.SetBasicAuth(fmt.Sprintf("guesser%d", i), "Sunflower7")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		r.ServeHTTP(w, req)
+		return w.Code
 	}
+	for i := 1; i <= cfg.LockoutIPThreshold; i++ {
+		if code := guess(i); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: got %d, want %d", i, code, http.StatusUnauthorized)
+		}
+	}
+	if code := guess(99); code != http.StatusTooManyRequests {
+		t.Errorf("after %d failures from one peer: got %d, want the ip locked out with %d",
+			cfg.LockoutIPThreshold, code, http.StatusTooManyRequests)
+	}
+}
 
-	r.ServeHTTP(w, req)
-
-	expectedData := `{"id":1,"fname":"Johnny","lname":"TwoTap","product":"Peashooter","price":20,"quantity":1,"category":"Toy","shipping":"578 Bingus Ave, Moeberry OK 71203"}`
+// parallel wrong guesses can't all get past the backoff
+// before any of them is counted
+func TestParallelGuessesAreCountedAtOnce(t *testing.T) {
+	r, _ := newTestRouter(t)
+	signUp(t, r, johnny)
 
-	rslt := w.Body.String()
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/login", nil)
+			req.SetBasicAuth(johnny.Username, "Wrong"+johnny.Password)
+			r.ServeHTTP(w, req)
+			mu.Lock()
+			codes[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
 
-	assert_v2.Equal(t, w.Code, http.StatusCreated)
-	assert_v2.Equal(t, rslt, expectedData)
+	if codes[http.StatusUnauthorized] != 1 || codes[http.StatusTooManyRequests] != 19 {
+		t.Errorf("got %v, want one guess checked and the rest told to wait", codes)
+	}
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/migrate"
+)
+
+const migrateUsage = "usage: conch migrate up|down|status [flags]"
+
+// runs `conch migrate up|down|status` and returns the exit code
+// it takes the same flags as the server, though only the database ones matter
+func runMigrate(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, migrateUsage)
+		return 2
+	}
+	cmd := args[0]
+	if cmd != "up" && cmd != "down" && cmd != "status" {
+		fmt.Fprintf(stderr, "unknown migrate command %q\n%s\n", cmd, migrateUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+	ctx := context.Background()
+	store, err := bikeshop.Open(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	switch cmd {
+	case "up":
+		applied, err := migrate.Up(ctx, store)
+		for _, m := range applied {
+			fmt.Fprintf(stdout, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(stdout, "the schema is up to date")
+		}
+
+	case "down":
+		m, err := migrate.Down(ctx, store)
+		if errors.Is(err, migrate.ErrNothingApplied) {
+			fmt.Fprintln(stdout, "there are no migrations to undo")
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "undid %04d_%s\n", m.Version, m.Name)
+
+	case "status":
+		statuses, err := migrate.Statuses(ctx, store)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+	}
+	return 0
+}
+
+// applies pending migrations when the server is started with migrate_on_start
+func migrateOnStart(ctx context.Context, store *bikeshop.Store) error {
+	applied, err := migrate.Up(ctx, store)
+	for _, m := range applied {
+		fmt.Fprintf(os.Stderr, "applied migration %04d_%s\n", m.Version, m.Name)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ScriptMang/conch/internal/accts"
+	"github.com/ScriptMang/conch/internal/bikeshop"
+	"github.com/ScriptMang/conch/internal/config"
+	"github.com/ScriptMang/conch/internal/invs"
+	"github.com/ScriptMang/conch/internal/seed"
+)
+
+// runs `conch seed [file] [flags]` and returns the exit code
+// without a file it loads the built-in demo bike shop
+func runSeed(args []string, stdout, stderr io.Writer) int {
+	var fix *seed.Fixture
+	var err error
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		fix, err = seed.ReadFile(args[0])
+		args = args[1:]
+	} else {
+		fix, err = seed.Demo()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+
+	cfg, err := config.Load(args, os.Getenv)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+	ctx := context.Background()
+	store, err := bikeshop.Open(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	defer store.Close()
+	accounts, err := accts.NewPostgres(cfg, store)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+
+	result, err := seed.Load(ctx, accounts, invs.NewPostgres(store, accounts), fix)
+	fmt.Fprintf(stdout, "users: %d added, %d already there\n", result.UsersAdded, result.UsersFound)
+	fmt.Fprintf(stdout, "invoices: %d added, %d already there\n", result.InvoicesAdded, result.InvoicesFound)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}